@@ -1,7 +1,59 @@
 package authenticationcontroller
 
+import (
+	"context"
+	"errors"
+
+	"github.com/clawio/entities"
+)
+
+// MethodPassword is the authentication method of controllers
+// that verify passwords, as defined in RFC 8176.
+const MethodPassword = "pwd"
+
+var (
+	// ErrUserNotFound is returned by Authenticate when
+	// the controller does not know the user.
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrInvalidPassword is returned by Authenticate when the user
+	// exists but the password does not match or can not be used.
+	ErrInvalidPassword = errors.New("password does not match")
+)
+
+// Result is what an AuthenticationController
+// knows about an authenticated user.
+type Result struct {
+	// User is the verified user.
+	User *entities.User
+
+	// Method is how the user was authenticated, an
+	// authentication method reference of RFC 8176.
+	Method string
+
+	// Factors are the additional factors the user must present
+	// before being signed in, empty when the password is enough.
+	Factors []string
+}
+
 // AuthenticationController defines an interface to
-// grant users access to other services.
+// verify the credentials of users. Tokens are issued
+// by the service from the result.
 type AuthenticationController interface {
-	Authenticate(username, password string) (string, error)
+	// Authenticate verifies the credentials of the user.
+	// The controller stops when the context is done.
+	Authenticate(ctx context.Context, username, password string) (*Result, error)
+}
+
+// ErrPasswordChangeNotSupported is returned by ChangePassword
+// when the user belongs to a controller that can not change it.
+var ErrPasswordChangeNotSupported = errors.New("password can not be changed")
+
+// PasswordChanger is implemented by the controllers
+// whose users can change their own password.
+type PasswordChanger interface {
+	// ChangePassword replaces the password of the user once the current
+	// one is verified, returning the same errors as Authenticate. The
+	// new password is expected to be checked against the policy already.
+	ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error
 }
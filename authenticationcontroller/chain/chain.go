@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+)
+
+// Supported policies.
+const (
+	// FirstSuccess tries the controllers in order until one
+	// accepts the credentials, whatever the others answered.
+	FirstSuccess = "first-success"
+
+	// FirstAuthoritative stops at the first controller that knows the
+	// user: only authenticationcontroller.ErrUserNotFound moves to the
+	// next one. A wrong password or an unavailable controller ends the
+	// login, so a stale account further down the chain can not be used
+	// while the controller that owns the user is down.
+	FirstAuthoritative = "first-authoritative"
+)
+
+// DefaultPolicy is the policy used when none is configured.
+const DefaultPolicy = FirstAuthoritative
+
+// Logger logs the outcome of every hop.
+// It is satisfied by the standard and logrus loggers.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// Hop is a controller of the chain. Only usernames matching
+// Pattern are sent to the controller, nil matches every username.
+type Hop struct {
+	Name       string
+	Pattern    *regexp.Regexp
+	Controller authenticationcontroller.AuthenticationController
+}
+
+// Options holds the configuration
+// parameters used by the controller.
+type Options struct {
+	Hops   []*Hop
+	Policy string
+	Log    Logger
+}
+
+type controller struct {
+	hops   []*Hop
+	policy string
+	log    Logger
+}
+
+// New returns an AuthenticationController that composes other
+// controllers, tried in order with the configured policy. The result
+// is the one of the controller that accepted the credentials.
+func New(opts *Options) (authenticationcontroller.AuthenticationController, error) {
+	if len(opts.Hops) == 0 {
+		return nil, errors.New("chain has no controllers")
+	}
+	for _, h := range opts.Hops {
+		if h.Name == "" || h.Controller == nil {
+			return nil, errors.New("chain controllers must have a name and a controller")
+		}
+	}
+	policy := opts.Policy
+	if policy == "" {
+		policy = DefaultPolicy
+	}
+	if policy != FirstSuccess && policy != FirstAuthoritative {
+		return nil, errors.New("chain policy " + policy + " is not supported")
+	}
+	return &controller{
+		hops:   opts.Hops,
+		policy: policy,
+		log:    opts.Log,
+	}, nil
+}
+
+// Authenticate returns the result of the first controller that accepts
+// the credentials. When none does, the error is ErrInvalidPassword if a
+// controller knew the user, the last unexpected error if any, and
+// ErrUserNotFound otherwise. The chain stops when the context is done.
+func (c *controller) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
+	_, res, err := c.authenticate(ctx, username, password)
+	return res, err
+}
+
+// ChangePassword changes the password in the controller that accepts the
+// current password, found as Authenticate does, which verifies it again.
+// ErrPasswordChangeNotSupported is returned if that controller is not an
+// authenticationcontroller.PasswordChanger.
+func (c *controller) ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error {
+	h, _, err := c.authenticate(ctx, username, currentPassword)
+	if err != nil {
+		return err
+	}
+	changer, ok := h.Controller.(authenticationcontroller.PasswordChanger)
+	if !ok {
+		return authenticationcontroller.ErrPasswordChangeNotSupported
+	}
+	return changer.ChangePassword(ctx, username, currentPassword, newPassword)
+}
+
+// authenticate returns the hop that accepted the credentials and its result.
+func (c *controller) authenticate(ctx context.Context, username, password string) (*Hop, *authenticationcontroller.Result, error) {
+	var result error = authenticationcontroller.ErrUserNotFound
+	for _, h := range c.hops {
+		if h.Pattern != nil && !h.Pattern.MatchString(username) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		res, err := h.Controller.Authenticate(ctx, username, password)
+		c.logf("chain: %s: user %q: %s", h.Name, username, outcome(err))
+		if err == nil {
+			return h, res, nil
+		}
+		if err == authenticationcontroller.ErrUserNotFound {
+			continue
+		}
+		if c.policy == FirstAuthoritative {
+			return nil, nil, err
+		}
+		if result != authenticationcontroller.ErrInvalidPassword {
+			result = err
+		}
+	}
+	return nil, nil, result
+}
+
+func (c *controller) logf(format string, args ...interface{}) {
+	if c.log != nil {
+		c.log.Printf(format, args...)
+	}
+}
+
+func outcome(err error) string {
+	switch err {
+	case nil:
+		return "authenticated"
+	case authenticationcontroller.ErrUserNotFound:
+		return "unknown user"
+	case authenticationcontroller.ErrInvalidPassword:
+		return "wrong password"
+	case authenticationcontroller.ErrUserDisabled:
+		return "disabled user"
+	default:
+		return "error: " + err.Error()
+	}
+}
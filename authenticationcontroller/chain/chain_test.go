@@ -0,0 +1,250 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/authenticationcontroller/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// unavailable is a controller whose backend is down.
+type unavailable struct{}
+
+func (unavailable) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
+	return nil, errors.New("connection refused")
+}
+
+// changer is a controller whose users can change their password.
+type changer struct {
+	authenticationcontroller.AuthenticationController
+	changed []string
+}
+
+func (c *changer) ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error {
+	c.changed = append(c.changed, username+":"+newPassword)
+	return nil
+}
+
+type logger struct {
+	lines []string
+}
+
+func (l *logger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+type TestSuite struct {
+	suite.Suite
+	ldap authenticationcontroller.AuthenticationController
+	sql  authenticationcontroller.AuthenticationController
+	log  *logger
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	ldap, err := memory.New(&memory.Options{
+		Users: []*memory.User{
+			{User: &entities.User{Username: "jdoe", Email: "jdoe@ldap"}, Password: "ldappwd"},
+		},
+	})
+	require.Nil(suite.T(), err)
+	sql, err := memory.New(&memory.Options{
+		Users: []*memory.User{
+			{User: &entities.User{Username: "jdoe", Email: "jdoe@sql"}, Password: "sqlpwd"},
+			{User: &entities.User{Username: "legacy"}, Password: "sqlpwd"},
+		},
+	})
+	require.Nil(suite.T(), err)
+	suite.ldap = ldap
+	suite.sql = sql
+	suite.log = &logger{}
+}
+
+func (suite *TestSuite) newController(policy string, hops ...*Hop) authenticationcontroller.AuthenticationController {
+	c, err := New(&Options{Hops: hops, Policy: policy, Log: suite.log})
+	require.Nil(suite.T(), err)
+	return c
+}
+
+func (suite *TestSuite) requireEmail(res *authenticationcontroller.Result, email string) {
+	require.Equal(suite.T(), email, res.User.Email)
+}
+
+func (suite *TestSuite) TestNew() {
+	c, err := New(&Options{Hops: []*Hop{{Name: "ldap", Controller: suite.ldap}}})
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), DefaultPolicy, c.(*controller).policy)
+}
+func (suite *TestSuite) TestNew_withoutHops() {
+	_, err := New(&Options{})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadHop() {
+	_, err := New(&Options{Hops: []*Hop{{Name: "ldap"}}})
+	require.NotNil(suite.T(), err)
+	_, err = New(&Options{Hops: []*Hop{{Controller: suite.ldap}}})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadPolicy() {
+	_, err := New(&Options{Hops: []*Hop{{Name: "ldap", Controller: suite.ldap}}, Policy: "random"})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withFirstAuthoritative() {
+	c := suite.newController(FirstAuthoritative,
+		&Hop{Name: "ldap", Controller: suite.ldap},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	res, err := c.Authenticate(context.Background(), "jdoe", "ldappwd")
+	require.Nil(suite.T(), err)
+	suite.requireEmail(res, "jdoe@ldap")
+
+	// users not migrated yet are found in the next controller.
+	res, err = c.Authenticate(context.Background(), "legacy", "sqlpwd")
+	require.Nil(suite.T(), err)
+	suite.requireEmail(res, "")
+
+	// the old password is not accepted once the user is in ldap.
+	_, err = c.Authenticate(context.Background(), "jdoe", "sqlpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+
+	_, err = c.Authenticate(context.Background(), "unknown", "sqlpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestAuthenticate_withFirstAuthoritativeAndUnavailable() {
+	c := suite.newController(FirstAuthoritative,
+		&Hop{Name: "ldap", Controller: unavailable{}},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	_, err := c.Authenticate(context.Background(), "jdoe", "sqlpwd")
+	require.NotNil(suite.T(), err)
+	require.Equal(suite.T(), "connection refused", err.Error())
+}
+func (suite *TestSuite) TestAuthenticate_withFirstSuccess() {
+	c := suite.newController(FirstSuccess,
+		&Hop{Name: "ldap", Controller: suite.ldap},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	res, err := c.Authenticate(context.Background(), "jdoe", "sqlpwd")
+	require.Nil(suite.T(), err)
+	suite.requireEmail(res, "jdoe@sql")
+	res, err = c.Authenticate(context.Background(), "jdoe", "ldappwd")
+	require.Nil(suite.T(), err)
+	suite.requireEmail(res, "jdoe@ldap")
+
+	_, err = c.Authenticate(context.Background(), "jdoe", "otherpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	_, err = c.Authenticate(context.Background(), "unknown", "otherpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestAuthenticate_withFirstSuccessAndUnavailable() {
+	c := suite.newController(FirstSuccess,
+		&Hop{Name: "ldap", Controller: unavailable{}},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	_, err := c.Authenticate(context.Background(), "jdoe", "sqlpwd")
+	require.Nil(suite.T(), err)
+
+	// a wrong password is reported rather than the unavailable controller.
+	_, err = c.Authenticate(context.Background(), "jdoe", "otherpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	_, err = c.Authenticate(context.Background(), "unknown", "otherpwd")
+	require.Equal(suite.T(), "connection refused", err.Error())
+}
+func (suite *TestSuite) TestAuthenticate_withPattern() {
+	c := suite.newController(FirstAuthoritative,
+		&Hop{Name: "sql", Pattern: regexp.MustCompile("^legacy$"), Controller: suite.sql},
+		&Hop{Name: "ldap", Pattern: regexp.MustCompile("^j"), Controller: suite.ldap},
+	)
+	_, err := c.Authenticate(context.Background(), "legacy", "sqlpwd")
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "ldappwd")
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "sqlpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	require.Equal(suite.T(), []string{
+		`chain: sql: user "legacy": authenticated`,
+		`chain: ldap: user "jdoe": authenticated`,
+		`chain: ldap: user "jdoe": wrong password`,
+	}, suite.log.lines)
+
+	// no controller matches.
+	_, err = c.Authenticate(context.Background(), "unknown", "sqlpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+	require.Len(suite.T(), suite.log.lines, 3)
+}
+func (suite *TestSuite) TestAuthenticate_withLog() {
+	c := suite.newController(FirstSuccess,
+		&Hop{Name: "down", Controller: unavailable{}},
+		&Hop{Name: "ldap", Controller: suite.ldap},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	_, err := c.Authenticate(context.Background(), "legacy", "sqlpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), []string{
+		`chain: down: user "legacy": error: connection refused`,
+		`chain: ldap: user "legacy": unknown user`,
+		`chain: sql: user "legacy": authenticated`,
+	}, suite.log.lines)
+}
+func (suite *TestSuite) TestAuthenticate_withCanceledContext() {
+	c := suite.newController(FirstSuccess,
+		&Hop{Name: "ldap", Controller: suite.ldap},
+		&Hop{Name: "sql", Controller: suite.sql},
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.Authenticate(ctx, "legacy", "sqlpwd")
+	require.Equal(suite.T(), context.Canceled, err)
+	require.Empty(suite.T(), suite.log.lines)
+}
+func (suite *TestSuite) TestNewFromConfig() {
+	c, err := newFromConfig(json.RawMessage(`{
+		"Policy": "first-success",
+		"Hops": [{"Name": "memory", "Pattern": "^j", "Type": "memory", "Config": {"Users": [
+			{"username": "jdoe", "password": "$2a$04$De314cF4i52YR6Ybpbx5r.kF3b9t56JnOYpXwQ0CGmyXlwmXALlpG"}
+		]}}]
+	}`))
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), FirstSuccess, c.(*controller).policy)
+	res, err := c.Authenticate(context.Background(), "jdoe", "hashed")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "jdoe", res.User.Username)
+}
+func (suite *TestSuite) TestNewFromConfig_withBadHop() {
+	for _, hops := range []string{
+		`[{"Name": "memory"}]`,
+		`[{"Name": "memory", "Pattern": "(", "Type": "memory"}]`,
+		`[{"Name": "other", "Type": "other"}]`,
+		`[{"Name": "memory", "Type": "memory", "Config": {"Unknown": true}}]`,
+	} {
+		_, err := newFromConfig(json.RawMessage(`{"Hops": ` + hops + `}`))
+		require.NotNil(suite.T(), err, hops)
+	}
+}
+func (suite *TestSuite) TestChangePassword() {
+	sql := &changer{AuthenticationController: suite.sql}
+	c := suite.newController(FirstSuccess,
+		&Hop{Name: "ldap", Controller: suite.ldap},
+		&Hop{Name: "sql", Controller: sql},
+	)
+	changer := c.(authenticationcontroller.PasswordChanger)
+	require.Nil(suite.T(), changer.ChangePassword(context.Background(), "jdoe", "sqlpwd", "newpwd"))
+	require.Equal(suite.T(), []string{"jdoe:newpwd"}, sql.changed)
+
+	// the password of ldap users can not be changed.
+	err := changer.ChangePassword(context.Background(), "jdoe", "ldappwd", "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrPasswordChangeNotSupported, err)
+	err = changer.ChangePassword(context.Background(), "jdoe", "otherpwd", "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	require.Len(suite.T(), sql.changed, 1)
+}
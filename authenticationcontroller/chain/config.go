@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+)
+
+func init() {
+	authenticationcontroller.Register("chain", newFromConfig)
+}
+
+// Config is the configuration of the chain type. Policy is
+// first-authoritative or first-success, empty means DefaultPolicy.
+type Config struct {
+	Policy string
+	Hops   []*HopConfig
+}
+
+// HopConfig is the configuration of a controller of the chain,
+// created from the registry with its Type and Config. Only usernames
+// matching the Pattern regular expression are sent to the controller,
+// empty matches every username.
+type HopConfig struct {
+	Name    string
+	Pattern string
+	Type    string
+	Config  json.RawMessage
+}
+
+// newFromConfig logs with the standard logger, which
+// the server sends to its own log.
+func newFromConfig(data json.RawMessage) (authenticationcontroller.AuthenticationController, error) {
+	var cfg Config
+	if err := authenticationcontroller.DecodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	opts := &Options{
+		Policy: cfg.Policy,
+		Log:    log.Default(),
+	}
+	for _, hopCfg := range cfg.Hops {
+		if hopCfg.Type == "" {
+			return nil, errors.New("chain controller " + hopCfg.Name + " has no type")
+		}
+		hop := &Hop{Name: hopCfg.Name}
+		if hopCfg.Pattern != "" {
+			pattern, err := regexp.Compile(hopCfg.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			hop.Pattern = pattern
+		}
+		c, err := authenticationcontroller.New(hopCfg.Type, hopCfg.Config)
+		if err != nil {
+			return nil, err
+		}
+		hop.Controller = c
+		opts.Hops = append(opts.Hops, hop)
+	}
+	return New(opts)
+}
@@ -0,0 +1,34 @@
+package file
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+)
+
+func init() {
+	authenticationcontroller.Register("file", newFromConfig)
+}
+
+// Config is the configuration of the file type. Path is an htpasswd or
+// JSON lines user file, Format is htpasswd or jsonl, empty means guessed
+// from the extension. PollInterval is the time in seconds between checks
+// of the file.
+type Config struct {
+	Path         string
+	Format       string
+	PollInterval int
+}
+
+func newFromConfig(data json.RawMessage) (authenticationcontroller.AuthenticationController, error) {
+	var cfg Config
+	if err := authenticationcontroller.DecodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	return New(&Options{
+		Path:         cfg.Path,
+		Format:       cfg.Format,
+		PollInterval: time.Duration(cfg.PollInterval) * time.Second,
+	})
+}
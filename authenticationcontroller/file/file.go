@@ -0,0 +1,176 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/passwordhasher"
+	"github.com/clawio/entities"
+)
+
+// Supported file formats.
+const (
+	// Htpasswd is the Apache htpasswd format, one username:hash per line.
+	Htpasswd = "htpasswd"
+	// JSONLines is one JSON user per line, with username,
+	// email, display_name and password fields.
+	JSONLines = "jsonl"
+)
+
+// DefaultPollInterval is how often the file is checked for changes.
+const DefaultPollInterval = 2 * time.Second
+
+// User is an user with its password, an encoded hash in any format
+// supported by the passwordhasher package but plaintext.
+type User struct {
+	*entities.User
+	Password string `json:"password"`
+}
+
+// Options holds the configuration
+// parameters used by the controller.
+type Options struct {
+	// Path is the user file. Format is Htpasswd or JSONLines, empty
+	// means JSONLines for .jsonl and .json files and Htpasswd otherwise.
+	Path   string
+	Format string
+
+	// PollInterval is the minimum time between checks of the file,
+	// zero means DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+type controller struct {
+	path         string
+	format       string
+	pollInterval time.Duration
+	dummyHash    string
+	now          func() time.Time
+
+	mu        sync.Mutex
+	users     map[string]*User
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+// New returns an AuthenticationController that reads the users
+// from a file. The file is not watched: it is polled lazily on
+// login, at most once per PollInterval, and replaced as a whole once
+// the new version is parsed; a version that does not parse is logged
+// and ignored, and the previous one is kept. The file must be valid to start.
+func New(opts *Options) (authenticationcontroller.AuthenticationController, error) {
+	format := opts.Format
+	if format == "" {
+		format = Htpasswd
+		if ext := filepath.Ext(opts.Path); ext == ".jsonl" || ext == ".json" {
+			format = JSONLines
+		}
+	}
+	if format != Htpasswd && format != JSONLines {
+		return nil, errors.New("user file format " + format + " is not supported")
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	hasher, err := passwordhasher.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	// dummyHash is verified when the user does not exist
+	// so unknown usernames take as long as wrong passwords.
+	dummyHash, err := hasher.Hash("")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &controller{
+		path:         opts.Path,
+		format:       format,
+		pollInterval: interval,
+		dummyHash:    dummyHash,
+		now:          time.Now,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	c.checkedAt = c.now()
+	return c, nil
+}
+
+func (c *controller) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
+	u, ok := c.getUsers()[username]
+	if !ok {
+		passwordhasher.Verify(c.dummyHash, password)
+		return nil, authenticationcontroller.ErrUserNotFound
+	}
+	ok, err := passwordhasher.Verify(u.Password, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, authenticationcontroller.ErrInvalidPassword
+	}
+	return &authenticationcontroller.Result{User: u.User, Method: authenticationcontroller.MethodPassword}, nil
+}
+
+// getUsers returns the current users, reloading the file if it is
+// time to check it. The returned map is never modified.
+func (c *controller) getUsers() map[string]*User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if now.Sub(c.checkedAt) >= c.pollInterval {
+		c.checkedAt = now
+		if err := c.reload(); err != nil {
+			log.Printf("keeping the previous users: %s", err)
+			reloadsCounter.WithLabelValues("error").Inc()
+		}
+	}
+	return c.users
+}
+
+// reload parses the file if it changed since the last attempt and
+// replaces the users. The users are kept when the file can not be read
+// or parsed, and a version that failed is parsed again on the next check.
+func (c *controller) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	if c.users != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	// the file is being written, it is read again on the next check.
+	after, err := os.Stat(c.path)
+	if err != nil || !after.ModTime().Equal(info.ModTime()) || after.Size() != int64(len(data)) {
+		return errors.New("user file " + c.path + " changed while being read")
+	}
+
+	var users map[string]*User
+	if c.format == JSONLines {
+		users, err = parseJSONLines(data)
+	} else {
+		users, err = parseHtpasswd(data)
+	}
+	if err != nil {
+		return errors.New("user file " + c.path + ": " + err.Error())
+	}
+	c.users = users
+	c.modTime, c.size = info.ModTime(), info.Size()
+	reloadsCounter.WithLabelValues("success").Inc()
+	return nil
+}
@@ -0,0 +1,214 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// hashes of "testpwd", generated with openssl passwd -5 and -6,
+// and the bcrypt hash of "hashed".
+const (
+	sha256Hash = "$5$clawio$65luP3OLpetpCqF/OUAgIpSR8lmFFiFmj8agIqFaUL7"
+	sha512Hash = "$6$clawio$hej0CV67fVafT6DjPV/uQa/CtYCjJf.tle.YHSL9rc.696vtD7YP0oT795yTWxUun97PCQXWkiGVn88v7QEyi/"
+	bcryptHash = "$2a$04$De314cF4i52YR6Ybpbx5r.kF3b9t56JnOYpXwQ0CGmyXlwmXALlpG"
+)
+
+var htpasswd = "# users\n" +
+	"test:" + sha256Hash + "\n" +
+	"\n" +
+	"hugo:" + sha512Hash + "\r\n" +
+	"hashed:" + bcryptHash + "\n"
+
+var jsonLines = `{"username": "test", "email": "test@example.org", "display_name": "Test", "password": "` + sha256Hash + `"}
+{"username": "hashed", "password": "` + bcryptHash + `"}
+`
+
+type TestSuite struct {
+	suite.Suite
+	dir     string
+	modTime time.Time
+	now     time.Time
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-file")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+	suite.modTime = time.Now().Add(-time.Hour)
+	suite.now = time.Now()
+}
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+// write writes the file with a new modification time, so
+// the change is seen even if the size does not change.
+func (suite *TestSuite) write(name, data string) string {
+	path := filepath.Join(suite.dir, name)
+	require.Nil(suite.T(), ioutil.WriteFile(path, []byte(data), 0600))
+	suite.modTime = suite.modTime.Add(time.Second)
+	require.Nil(suite.T(), os.Chtimes(path, suite.modTime, suite.modTime))
+	return path
+}
+
+func (suite *TestSuite) newController(path string) *controller {
+	c, err := New(&Options{Path: path})
+	require.Nil(suite.T(), err)
+	c.(*controller).now = func() time.Time { return suite.now }
+	c.(*controller).checkedAt = suite.now
+	return c.(*controller)
+}
+
+func (suite *TestSuite) TestNew() {
+	c := suite.newController(suite.write("htpasswd", htpasswd))
+	require.Equal(suite.T(), Htpasswd, c.format)
+	require.Len(suite.T(), c.users, 3)
+	c = suite.newController(suite.write("users.jsonl", jsonLines))
+	require.Equal(suite.T(), JSONLines, c.format)
+	require.Len(suite.T(), c.users, 2)
+}
+func (suite *TestSuite) TestNew_withFormat() {
+	path := suite.write("users", jsonLines)
+	_, err := New(&Options{Path: path, Format: JSONLines})
+	require.Nil(suite.T(), err)
+	_, err = New(&Options{Path: path, Format: "csv"})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withMissingFile() {
+	_, err := New(&Options{Path: filepath.Join(suite.dir, "htpasswd")})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadFile() {
+	for _, data := range []string{
+		"test\n",
+		":" + bcryptHash + "\n",
+		"test:testpwd\n",
+		"test:{SHA}mDwOgpoEYIJ8xVcWEvmSNbkxL+0=\n",
+		"test:$apr1$clawio$iKs.mud4wnFFdNEYhdfM9.\n",
+		"test:" + bcryptHash + "\ntest:" + sha256Hash + "\n",
+	} {
+		_, err := New(&Options{Path: suite.write("htpasswd", data)})
+		require.NotNil(suite.T(), err, data)
+	}
+	for _, data := range []string{
+		`{"username": "test", "password": "` + sha256Hash,
+		`{"username": "test", "password": "testpwd"}`,
+		`{"password": "` + sha256Hash + `"}`,
+		`["test"]`,
+	} {
+		_, err := New(&Options{Path: suite.write("users.jsonl", data)})
+		require.NotNil(suite.T(), err, data)
+	}
+}
+func (suite *TestSuite) TestNew_withErrorLine() {
+	_, err := New(&Options{Path: suite.write("htpasswd", htpasswd+"hugo:"+bcryptHash+"\n")})
+	require.NotNil(suite.T(), err)
+	require.Contains(suite.T(), err.Error(), "line 6")
+}
+func (suite *TestSuite) TestAuthenticate() {
+	c := suite.newController(suite.write("htpasswd", htpasswd))
+	for _, username := range []string{"test", "hugo"} {
+		res, err := c.Authenticate(context.Background(), username, "testpwd")
+		require.Nil(suite.T(), err)
+		require.Equal(suite.T(), username, res.User.Username)
+	}
+	_, err := c.Authenticate(context.Background(), "hashed", "hashed")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withJSONLines() {
+	c := suite.newController(suite.write("users.jsonl", jsonLines))
+	res, err := c.Authenticate(context.Background(), "test", "testpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), &entities.User{Username: "test", Email: "test@example.org", DisplayName: "Test"}, res.User)
+}
+func (suite *TestSuite) TestAuthenticate_withBadPassword() {
+	c := suite.newController(suite.write("htpasswd", htpasswd))
+	_, err := c.Authenticate(context.Background(), "test", "hashed")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	_, err = c.Authenticate(context.Background(), "test", "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withUnknownUser() {
+	c := suite.newController(suite.write("htpasswd", htpasswd))
+	_, err := c.Authenticate(context.Background(), "notfound", "testpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+	_, err = c.Authenticate(context.Background(), "# users", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withChangedFile() {
+	path := suite.write("htpasswd", htpasswd)
+	c := suite.newController(path)
+	suite.write("htpasswd", "new:"+sha256Hash+"\n")
+
+	// the file is not checked before the poll interval.
+	_, err := c.Authenticate(context.Background(), "test", "testpwd")
+	require.Nil(suite.T(), err)
+
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err = c.Authenticate(context.Background(), "test", "testpwd")
+	require.NotNil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "new", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withBadChangedFile() {
+	path := suite.write("htpasswd", htpasswd)
+	c := suite.newController(path)
+	suite.write("htpasswd", "new:testpwd\n")
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err := c.Authenticate(context.Background(), "test", "testpwd")
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "new", "testpwd")
+	require.NotNil(suite.T(), err)
+
+	// the next good version is loaded.
+	suite.write("htpasswd", "new:"+sha256Hash+"\n")
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err = c.Authenticate(context.Background(), "new", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withBadChangedFileFixedInPlace() {
+	// a version that failed is parsed again even if the fix
+	// keeps its size and modification time.
+	path := suite.write("htpasswd", htpasswd)
+	c := suite.newController(path)
+	suite.write("htpasswd", "new;"+sha256Hash+"\n")
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err := c.Authenticate(context.Background(), "new", "testpwd")
+	require.NotNil(suite.T(), err)
+
+	require.Nil(suite.T(), ioutil.WriteFile(path, []byte("new:"+sha256Hash+"\n"), 0600))
+	require.Nil(suite.T(), os.Chtimes(path, suite.modTime, suite.modTime))
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err = c.Authenticate(context.Background(), "new", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withRemovedFile() {
+	path := suite.write("htpasswd", htpasswd)
+	c := suite.newController(path)
+	require.Nil(suite.T(), os.Remove(path))
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err := c.Authenticate(context.Background(), "test", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withRenamedFile() {
+	// files replaced atomically, like editors
+	// and htpasswd do, are seen as changed.
+	path := suite.write("htpasswd", htpasswd)
+	c := suite.newController(path)
+	require.Nil(suite.T(), os.Rename(suite.write("htpasswd.tmp", "new:"+sha512Hash+"\n"), path))
+	suite.now = suite.now.Add(DefaultPollInterval)
+	_, err := c.Authenticate(context.Background(), "new", "testpwd")
+	require.Nil(suite.T(), err)
+}
@@ -0,0 +1,89 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/clawio/authentication/passwordhasher"
+	"github.com/clawio/entities"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var reloadsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "clawio",
+		Subsystem: "authentication_file",
+		Name:      "reloads_total",
+		Help:      "Number of times the user file was loaded, by result. Failed loads keep the previous users.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(reloadsCounter)
+}
+
+// parseHtpasswd parses username:hash lines.
+// Blank lines and lines starting with # are ignored.
+func parseHtpasswd(data []byte) (map[string]*User, error) {
+	users := map[string]*User{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			return nil, lineError(i, errors.New("expected username:hash"))
+		}
+		u := &User{User: &entities.User{Username: fields[0]}, Password: fields[1]}
+		if err := addUser(users, u); err != nil {
+			return nil, lineError(i, err)
+		}
+	}
+	return users, nil
+}
+
+// parseJSONLines parses one JSON user per line. Blank lines are ignored.
+func parseJSONLines(data []byte) (map[string]*User, error) {
+	users := map[string]*User{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		u := &User{}
+		if err := json.Unmarshal([]byte(line), u); err != nil {
+			return nil, lineError(i, err)
+		}
+		if u.User == nil {
+			u.User = &entities.User{}
+		}
+		if err := addUser(users, u); err != nil {
+			return nil, lineError(i, err)
+		}
+	}
+	return users, nil
+}
+
+func addUser(users map[string]*User, u *User) error {
+	if u.Username == "" {
+		return errors.New("username is empty")
+	}
+	if _, ok := users[u.Username]; ok {
+		return errors.New("user " + u.Username + " is duplicated")
+	}
+	// plaintext passwords are refused, and so are formats like
+	// the Apache MD5 ($apr1$) one, which can not be verified.
+	format := passwordhasher.Format(u.Password)
+	if format == "" || format == passwordhasher.Plaintext {
+		return errors.New("password of user " + u.Username + " is not hashed with a supported algorithm")
+	}
+	users[u.Username] = u
+	return nil
+}
+
+func lineError(i int, err error) error {
+	return errors.New("line " + strconv.Itoa(i+1) + ": " + err.Error())
+}
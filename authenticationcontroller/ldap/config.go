@@ -0,0 +1,71 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+)
+
+func init() {
+	authenticationcontroller.Register("ldap", newFromConfig)
+}
+
+// Config is the configuration of the ldap type.
+type Config struct {
+	// URL is ldap://host:389 or ldaps://host:636. StartTLS upgrades
+	// ldap:// connections and CAFile is a PEM file with the certificates
+	// trusted for TLS, empty means the system roots.
+	URL      string
+	StartTLS bool
+	CAFile   string
+
+	// BindDN and BindPassword are the service account
+	// that searches the users under BaseDN with Filter.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	Filter       string
+
+	// Attributes maps the directory attributes to the users.
+	Attributes *AttributeMapping
+
+	// PoolSize is the number of idle connections kept open.
+	// Timeout is the timeout of the directory requests in seconds.
+	PoolSize int
+	Timeout  int
+}
+
+func newFromConfig(data json.RawMessage) (authenticationcontroller.AuthenticationController, error) {
+	var cfg Config
+	if err := authenticationcontroller.DecodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	opts := &Options{
+		URL:          cfg.URL,
+		StartTLS:     cfg.StartTLS,
+		BindDN:       cfg.BindDN,
+		BindPassword: cfg.BindPassword,
+		BaseDN:       cfg.BaseDN,
+		Filter:       cfg.Filter,
+		Attributes:   cfg.Attributes,
+		PoolSize:     cfg.PoolSize,
+		Timeout:      time.Duration(cfg.Timeout) * time.Second,
+	}
+	if cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("ldap CA file " + cfg.CAFile + " has no certificates")
+		}
+		opts.TLSConfig = &tls.Config{RootCAs: pool}
+	}
+	return New(opts)
+}
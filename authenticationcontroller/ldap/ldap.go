@@ -0,0 +1,241 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/entities"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// DefaultFilter finds users by their uid, use
+	// "(sAMAccountName={username})" for Active Directory.
+	DefaultFilter = "(uid={username})"
+
+	// DefaultPoolSize is the number of idle connections kept open.
+	DefaultPoolSize = 4
+
+	// DefaultTimeout is the timeout of the directory requests.
+	DefaultTimeout = 10 * time.Second
+)
+
+// DefaultAttributeMapping is the mapping used when none is configured.
+var DefaultAttributeMapping = &AttributeMapping{
+	Username:    "uid",
+	Email:       "mail",
+	DisplayName: "cn",
+}
+
+// AttributeMapping holds the directory attributes of the user fields.
+// The username is taken from the directory, so it has the case stored
+// there; it is the one typed by the user when the attribute is missing.
+type AttributeMapping struct {
+	Username    string
+	Email       string
+	DisplayName string
+}
+
+// Options holds the configuration
+// parameters used by the controller.
+type Options struct {
+	// URL is the address of the directory, ldap://host:389 or ldaps://host:636.
+	// StartTLS upgrades ldap:// connections before binding.
+	URL      string
+	StartTLS bool
+
+	// TLSConfig is used by ldaps:// and StartTLS,
+	// nil means the system roots and the host of URL.
+	TLSConfig *tls.Config
+
+	// BindDN and BindPassword are the service account that searches the users.
+	BindDN       string
+	BindPassword string
+
+	// BaseDN is where the users are searched with Filter, where {username}
+	// is replaced with the escaped username. Empty means DefaultFilter.
+	BaseDN string
+	Filter string
+
+	// Attributes maps the directory attributes to the user,
+	// DefaultAttributeMapping when nil.
+	Attributes *AttributeMapping
+
+	// PoolSize is the number of idle connections kept open, zero means
+	// DefaultPoolSize. Timeout zero means DefaultTimeout.
+	PoolSize int
+	Timeout  time.Duration
+}
+
+type controller struct {
+	baseDN     string
+	filter     string
+	attributes *AttributeMapping
+	pool       *pool
+}
+
+// New returns an AuthenticationController that authenticates users
+// against a LDAP directory, like OpenLDAP or Active Directory. The user is searched with the service account and its
+// password is verified binding as the user. Connections are opened on
+// first use, so the directory does not need to be up to start the service.
+func New(opts *Options) (authenticationcontroller.AuthenticationController, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, errors.New("ldap url " + opts.URL + " must be ldap:// or ldaps://")
+	}
+	if u.Scheme == "ldaps" && opts.StartTLS {
+		return nil, errors.New("ldap StartTLS can not be used with ldaps://")
+	}
+	if opts.BaseDN == "" {
+		return nil, errors.New("ldap base dn is empty")
+	}
+	filter := opts.Filter
+	if filter == "" {
+		filter = DefaultFilter
+	}
+	if !strings.Contains(filter, "{username}") {
+		return nil, errors.New("ldap filter " + filter + " does not contain {username}")
+	}
+	if _, err := goldap.CompileFilter(strings.Replace(filter, "{username}", "test", -1)); err != nil {
+		return nil, err
+	}
+	attributes := opts.Attributes
+	if attributes == nil {
+		attributes = DefaultAttributeMapping
+	}
+
+	tlsConfig := opts.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+	size := opts.PoolSize
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &controller{
+		baseDN:     opts.BaseDN,
+		filter:     filter,
+		attributes: attributes,
+		pool: &pool{
+			url:          opts.URL,
+			startTLS:     opts.StartTLS,
+			tlsConfig:    tlsConfig,
+			bindDN:       opts.BindDN,
+			bindPassword: opts.BindPassword,
+			timeout:      timeout,
+			idle:         make(chan *goldap.Conn, size),
+		},
+	}, nil
+}
+
+func (c *controller) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
+	// an empty password is an unauthenticated bind, which
+	// succeeds for any DN on many directories (RFC 4513 section 5.1.2).
+	if username == "" || password == "" {
+		return nil, errors.New("username or password is empty")
+	}
+	u, err := c.authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticationcontroller.Result{User: u, Method: authenticationcontroller.MethodPassword}, nil
+}
+
+// authenticate searches the user and binds as the user on a pooled
+// connection, which binds again as the service account before being
+// returned to the pool. The directory requests can not be cancelled,
+// so the connection is closed when the context is done.
+func (c *controller) authenticate(ctx context.Context, username, password string) (*entities.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, pooled, err := c.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, conn)
+	entry, err := c.search(conn, username)
+	if err != nil && pooled && conn.IsClosing() && ctx.Err() == nil {
+		// the directory closed the idle connection.
+		stop()
+		conn.Close()
+		conn, err = c.pool.dial()
+		if err != nil {
+			return nil, err
+		}
+		stop = closeOnDone(ctx, conn)
+		entry, err = c.search(conn, username)
+	}
+	if err != nil {
+		stop()
+		c.pool.put(conn, true)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	bindErr := conn.Bind(entry.DN, password)
+	rebindErr := c.pool.rebind(conn)
+	stop()
+	c.pool.put(conn, rebindErr == nil)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if bindErr != nil {
+		if goldap.IsErrorWithCode(bindErr, goldap.LDAPResultInvalidCredentials) {
+			return nil, authenticationcontroller.ErrInvalidPassword
+		}
+		return nil, bindErr
+	}
+
+	u := &entities.User{
+		Username:    entry.GetEqualFoldAttributeValue(c.attributes.Username),
+		Email:       entry.GetEqualFoldAttributeValue(c.attributes.Email),
+		DisplayName: entry.GetEqualFoldAttributeValue(c.attributes.DisplayName),
+	}
+	if u.Username == "" {
+		u.Username = username
+	}
+	return u, nil
+}
+
+// search returns the entry of the user. The username must match a single entry.
+func (c *controller) search(conn *goldap.Conn, username string) (*goldap.Entry, error) {
+	req := goldap.NewSearchRequest(
+		c.baseDN,
+		goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		2, int(c.pool.timeout/time.Second), false,
+		strings.Replace(c.filter, "{username}", goldap.EscapeFilter(username), -1),
+		[]string{c.attributes.Username, c.attributes.Email, c.attributes.DisplayName},
+		nil,
+	)
+	res, err := conn.Search(req)
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	if res == nil || len(res.Entries) == 0 {
+		return nil, authenticationcontroller.ErrUserNotFound
+	}
+	if len(res.Entries) > 1 {
+		return nil, errors.New("username " + username + " matches several entries")
+	}
+	return res.Entries[0], nil
+}
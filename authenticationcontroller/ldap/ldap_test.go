@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"testing"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/authenticationcontroller/ldap/ldaptest"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var entries = []*ldaptest.Entry{
+	{DN: "cn=clawio,ou=services,dc=example,dc=org", Password: "servicepwd"},
+	{
+		DN:       "uid=jdoe,ou=people,dc=example,dc=org",
+		Password: "testpwd",
+		Attributes: map[string][]string{
+			"objectClass":    {"person"},
+			"uid":            {"jdoe"},
+			"sAMAccountName": {"JDoe"},
+			"mail":           {"jdoe@example.org"},
+			"cn":             {"Jane Doe"},
+			"displayName":    {"Doe, Jane"},
+		},
+	},
+	{DN: "uid=twin,ou=people,dc=example,dc=org", Password: "testpwd", Attributes: map[string][]string{"uid": {"twin"}}},
+	{DN: "uid=twin,ou=staff,dc=example,dc=org", Password: "testpwd", Attributes: map[string][]string{"uid": {"twin"}}},
+	{DN: "uid=jdoe,ou=people,dc=other,dc=org", Password: "otherpwd", Attributes: map[string][]string{"uid": {"jdoe"}}},
+}
+
+type TestSuite struct {
+	suite.Suite
+	server *ldaptest.Server
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	suite.server = ldaptest.NewServer(entries)
+}
+func (suite *TestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *TestSuite) options() *Options {
+	return &Options{
+		URL:          suite.server.URL,
+		BindDN:       "cn=clawio,ou=services,dc=example,dc=org",
+		BindPassword: "servicepwd",
+		BaseDN:       "dc=example,dc=org",
+	}
+}
+
+func (suite *TestSuite) TestNew() {
+	_, err := New(suite.options())
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadURL() {
+	opts := suite.options()
+	opts.URL = "http://127.0.0.1"
+	_, err := New(opts)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withStartTLSAndLDAPS() {
+	opts := suite.options()
+	opts.URL = "ldaps://127.0.0.1"
+	opts.StartTLS = true
+	_, err := New(opts)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withoutBaseDN() {
+	opts := suite.options()
+	opts.BaseDN = ""
+	_, err := New(opts)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadFilter() {
+	opts := suite.options()
+	opts.Filter = "(uid=jdoe)"
+	_, err := New(opts)
+	require.NotNil(suite.T(), err)
+	opts.Filter = "(uid={username}"
+	_, err = New(opts)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withUnreachableServer() {
+	// connections are opened on first use.
+	opts := suite.options()
+	opts.URL = "ldap://127.0.0.1:1"
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	res, err := c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), &entities.User{Username: "jdoe", Email: "jdoe@example.org", DisplayName: "Jane Doe"}, res.User)
+	require.Equal(suite.T(), authenticationcontroller.MethodPassword, res.Method)
+}
+func (suite *TestSuite) TestAuthenticate_withCanceledContext() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.Authenticate(ctx, "jdoe", "testpwd")
+	require.Equal(suite.T(), context.Canceled, err)
+	require.Equal(suite.T(), 0, suite.server.Dials())
+}
+func (suite *TestSuite) TestAuthenticate_withBadPassword() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "otherpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestAuthenticate_withEmptyPassword() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "")
+	require.NotNil(suite.T(), err)
+	require.Equal(suite.T(), 0, suite.server.Binds())
+}
+func (suite *TestSuite) TestAuthenticate_withUnknownUser() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "unknown", "testpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestAuthenticate_withAmbiguousUser() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "twin", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withFilterInjection() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "j*", "testpwd")
+	require.NotNil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe)(uid=*", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withBaseDN() {
+	opts := suite.options()
+	opts.BaseDN = "dc=other,dc=org"
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.NotNil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "otherpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withBadServiceAccount() {
+	opts := suite.options()
+	opts.BindPassword = "bad"
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withAttributeMapping() {
+	opts := suite.options()
+	opts.Filter = "(&(objectClass=person)(sAMAccountName={username}))"
+	opts.Attributes = &AttributeMapping{Username: "samaccountname", Email: "mail", DisplayName: "displayname"}
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	res, err := c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "JDoe", res.User.Username)
+	require.Equal(suite.T(), "Doe, Jane", res.User.DisplayName)
+}
+func (suite *TestSuite) TestAuthenticate_withStartTLS() {
+	opts := suite.options()
+	opts.StartTLS = true
+	opts.TLSConfig = &tls.Config{RootCAs: suite.server.RootCAs}
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withLDAPS() {
+	server := ldaptest.NewTLSServer(entries)
+	defer server.Close()
+	opts := suite.options()
+	opts.URL = server.URL
+	opts.TLSConfig = &tls.Config{RootCAs: server.RootCAs}
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withUntrustedCertificate() {
+	server := ldaptest.NewTLSServer(entries)
+	defer server.Close()
+	opts := suite.options()
+	opts.URL = server.URL
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withPooledConnections() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "otherpwd")
+	require.NotNil(suite.T(), err)
+	for i := 0; i < 3; i++ {
+		_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+		require.Nil(suite.T(), err)
+	}
+	require.Equal(suite.T(), 1, suite.server.Dials())
+}
+func (suite *TestSuite) TestAuthenticate_withClosedConnections() {
+	c, err := New(suite.options())
+	require.Nil(suite.T(), err)
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+	suite.server.CloseConnections()
+	_, err = c.Authenticate(context.Background(), "jdoe", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_concurrently() {
+	opts := suite.options()
+	opts.PoolSize = 2
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Authenticate(context.Background(), "jdoe", "testpwd")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.Nil(suite.T(), err)
+	}
+	require.True(suite.T(), len(c.(*controller).pool.idle) <= 2)
+}
@@ -0,0 +1,348 @@
+// Package ldaptest provides an in-process LDAP server
+// to test the ldap controller without a directory service.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// Entry is an entry of the directory.
+// Entries with a password can bind.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is an LDAP server listening on the loopback interface. It
+// implements simple binds, searches with equality, presence, and, or
+// and not filters, and the StartTLS operation. Searches are refused on
+// anonymous connections, like most directories do.
+type Server struct {
+	// URL is ldap://127.0.0.1:port or ldaps://127.0.0.1:port.
+	URL string
+
+	// RootCAs trusts the certificate of the server.
+	RootCAs *x509.CertPool
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	mu      sync.Mutex
+	entries []*Entry
+	conns   map[net.Conn]bool
+	dials   int
+	binds   int
+}
+
+// NewServer starts a Server serving the entries over ldap://.
+func NewServer(entries []*Entry) *Server {
+	return newServer(entries, false)
+}
+
+// NewTLSServer starts a Server serving the entries over ldaps://.
+func NewTLSServer(entries []*Entry) *Server {
+	return newServer(entries, true)
+}
+
+func newServer(entries []*Entry, useTLS bool) *Server {
+	cert, pool := newCertificate()
+	s := &Server{
+		RootCAs:   pool,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		entries:   entries,
+		conns:     map[net.Conn]bool{},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s.URL = "ldap://" + l.Addr().String()
+	if useTLS {
+		l = tls.NewListener(l, s.tlsConfig)
+		s.URL = "ldaps://" + l.Addr().String()
+	}
+	s.listener = l
+	go s.serve()
+	return s
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseConnections()
+}
+
+// CloseConnections closes the open connections,
+// as a server does when they are idle for too long.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Dials returns the number of connections accepted.
+func (s *Server) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+// Binds returns the number of bind requests received.
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds
+}
+
+// SetPassword changes the password of an entry.
+func (s *Server) SetPassword(dn, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			e.Password = password
+		}
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	var bound *Entry
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			var ok bool
+			bound, ok = s.bind(op)
+			code := uint16(goldap.LDAPResultSuccess)
+			if !ok {
+				code = goldap.LDAPResultInvalidCredentials
+			}
+			writeResult(conn, id, goldap.ApplicationBindResponse, code)
+		case goldap.ApplicationSearchRequest:
+			if bound == nil {
+				writeResult(conn, id, goldap.ApplicationSearchResultDone, goldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			for _, e := range s.search(op) {
+				writePacket(conn, id, entryPacket(e, op.Children[7]))
+			}
+			writeResult(conn, id, goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess)
+		case goldap.ApplicationExtendedRequest:
+			if _, isTLS := conn.(*tls.Conn); isTLS || ber.DecodeString(op.Children[0].Data.Bytes()) != startTLSOID {
+				writeResult(conn, id, goldap.ApplicationExtendedResponse, goldap.LDAPResultProtocolError)
+				continue
+			}
+			writeResult(conn, id, goldap.ApplicationExtendedResponse, goldap.LDAPResultSuccess)
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.conns[tlsConn] = true
+			s.mu.Unlock()
+			conn = tlsConn
+		case goldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// bind returns the entry of a simple bind and whether the credentials are
+// valid. Anonymous binds, without DN and password, have no entry.
+func (s *Server) bind(op *ber.Packet) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.binds++
+	if len(op.Children) < 3 || op.Children[2].Tag != 0 {
+		return nil, false
+	}
+	dn := ber.DecodeString(op.Children[1].Data.Bytes())
+	password := ber.DecodeString(op.Children[2].Data.Bytes())
+	if dn == "" && password == "" {
+		return nil, true
+	}
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) && e.Password != "" && e.Password == password {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// search returns the entries under the base DN that match the filter.
+func (s *Server) search(op *ber.Packet) []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base := strings.ToLower(ber.DecodeString(op.Children[0].Data.Bytes()))
+	entries := []*Entry{}
+	for _, e := range s.entries {
+		dn := strings.ToLower(e.DN)
+		if (dn == base || strings.HasSuffix(dn, ","+base)) && match(e, op.Children[6]) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func match(e *Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case goldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !match(e, f) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, f := range filter.Children {
+			if match(e, f) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !match(e, filter.Children[0])
+	case goldap.FilterEqualityMatch:
+		value := ber.DecodeString(filter.Children[1].Data.Bytes())
+		for _, v := range attribute(e, ber.DecodeString(filter.Children[0].Data.Bytes())) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterPresent:
+		return len(attribute(e, ber.DecodeString(filter.Data.Bytes()))) > 0
+	}
+	return false
+}
+
+// attribute returns the values of an attribute, whose names are case insensitive.
+func attribute(e *Entry, name string) []string {
+	for n, values := range e.Attributes {
+		if strings.EqualFold(n, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func entryPacket(e *Entry, requested *ber.Packet) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	// attributes are returned with the case of the entry.
+	for name, values := range e.Attributes {
+		if !requestedAttribute(requested, name) || len(values) == 0 {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(set)
+		attributes.AppendChild(attr)
+	}
+	op.AppendChild(attributes)
+	return op
+}
+
+func requestedAttribute(requested *ber.Packet, name string) bool {
+	for _, r := range requested.Children {
+		if strings.EqualFold(ber.DecodeString(r.Data.Bytes()), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeResult(conn net.Conn, id int64, tag ber.Tag, code uint16) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, goldap.LDAPResultCodeMap[code], "Diagnostic Message"))
+	writePacket(conn, id, op)
+}
+
+func writePacket(conn net.Conn, id int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(op)
+	conn.Write(packet.Bytes())
+}
+
+// newCertificate returns a self-signed certificate for 127.0.0.1
+// and a pool that trusts it.
+func newCertificate() (tls.Certificate, *x509.CertPool) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldaptest"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, pool
+}
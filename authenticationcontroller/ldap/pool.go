@@ -0,0 +1,102 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// pool keeps idle connections bound as the service account. Connections
+// are opened on demand, so there can be more connections in use than
+// idle ones; the extra ones are closed when they are returned.
+type pool struct {
+	url          string
+	startTLS     bool
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	timeout      time.Duration
+
+	idle chan *goldap.Conn
+}
+
+// get returns an idle connection or opens a new one.
+// It reports whether the connection was idle.
+func (p *pool) get() (*goldap.Conn, bool, error) {
+	for {
+		select {
+		case conn := <-p.idle:
+			if conn.IsClosing() {
+				continue
+			}
+			return conn, true, nil
+		default:
+			conn, err := p.dial()
+			return conn, false, err
+		}
+	}
+}
+
+// put returns a connection to the pool, or closes it
+// if it is not reusable or the pool is full.
+func (p *pool) put(conn *goldap.Conn, reusable bool) {
+	if !reusable || conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	select {
+	case p.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// dial opens a connection bound as the service account.
+func (p *pool) dial() (*goldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.timeout}
+	conn, err := goldap.DialURL(p.url, goldap.DialWithDialer(dialer), goldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(p.timeout)
+	if p.startTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := p.rebind(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// rebind binds the connection as the service account.
+// Without a service account the connection is anonymous.
+func (p *pool) rebind(conn *goldap.Conn) error {
+	if p.bindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(p.bindDN, p.bindPassword)
+}
+
+// closeOnDone closes the connection if the context is done before
+// the returned function is called.
+func closeOnDone(ctx context.Context, conn *goldap.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
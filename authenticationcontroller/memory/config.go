@@ -0,0 +1,24 @@
+package memory
+
+import (
+	"encoding/json"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+)
+
+func init() {
+	authenticationcontroller.Register("memory", newFromConfig)
+}
+
+// Config is the configuration of the memory type.
+type Config struct {
+	Users []*User
+}
+
+func newFromConfig(data json.RawMessage) (authenticationcontroller.AuthenticationController, error) {
+	var cfg Config
+	if err := authenticationcontroller.DecodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	return New(&Options{Users: cfg.Users})
+}
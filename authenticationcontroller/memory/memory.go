@@ -1,13 +1,19 @@
 package memory
 
 import (
+	"context"
 	"errors"
+	"log"
+	"strings"
 
 	"github.com/clawio/authentication/authenticationcontroller"
-	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/passwordhasher"
 	"github.com/clawio/entities"
 )
 
+// User is an user with its password. The password is an encoded hash
+// in any format supported by the passwordhasher package, plaintext
+// passwords are still accepted for backwards compatibility but logged.
 type User struct {
 	*entities.User
 	Password string `json:"password"`
@@ -16,29 +22,78 @@ type User struct {
 // Options  holds the configuration
 // parameters used by the MemoryAuthenticationController.
 type Options struct {
-	Users         []*User
-	Authenticator *lib.Authenticator
+	Users []*User
 }
 
 // New returns an AuthenticationControler that
 // stores users in memory. This controller is for testing purposes.
-func New(opts *Options) authenticationcontroller.AuthenticationController {
-	return &controller{
-		users:         opts.Users,
-		authenticator: opts.Authenticator,
+// Passwords hashed in a format that is not supported are an error.
+func New(opts *Options) (authenticationcontroller.AuthenticationController, error) {
+	for _, u := range opts.Users {
+		if err := checkPassword(u); err != nil {
+			return nil, err
+		}
+	}
+	// dummyHash is verified when the user does not exist
+	// so unknown usernames take as long as wrong passwords.
+	hasher, err := passwordhasher.New(nil)
+	if err != nil {
+		return nil, err
 	}
+	dummyHash, err := hasher.Hash("")
+	if err != nil {
+		return nil, err
+	}
+	return &controller{users: opts.Users, dummyHash: dummyHash}, nil
 }
 
-func (c *controller) Authenticate(username, password string) (string, error) {
+func (c *controller) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
 	for _, u := range c.users {
-		if u.Username == username && u.Password == password {
-			return c.authenticator.CreateToken(u.User)
+		if u.Username != username {
+			continue
+		}
+		ok, err := passwordhasher.Verify(u.Password, password)
+		if err != nil {
+			return nil, err
 		}
+		if !ok {
+			return nil, authenticationcontroller.ErrInvalidPassword
+		}
+		return &authenticationcontroller.Result{User: u.User, Method: authenticationcontroller.MethodPassword}, nil
+	}
+	passwordhasher.Verify(c.dummyHash, password)
+	return nil, authenticationcontroller.ErrUserNotFound
+}
+
+// checkPassword refuses the passwords that can not be verified, like
+// the Apache MD5 ($apr1$) or the LDAP {SHA} ones, which would otherwise
+// be compared as plaintext, and logs the plaintext ones.
+func checkPassword(u *User) error {
+	unsupported := errors.New("password of user " + u.Username + " is not hashed with a supported algorithm")
+	if passwordhasher.Format(u.Password) != passwordhasher.Plaintext {
+		if !passwordhasher.Valid(u.Password) {
+			return unsupported
+		}
+		return nil
+	}
+	if isSchemePrefixed(u.Password) {
+		return unsupported
+	}
+	log.Printf("password of user %s is stored in plaintext, hash it with hash-password", u.Username)
+	return nil
+}
+
+// isSchemePrefixed reports whether the password starts with
+// a {SCHEME} prefix like the LDAP {SHA} and {SSHA} hashes.
+func isSchemePrefixed(password string) bool {
+	if !strings.HasPrefix(password, "{") {
+		return false
 	}
-	return "", errors.New("user not found")
+	end := strings.Index(password, "}")
+	return end > 1 && strings.Trim(password[1:end], "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") == ""
 }
 
 type controller struct {
-	users         []*User
-	authenticator *lib.Authenticator
+	users     []*User
+	dummyHash string
 }
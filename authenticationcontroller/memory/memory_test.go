@@ -1,10 +1,10 @@
 package memory
 
 import (
+	"context"
 	"testing"
 
 	"github.com/clawio/authentication/authenticationcontroller"
-	"github.com/clawio/authentication/lib"
 	"github.com/clawio/entities"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -13,6 +13,8 @@ import (
 var users = []*User{
 	{User: &entities.User{Username: "test"}, Password: "test"},
 	{User: &entities.User{Username: "hugo"}, Password: "hugo"},
+	// bcrypt hash of "hashed"
+	{User: &entities.User{Username: "hashed"}, Password: "$2a$04$De314cF4i52YR6Ybpbx5r.kF3b9t56JnOYpXwQ0CGmyXlwmXALlpG"},
 }
 
 type TestSuite struct {
@@ -26,10 +28,10 @@ func Test(t *testing.T) {
 }
 func (suite *TestSuite) SetupTest() {
 	opts := &Options{
-		Users:         users,
-		Authenticator: lib.NewAuthenticator("secret", "HS256"),
+		Users: users,
 	}
-	authenticationController := New(opts)
+	authenticationController, err := New(opts)
+	require.Nil(suite.T(), err)
 	require.NotNil(suite.T(), authenticationController)
 	suite.authenticationController = authenticationController
 	suite.controller = suite.authenticationController.(*controller)
@@ -37,18 +39,35 @@ func (suite *TestSuite) SetupTest() {
 
 func (suite *TestSuite) TestNew() {
 	opts := &Options{
-		Users:         users,
-		Authenticator: lib.NewAuthenticator("secret", "HS256"),
+		Users: users,
 	}
-	c := New(opts)
+	c, err := New(opts)
+	require.Nil(suite.T(), err)
 	require.NotNil(suite.T(), c)
 }
 
 func (suite *TestSuite) TestAuthenticate() {
-	_, err := suite.authenticationController.Authenticate("test", "test")
+	res, err := suite.authenticationController.Authenticate(context.Background(), "test", "test")
 	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", res.User.Username)
+	require.Equal(suite.T(), authenticationcontroller.MethodPassword, res.Method)
+	require.Empty(suite.T(), res.Factors)
 }
 func (suite *TestSuite) TestAuthenticate_withBadUser() {
-	_, err := suite.authenticationController.Authenticate("notfound", "notfound")
-	require.NotNil(suite.T(), err)
+	_, err := suite.authenticationController.Authenticate(context.Background(), "notfound", "notfound")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestAuthenticate_withHashedPassword() {
+	_, err := suite.authenticationController.Authenticate(context.Background(), "hashed", "hashed")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withHashedPasswordAndBadPassword() {
+	_, err := suite.authenticationController.Authenticate(context.Background(), "hashed", "notfound")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestNew_withUnsupportedHash() {
+	for _, password := range []string{"$md5$badhash", "$apr1$salt$hash", "{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M="} {
+		_, err := New(&Options{Users: []*User{{User: &entities.User{Username: "badhash"}, Password: password}}})
+		require.NotNil(suite.T(), err, password)
+	}
 }
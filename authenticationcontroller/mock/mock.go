@@ -1,6 +1,9 @@
 package mock
 
 import (
+	"context"
+
+	"github.com/clawio/authentication/authenticationcontroller"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -10,7 +13,8 @@ type AuthenticationController struct {
 }
 
 // Authenticate mocks the Authenticate call.
-func (m *AuthenticationController) Authenticate(username, password string) (string, error) {
+func (m *AuthenticationController) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
 	args := m.Called()
-	return args.String(0), args.Error(1)
+	res, _ := args.Get(0).(*authenticationcontroller.Result)
+	return res, args.Error(1)
 }
@@ -0,0 +1,70 @@
+package authenticationcontroller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Factory creates an AuthenticationController from its configuration,
+// the raw JSON object given for the controller, empty when there is none.
+type Factory func(config json.RawMessage) (AuthenticationController, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a controller available under the name, which is the
+// Type of the configuration. It is meant to be called from the init
+// function of the controller package, so controllers not in this
+// repository are enabled by importing their package for its side effects.
+// Register panics if the name is already registered or the factory is nil.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("authenticationcontroller: Register factory is nil")
+	}
+	if _, ok := factories[name]; ok {
+		panic("authenticationcontroller: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the controller registered under the name with its configuration.
+func New(name string, config json.RawMessage) (AuthenticationController, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, errors.New("authenticationController type " + name + " does not exist")
+	}
+	return factory(config)
+}
+
+// Types returns the sorted names of the registered controllers.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodeConfig decodes the configuration of a controller into v, which is
+// left untouched when the configuration is empty. Unknown fields are an
+// error so that misspelled options are not silently ignored.
+func DecodeConfig(config json.RawMessage, v interface{}) error {
+	if len(bytes.TrimSpace(config)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
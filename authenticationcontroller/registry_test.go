@@ -0,0 +1,64 @@
+package authenticationcontroller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type static struct {
+	Username string
+}
+
+func (c *static) Authenticate(ctx context.Context, username, password string) (*Result, error) {
+	return nil, ErrUserNotFound
+}
+
+func newStatic(config json.RawMessage) (AuthenticationController, error) {
+	c := &static{}
+	if err := DecodeConfig(config, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupSuite() {
+	Register("static", newStatic)
+}
+func (suite *TestSuite) TestNew() {
+	c, err := New("static", json.RawMessage(`{"Username": "test"}`))
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", c.(*static).Username)
+}
+func (suite *TestSuite) TestNew_withoutConfig() {
+	c, err := New("static", nil)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "", c.(*static).Username)
+}
+func (suite *TestSuite) TestNew_withUnknownType() {
+	_, err := New("notfound", nil)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withUnknownField() {
+	_, err := New("static", json.RawMessage(`{"User": "test"}`))
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRegister_withDuplicate() {
+	require.Panics(suite.T(), func() { Register("static", newStatic) })
+}
+func (suite *TestSuite) TestRegister_withNilFactory() {
+	require.Panics(suite.T(), func() { Register("nil", nil) })
+}
+func (suite *TestSuite) TestTypes() {
+	require.Contains(suite.T(), Types(), "static")
+}
@@ -0,0 +1,38 @@
+package simple
+
+import (
+	"encoding/json"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/passwordhasher"
+)
+
+func init() {
+	authenticationcontroller.Register("simple", newFromConfig)
+}
+
+// Config is the configuration of the simple type.
+type Config struct {
+	Driver string
+	DSN    string
+
+	// PasswordHashAlgorithm is one of bcrypt, scrypt or argon2id.
+	// PasswordHashCost is the algorithm specific cost, zero means the default.
+	PasswordHashAlgorithm string
+	PasswordHashCost      int
+}
+
+func newFromConfig(data json.RawMessage) (authenticationcontroller.AuthenticationController, error) {
+	var cfg Config
+	if err := authenticationcontroller.DecodeConfig(data, &cfg); err != nil {
+		return nil, err
+	}
+	hasher, err := passwordhasher.New(&passwordhasher.Options{
+		Algorithm: cfg.PasswordHashAlgorithm,
+		Cost:      cfg.PasswordHashCost,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return New(&Options{Driver: cfg.Driver, DSN: cfg.DSN, PasswordHasher: hasher})
+}
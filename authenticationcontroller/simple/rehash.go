@@ -0,0 +1,114 @@
+package simple
+
+import (
+	"github.com/clawio/authentication/passwordhasher"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	legacyPasswordsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "clawio",
+			Subsystem: "authentication_simple",
+			Name:      "legacy_passwords",
+			Help:      "Number of accounts whose password is stored in a legacy format or below the configured policy.",
+		},
+		[]string{"format"},
+	)
+	rehashesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "clawio",
+			Subsystem: "authentication_simple",
+			Name:      "password_rehashes_total",
+			Help:      "Number of passwords re-hashed on login with the configured algorithm.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(legacyPasswordsGauge, rehashesCounter)
+}
+
+// rehash stores a new hash of the password computed with the configured
+// algorithm and cost. The row is only updated if it still holds the hash
+// the password was verified against, so concurrent logins or password changes
+// cannot be overwritten. Failures are counted but do not fail the login,
+// the migration is retried on the next successful login.
+func (c *controller) rehash(rec *userRecord, password string) {
+	encoded, err := c.passwordHasher.Hash(password)
+	if err != nil {
+		rehashesCounter.WithLabelValues("error").Inc()
+		return
+	}
+	db := c.db.Model(&userRecord{}).
+		Where("username=? AND password=?", rec.Username, rec.Password).
+		Update("password", encoded)
+	if db.Error != nil {
+		rehashesCounter.WithLabelValues("error").Inc()
+		return
+	}
+	if db.RowsAffected == 0 {
+		rehashesCounter.WithLabelValues("conflict").Inc()
+		return
+	}
+	rehashesCounter.WithLabelValues("success").Inc()
+	legacyPasswordsGauge.WithLabelValues(legacyFormat(rec.Password)).Dec()
+	rec.Password = encoded
+}
+
+// forgetLegacyPassword updates the gauge for a password that
+// was replaced or deleted, if it was waiting to be re-hashed.
+func (c *controller) forgetLegacyPassword(encoded string) {
+	if encoded != "" && c.passwordHasher.NeedsRehash(encoded) {
+		legacyPasswordsGauge.WithLabelValues(legacyFormat(encoded)).Dec()
+	}
+}
+
+// countLegacyPasswords returns the number of accounts that need
+// to be re-hashed grouped by the format of their current password.
+func (c *controller) countLegacyPasswords() (map[string]int, error) {
+	rows, err := c.db.Model(&userRecord{}).Select("password").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		// provisioned users have no password to re-hash.
+		if encoded != "" && c.passwordHasher.NeedsRehash(encoded) {
+			counts[legacyFormat(encoded)]++
+		}
+	}
+	return counts, rows.Err()
+}
+
+// addLegacyPasswords adds the accounts of the controller to the gauge.
+// The gauge is shared by every controller, like the ones of a chain,
+// so each one adds its own accounts instead of setting the total.
+func (c *controller) addLegacyPasswords() error {
+	counts, err := c.countLegacyPasswords()
+	if err != nil {
+		return err
+	}
+	for format, count := range counts {
+		legacyPasswordsGauge.WithLabelValues(format).Add(float64(count))
+	}
+	return nil
+}
+
+// legacyFormat returns the label used for the format of an encoded password.
+// Values that start like a hash but are not a valid one are unknown, they can
+// not be re-hashed on login and must be reset.
+func legacyFormat(encoded string) string {
+	format := passwordhasher.Format(encoded)
+	if format == passwordhasher.Plaintext || (format != "" && passwordhasher.Valid(encoded)) {
+		return format
+	}
+	return "unknown"
+}
@@ -1,8 +1,13 @@
 package simple
 
 import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"log"
+
 	"github.com/clawio/authentication/authenticationcontroller"
-	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/passwordhasher"
 	"github.com/clawio/entities"
 	_ "github.com/go-sql-driver/mysql" // enable mysql driver
 	"github.com/jinzhu/gorm"
@@ -11,20 +16,21 @@ import (
 )
 
 type controller struct {
-	driver, dsn   string
-	db            *gorm.DB
-	authenticator *lib.Authenticator
+	driver, dsn    string
+	db             *gorm.DB
+	passwordHasher passwordhasher.PasswordHasher
+	dummyHash      string
 }
 
 // Options  holds the configuration
 // parameters used by the controller.
+// If PasswordHasher is nil passwords are hashed with bcrypt.
 type Options struct {
-	Driver, DSN   string
-	Authenticator *lib.Authenticator
+	Driver, DSN    string
+	PasswordHasher passwordhasher.PasswordHasher
 }
 
-// New returns an AuthenticationControler that uses a SQL database for handling
-// users and JWT for tokens.
+// New returns an AuthenticationControler that uses a SQL database for handling users.
 func New(opts *Options) (authenticationcontroller.AuthenticationController, error) {
 	db, err := gorm.Open(opts.Driver, opts.DSN)
 	if err != nil {
@@ -35,32 +41,145 @@ func New(opts *Options) (authenticationcontroller.AuthenticationController, erro
 		return nil, err
 	}
 
-	return &controller{
-		driver:        opts.Driver,
-		dsn:           opts.DSN,
-		db:            db,
-		authenticator: opts.Authenticator,
-	}, nil
+	hasher := opts.PasswordHasher
+	if hasher == nil {
+		hasher, err = passwordhasher.New(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// dummyHash is verified when the user does not exist
+	// so unknown usernames take as long as wrong passwords.
+	dummyHash, err := hasher.Hash("")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &controller{
+		driver:         opts.Driver,
+		dsn:            opts.DSN,
+		db:             db,
+		passwordHasher: hasher,
+		dummyHash:      dummyHash,
+	}
+	if err := c.addLegacyPasswords(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func (c *controller) Authenticate(username, password string) (string, error) {
-	rec, err := c.findByCredentials(username, password)
+func (c *controller) Authenticate(ctx context.Context, username, password string) (*authenticationcontroller.Result, error) {
+	rec, err := c.findByCredentials(ctx, username, password)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if c.passwordHasher.NeedsRehash(rec.Password) {
+		c.rehash(rec, password)
 	}
 	u := &entities.User{
 		Username:    rec.Username,
 		Email:       rec.Email,
 		DisplayName: rec.DisplayName,
 	}
-	return c.authenticator.CreateToken(u)
+	return &authenticationcontroller.Result{User: u, Method: authenticationcontroller.MethodPassword}, nil
 }
 
 // findByCredentials finds an user given an username and a password.
-func (c *controller) findByCredentials(username, password string) (*userRecord, error) {
+// The record is fetched by username and the password is verified
+// against the stored hash.
+func (c *controller) findByCredentials(ctx context.Context, username, password string) (*userRecord, error) {
+	rec, err := c.findByUsername(ctx, username)
+	if err == gorm.ErrRecordNotFound {
+		c.passwordHasher.Verify(c.dummyHash, password)
+		return nil, authenticationcontroller.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// users provisioned by the federation have no password
+	// and can only sign in through their identity provider.
+	if rec.Password == "" {
+		c.passwordHasher.Verify(c.dummyHash, password)
+		return nil, authenticationcontroller.ErrInvalidPassword
+	}
+	ok, err := c.verify(rec.Username, rec.Password, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, authenticationcontroller.ErrInvalidPassword
+	}
+	if rec.Disabled {
+		return nil, authenticationcontroller.ErrUserDisabled
+	}
+	return rec, nil
+}
+
+// verify compares the password with the stored one. Stored values that do not
+// start with $ are legacy plaintext passwords, compared as they are and re-hashed
+// on login. Values that start like a hash but are not a valid one never match,
+// otherwise the stored value itself would be accepted as the password.
+func (c *controller) verify(username, encoded, password string) (bool, error) {
+	if passwordhasher.Format(encoded) == passwordhasher.Plaintext {
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1, nil
+	}
+	if !passwordhasher.Valid(encoded) {
+		log.Printf("password of user %s is not a valid hash in a supported format", username)
+		c.passwordHasher.Verify(c.dummyHash, password)
+		return false, nil
+	}
+	return c.passwordHasher.Verify(encoded, password)
+}
+
+// Provision creates the user signed in through an upstream identity provider,
+// without a password, or updates its email and display name. Only the users
+// created by the same provider are updated: a local user, or one of another
+// provider, is never taken over by an upstream login.
+func (c *controller) Provision(provider string, user *entities.User) error {
+	rec, err := c.findByUsername(context.Background(), user.Username)
+	if err == gorm.ErrRecordNotFound {
+		rec = &userRecord{
+			Username:    user.Username,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+			Provider:    provider,
+		}
+		return c.db.Create(rec).Error
+	}
+	if err != nil {
+		return err
+	}
+	if rec.Provider != provider || rec.Password != "" {
+		log.Printf("user %s already exists and was not provisioned by %s", user.Username, provider)
+		return authenticationcontroller.ErrUserExists
+	}
+	if rec.Disabled {
+		return authenticationcontroller.ErrUserDisabled
+	}
+	return c.db.Model(&userRecord{}).Where("username=?", user.Username).Updates(map[string]interface{}{
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+	}).Error
+}
+
+// findByUsername finds an user given an username. The query is run with
+// database/sql, gorm queries can not be cancelled with the context.
+func (c *controller) findByUsername(ctx context.Context, username string) (*userRecord, error) {
+	query := "SELECT username, email, display_name, password, disabled, provider FROM users WHERE username=" + c.db.Dialect().BindVar(1)
+	var email, displayName, password, provider sql.NullString
+	var disabled sql.NullBool
 	rec := &userRecord{}
-	err := c.db.Where("username=? AND password=?", username, password).First(rec).Error
-	return rec, err
+	err := c.db.DB().QueryRowContext(ctx, query, username).Scan(&rec.Username, &email, &displayName, &password, &disabled, &provider)
+	if err == sql.ErrNoRows {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.Email, rec.DisplayName, rec.Password = email.String, displayName.String, password.String
+	rec.Provider = provider.String
+	rec.Disabled = disabled.Bool
+	return rec, nil
 }
 
 // TODO(labkode) set collation for table and column to utf8. The default is swedish
@@ -68,7 +187,13 @@ type userRecord struct {
 	Username    string `gorm:"primary_key"`
 	Email       string
 	DisplayName string
-	Password    string
+	// Password holds the encoded password hash, or the plaintext
+	// password of the legacy accounts until they are re-hashed.
+	Password string
+	Disabled bool `gorm:"not null;default:false"`
+	// Provider is the ID of the identity provider
+	// that provisioned the user, empty for local users.
+	Provider string
 }
 
 func (u userRecord) TableName() string {
@@ -1,18 +1,23 @@
 package simple
 
 import (
+	"context"
 	"database/sql"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/clawio/authentication/authenticationcontroller"
-	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/passwordhasher"
+	"github.com/clawio/entities"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
 type TestSuite struct {
 	suite.Suite
+	dir                      string
 	authenticationController authenticationcontroller.AuthenticationController
 	controller               *controller
 }
@@ -21,34 +26,36 @@ func Test(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
 func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-userstore")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+	hasher, err := passwordhasher.New(&passwordhasher.Options{Cost: 4})
+	require.Nil(suite.T(), err)
 	opts := &Options{
-		Driver:        "sqlite3",
-		DSN:           "/tmp/userstore.db",
-		Authenticator: lib.NewAuthenticator("secret", "HS256"),
+		Driver:         "sqlite3",
+		DSN:            filepath.Join(suite.dir, "userstore.db"),
+		PasswordHasher: hasher,
 	}
 	authenticationController, err := New(opts)
 	require.Nil(suite.T(), err)
 	suite.authenticationController = authenticationController
 	suite.controller = suite.authenticationController.(*controller)
 }
-func (suite *TestSuite) TeardownTest() {
-	os.RemoveAll("/tmp/t")
-	os.RemoveAll("/tmp/userstore.db")
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
 }
 func (suite *TestSuite) TestNew() {
 	opts := &Options{
-		Driver:        "sqlite3",
-		DSN:           "/tmp/userstore.db",
-		Authenticator: lib.NewAuthenticator("secret", "HS256"),
+		Driver: "sqlite3",
+		DSN:    filepath.Join(suite.dir, "userstore.db"),
 	}
 	_, err := New(opts)
 	require.Nil(suite.T(), err)
 }
 func (suite *TestSuite) TestNew_withBadDriver() {
 	opts := &Options{
-		Driver:        "thisnotexists",
-		DSN:           "/tmp/userstore.db",
-		Authenticator: lib.NewAuthenticator("secret", "HS256"),
+		Driver: "thisnotexists",
+		DSN:    filepath.Join(suite.dir, "userstore.db"),
 	}
 	_, err := New(opts)
 	require.NotNil(suite.T(), err)
@@ -58,30 +65,390 @@ func (suite *TestSuite) TestfindByCredentials() {
 	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
 	require.Nil(suite.T(), err)
 	defer db.Close()
-	sqlStmt := `insert into users values ("testFindByCredentials", "test@test.com", "Test", "testpwd")`
-	_, err = db.Exec(sqlStmt)
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testFindByCredentials", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
 	defer db.Exec("delete from users")
 	require.Nil(suite.T(), err)
-	user, err := suite.controller.findByCredentials("testFindByCredentials", "testpwd")
+	user, err := suite.controller.findByCredentials(context.Background(), "testFindByCredentials", "testpwd")
 	require.Nil(suite.T(), err)
 	require.Equal(suite.T(), "testFindByCredentials", user.Username)
 }
 func (suite *TestSuite) TestfindByCredentials_withBadUser() {
-	_, err := suite.controller.findByCredentials("", "")
-	require.NotNil(suite.T(), err)
+	_, err := suite.controller.findByCredentials(context.Background(), "", "")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
 }
-func (suite *TestSuite) TestAuthenticate() {
+func (suite *TestSuite) TestfindByCredentials_withBadPassword() {
 	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
 	require.Nil(suite.T(), err)
 	defer db.Close()
-	sqlStmt := `insert into users values ("testAuthenticate", "test@test.com", "Test", "testpwd")`
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testFindByCredentials", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+	_, err = suite.controller.findByCredentials(context.Background(), "testFindByCredentials", "notpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestfindByCredentials_withPlaintextPassword() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testFindByCredentials", "test@test.com", "Test", "testpwd")`
 	_, err = db.Exec(sqlStmt)
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+	_, err = suite.controller.findByCredentials(context.Background(), "testFindByCredentials", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testAuthenticate", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
 	require.Nil(suite.T(), err)
 	defer db.Exec("delete from users where username=testAuthenticate")
-	_, err = suite.controller.Authenticate("testAuthenticate", "testpwd")
+	res, err := suite.controller.Authenticate(context.Background(), "testAuthenticate", "testpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), &entities.User{Username: "testAuthenticate", Email: "test@test.com", DisplayName: "Test"}, res.User)
+	require.Equal(suite.T(), authenticationcontroller.MethodPassword, res.Method)
+}
+func (suite *TestSuite) TestAuthenticate_withCanceledContext() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testCanceled", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
+	require.Nil(suite.T(), err)
+	defer db.Exec("delete from users")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = suite.controller.Authenticate(ctx, "testCanceled", "testpwd")
+	require.Equal(suite.T(), context.Canceled, err)
+}
+func (suite *TestSuite) TestAuthenticate_withPlaintextPassword() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testRehash", "test@test.com", "Test", "testpwd")`
+	_, err = db.Exec(sqlStmt)
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+	counts, err := suite.controller.countLegacyPasswords()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 1, counts[passwordhasher.Plaintext])
+
+	_, err = suite.controller.Authenticate(context.Background(), "testRehash", "testpwd")
+	require.Nil(suite.T(), err)
+	rec, err := suite.controller.findByUsername(context.Background(), "testRehash")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), passwordhasher.Bcrypt, passwordhasher.Format(rec.Password))
+	counts, err = suite.controller.countLegacyPasswords()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 0, counts[passwordhasher.Plaintext])
+	_, err = suite.controller.Authenticate(context.Background(), "testRehash", "testpwd")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withInvalidHash() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	defer db.Exec("delete from users")
+	invalid := []string{"$ecret", "$2a$04$T/dwqYcp0JQnEQHTtka7ZO", "$apr1$salt$secret", "$argon2i$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA"}
+	for i, password := range invalid {
+		username := "testInvalid" + string('0'+rune(i))
+		sqlStmt := `insert into users (username, email, display_name, password) values (?, "test@test.com", "Test", ?)`
+		_, err = db.Exec(sqlStmt, username, password)
+		require.Nil(suite.T(), err)
+	}
+	counts, err := suite.controller.countLegacyPasswords()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 0, counts[passwordhasher.Plaintext])
+	require.Equal(suite.T(), len(invalid), counts["unknown"])
+
+	// the stored value is not accepted as the password.
+	for i, password := range invalid {
+		username := "testInvalid" + string('0'+rune(i))
+		_, err = suite.controller.Authenticate(context.Background(), username, password)
+		require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err, password)
+		rec, err := suite.controller.findByUsername(context.Background(), username)
+		require.Nil(suite.T(), err)
+		require.Equal(suite.T(), password, rec.Password)
+	}
+}
+func (suite *TestSuite) TestAuthenticate_withStoredHash() {
+	encoded, err := suite.controller.passwordHasher.Hash("testpwd")
+	require.Nil(suite.T(), err)
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	defer db.Exec("delete from users")
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testHash", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, encoded)
+	require.Nil(suite.T(), err)
+	_, err = suite.controller.Authenticate(context.Background(), "testHash", encoded)
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	err = suite.controller.ChangePassword(context.Background(), "testHash", encoded, "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestAuthenticate_withWeakHash() {
+	weak, err := passwordhasher.New(&passwordhasher.Options{Algorithm: passwordhasher.Scrypt, Cost: 2})
+	require.Nil(suite.T(), err)
+	encoded, err := weak.Hash("testpwd")
+	require.Nil(suite.T(), err)
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testRehash", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, encoded)
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+
+	_, err = suite.controller.Authenticate(context.Background(), "testRehash", "testpwd")
+	require.Nil(suite.T(), err)
+	rec, err := suite.controller.findByUsername(context.Background(), "testRehash")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), suite.controller.passwordHasher.NeedsRehash(rec.Password))
+}
+func (suite *TestSuite) Testrehash_withConcurrentUpdate() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testRehash", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("newpwd"))
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+
+	stale := &userRecord{Username: "testRehash", Password: "testpwd"}
+	suite.controller.rehash(stale, "testpwd")
+	_, err = suite.controller.findByCredentials(context.Background(), "testRehash", "newpwd")
 	require.Nil(suite.T(), err)
 }
 func (suite *TestSuite) TestAuthenticate_withBadUser() {
-	_, err := suite.controller.Authenticate("", "")
+	_, err := suite.controller.Authenticate(context.Background(), "", "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestProvision() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &entities.User{Username: "jdoe@campus", Email: "jdoe@campus.example.org", DisplayName: "Jane Doe"}
+	require.Nil(suite.T(), suite.controller.Provision("campus", user))
+	rec, err := suite.controller.findByUsername(context.Background(), "jdoe@campus")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Jane Doe", rec.DisplayName)
+	require.Empty(suite.T(), rec.Password)
+
+	user.DisplayName = "Jane Roe"
+	require.Nil(suite.T(), suite.controller.Provision("campus", user))
+	rec, err = suite.controller.findByUsername(context.Background(), "jdoe@campus")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Jane Roe", rec.DisplayName)
+
+	// provisioned users can not sign in with a password.
+	_, err = suite.controller.Authenticate(context.Background(), "jdoe@campus", "")
+	require.NotNil(suite.T(), err)
+	counts, err := suite.controller.countLegacyPasswords()
+	require.Nil(suite.T(), err)
+	require.Empty(suite.T(), counts)
+}
+func (suite *TestSuite) TestProvision_withPassword() {
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testProvision", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
+	defer db.Exec("delete from users")
+	require.Nil(suite.T(), err)
+	err = suite.controller.Provision("campus", &entities.User{Username: "testProvision", DisplayName: "Other"})
+	require.Equal(suite.T(), authenticationcontroller.ErrUserExists, err)
+	rec, err := suite.controller.findByUsername(context.Background(), "testProvision")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Test", rec.DisplayName)
+}
+func (suite *TestSuite) TestProvision_withOtherProvider() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &entities.User{Username: "jdoe@campus", DisplayName: "Jane Doe"}
+	require.Nil(suite.T(), suite.controller.Provision("campus", user))
+	err := suite.controller.Provision("other", &entities.User{Username: "jdoe@campus", DisplayName: "Other"})
+	require.Equal(suite.T(), authenticationcontroller.ErrUserExists, err)
+
+	// local users without a password are not taken over either.
+	local := &authenticationcontroller.StoredUser{User: &entities.User{Username: "admin@campus"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), local, ""))
+	err = suite.controller.Provision("campus", &entities.User{Username: "admin@campus"})
+	require.Equal(suite.T(), authenticationcontroller.ErrUserExists, err)
+}
+func (suite *TestSuite) TestCreateUser() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testCreate", Email: "test@test.com"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, "testpwd"))
+	res, err := suite.controller.Authenticate(context.Background(), "testCreate", "testpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test@test.com", res.User.Email)
+
+	err = suite.controller.CreateUser(context.Background(), user, "otherpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserExists, err)
+	err = suite.controller.CreateUser(context.Background(), &authenticationcontroller.StoredUser{User: &entities.User{}}, "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestCreateUser_withoutPassword() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testCreate"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, ""))
+	_, err := suite.controller.Authenticate(context.Background(), "testCreate", "")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestGetUser() {
+	defer suite.controller.db.Exec("delete from users")
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testGet", "test@test.com", "Test", ?)`
+	_, err = db.Exec(sqlStmt, suite.hash("testpwd"))
+	require.Nil(suite.T(), err)
+	user, err := suite.controller.GetUser(context.Background(), "testGet")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), &authenticationcontroller.StoredUser{
+		User: &entities.User{Username: "testGet", Email: "test@test.com", DisplayName: "Test"},
+	}, user)
+	_, err = suite.controller.GetUser(context.Background(), "notfound")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestListUsers() {
+	defer suite.controller.db.Exec("delete from users")
+	for _, u := range []*entities.User{
+		{Username: "adoe", Email: "adoe@example.org", DisplayName: "Alice Doe"},
+		{Username: "bdoe", Email: "bdoe@example.org", DisplayName: "Bob Doe"},
+		{Username: "croe", Email: "croe@example.com", DisplayName: "Carol Roe"},
+		{Username: "d_roe", Email: "droe@example.com", DisplayName: "Dave Roe"},
+	} {
+		user := &authenticationcontroller.StoredUser{User: u, Disabled: u.Username == "bdoe"}
+		require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, ""))
+	}
+	usernames := func(filter *authenticationcontroller.UserFilter) ([]string, int) {
+		users, total, err := suite.controller.ListUsers(context.Background(), filter)
+		require.Nil(suite.T(), err)
+		names := []string{}
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		return names, total
+	}
+	names, total := usernames(&authenticationcontroller.UserFilter{})
+	require.Equal(suite.T(), []string{"adoe", "bdoe", "croe", "d_roe"}, names)
+	require.Equal(suite.T(), 4, total)
+
+	names, total = usernames(&authenticationcontroller.UserFilter{Offset: 1, Limit: 2})
+	require.Equal(suite.T(), []string{"bdoe", "croe"}, names)
+	require.Equal(suite.T(), 4, total)
+	names, _ = usernames(&authenticationcontroller.UserFilter{Offset: 3})
+	require.Equal(suite.T(), []string{"d_roe"}, names)
+
+	// the query matches the email and display name, regardless of the case.
+	names, total = usernames(&authenticationcontroller.UserFilter{Query: "DOE"})
+	require.Equal(suite.T(), []string{"adoe", "bdoe"}, names)
+	require.Equal(suite.T(), 2, total)
+	names, _ = usernames(&authenticationcontroller.UserFilter{Query: "example.com"})
+	require.Equal(suite.T(), []string{"croe", "d_roe"}, names)
+	// wildcards are matched literally.
+	names, _ = usernames(&authenticationcontroller.UserFilter{Query: "_"})
+	require.Equal(suite.T(), []string{"d_roe"}, names)
+
+	disabled := true
+	names, total = usernames(&authenticationcontroller.UserFilter{Query: "doe", Disabled: &disabled})
+	require.Equal(suite.T(), []string{"bdoe"}, names)
+	require.Equal(suite.T(), 1, total)
+}
+func (suite *TestSuite) TestUpdateUser() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testUpdate", Email: "test@test.com", DisplayName: "Test"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, "testpwd"))
+
+	displayName, password := "Other", "newpwd"
+	updated, err := suite.controller.UpdateUser(context.Background(), "testUpdate", &authenticationcontroller.UserUpdate{
+		DisplayName: &displayName,
+		Password:    &password,
+	})
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Other", updated.DisplayName)
+	require.Equal(suite.T(), "test@test.com", updated.Email)
+	_, err = suite.controller.Authenticate(context.Background(), "testUpdate", "testpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	res, err := suite.controller.Authenticate(context.Background(), "testUpdate", "newpwd")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Other", res.User.DisplayName)
+
+	empty := ""
+	_, err = suite.controller.UpdateUser(context.Background(), "testUpdate", &authenticationcontroller.UserUpdate{Password: &empty})
 	require.NotNil(suite.T(), err)
+	_, err = suite.controller.UpdateUser(context.Background(), "notfound", &authenticationcontroller.UserUpdate{DisplayName: &displayName})
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestUpdateUser_withDisabled() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testDisabled"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, "testpwd"))
+	disabled := true
+	updated, err := suite.controller.UpdateUser(context.Background(), "testDisabled", &authenticationcontroller.UserUpdate{Disabled: &disabled})
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), updated.Disabled)
+
+	_, err = suite.controller.Authenticate(context.Background(), "testDisabled", "testpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserDisabled, err)
+	// a wrong password does not tell the user is disabled.
+	_, err = suite.controller.Authenticate(context.Background(), "testDisabled", "notpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+}
+func (suite *TestSuite) TestDeleteUser() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testDelete"}}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, "testpwd"))
+	require.Nil(suite.T(), suite.controller.DeleteUser(context.Background(), "testDelete"))
+	_, err := suite.controller.GetUser(context.Background(), "testDelete")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+	err = suite.controller.DeleteUser(context.Background(), "testDelete")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+}
+func (suite *TestSuite) TestProvision_withDisabled() {
+	defer suite.controller.db.Exec("delete from users")
+	require.Nil(suite.T(), suite.controller.Provision("campus", &entities.User{Username: "jdoe@campus"}))
+	disabled := true
+	_, err := suite.controller.UpdateUser(context.Background(), "jdoe@campus", &authenticationcontroller.UserUpdate{Disabled: &disabled})
+	require.Nil(suite.T(), err)
+	err = suite.controller.Provision("campus", &entities.User{Username: "jdoe@campus"})
+	require.Equal(suite.T(), authenticationcontroller.ErrUserDisabled, err)
+}
+func (suite *TestSuite) TestChangePassword() {
+	defer suite.controller.db.Exec("delete from users")
+	db, err := sql.Open(suite.controller.driver, suite.controller.dsn)
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into users (username, email, display_name, password) values ("testChange", "test@test.com", "Test", "testpwd")`
+	_, err = db.Exec(sqlStmt)
+	require.Nil(suite.T(), err)
+
+	err = suite.controller.ChangePassword(context.Background(), "testChange", "notpwd", "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	err = suite.controller.ChangePassword(context.Background(), "notfound", "testpwd", "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserNotFound, err)
+
+	require.Nil(suite.T(), suite.controller.ChangePassword(context.Background(), "testChange", "testpwd", "newpwd"))
+	_, err = suite.controller.Authenticate(context.Background(), "testChange", "testpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+	_, err = suite.controller.Authenticate(context.Background(), "testChange", "newpwd")
+	require.Nil(suite.T(), err)
+	rec, err := suite.controller.findByUsername(context.Background(), "testChange")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), passwordhasher.Bcrypt, passwordhasher.Format(rec.Password))
+}
+func (suite *TestSuite) TestChangePassword_withDisabled() {
+	defer suite.controller.db.Exec("delete from users")
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: "testChange"}, Disabled: true}
+	require.Nil(suite.T(), suite.controller.CreateUser(context.Background(), user, "testpwd"))
+	err := suite.controller.ChangePassword(context.Background(), "testChange", "testpwd", "newpwd")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserDisabled, err)
+}
+
+func (suite *TestSuite) hash(password string) string {
+	encoded, err := suite.controller.passwordHasher.Hash(password)
+	require.Nil(suite.T(), err)
+	return encoded
 }
@@ -0,0 +1,195 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/entities"
+	"github.com/jinzhu/gorm"
+)
+
+// likeEscaper escapes the wildcards of LIKE patterns with the ESCAPE
+// character used by ListUsers, which is the same in every database.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (c *controller) ListUsers(ctx context.Context, filter *authenticationcontroller.UserFilter) ([]*authenticationcontroller.StoredUser, int, error) {
+	db := c.db.Model(&userRecord{})
+	if filter.Query != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(filter.Query)) + "%"
+		db = db.Where("LOWER(username) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!' OR LOWER(display_name) LIKE ? ESCAPE '!'",
+			pattern, pattern, pattern)
+	}
+	if filter.Disabled != nil {
+		db = db.Where("disabled=?", *filter.Disabled)
+	}
+	var total int
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 && filter.Offset > 0 {
+		// an offset without a limit is not valid SQL in every database.
+		limit = math.MaxInt32
+	}
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	if filter.Offset > 0 {
+		db = db.Offset(filter.Offset)
+	}
+	var recs []*userRecord
+	if err := db.Order("username").Find(&recs).Error; err != nil {
+		return nil, 0, err
+	}
+	users := make([]*authenticationcontroller.StoredUser, 0, len(recs))
+	for _, rec := range recs {
+		users = append(users, rec.storedUser())
+	}
+	return users, total, nil
+}
+
+func (c *controller) GetUser(ctx context.Context, username string) (*authenticationcontroller.StoredUser, error) {
+	rec, err := c.findByUsername(ctx, username)
+	if err == gorm.ErrRecordNotFound {
+		return nil, authenticationcontroller.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec.storedUser(), nil
+}
+
+func (c *controller) CreateUser(ctx context.Context, user *authenticationcontroller.StoredUser, password string) error {
+	if user.User == nil || user.Username == "" {
+		return errors.New("user has no username")
+	}
+	_, err := c.findByUsername(ctx, user.Username)
+	if err == nil {
+		return authenticationcontroller.ErrUserExists
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	rec := &userRecord{
+		Username:    user.Username,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Disabled:    user.Disabled,
+	}
+	if password != "" {
+		rec.Password, err = c.passwordHasher.Hash(password)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.db.Create(rec).Error; err != nil {
+		// the user may have been created concurrently since it was looked up,
+		// the constraint violation is told apart from other errors by looking
+		// it up again, as each driver reports it with its own error.
+		if _, findErr := c.findByUsername(ctx, user.Username); findErr == nil {
+			return authenticationcontroller.ErrUserExists
+		}
+		return err
+	}
+	return nil
+}
+
+// UpdateUser changes the fields of the update. A new password
+// is hashed with the configured algorithm and cost.
+func (c *controller) UpdateUser(ctx context.Context, username string, update *authenticationcontroller.UserUpdate) (*authenticationcontroller.StoredUser, error) {
+	rec, err := c.findByUsername(ctx, username)
+	if err == gorm.ErrRecordNotFound {
+		return nil, authenticationcontroller.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if update.Email != nil {
+		fields["email"] = *update.Email
+		rec.Email = *update.Email
+	}
+	if update.DisplayName != nil {
+		fields["display_name"] = *update.DisplayName
+		rec.DisplayName = *update.DisplayName
+	}
+	if update.Disabled != nil {
+		fields["disabled"] = *update.Disabled
+		rec.Disabled = *update.Disabled
+	}
+	oldPassword := rec.Password
+	if update.Password != nil {
+		if *update.Password == "" {
+			return nil, errors.New("password is empty")
+		}
+		fields["password"], err = c.passwordHasher.Hash(*update.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) == 0 {
+		return rec.storedUser(), nil
+	}
+	if err := c.db.Model(&userRecord{}).Where("username=?", username).Updates(fields).Error; err != nil {
+		return nil, err
+	}
+	if update.Password != nil {
+		c.forgetLegacyPassword(oldPassword)
+	}
+	return rec.storedUser(), nil
+}
+
+func (c *controller) DeleteUser(ctx context.Context, username string) error {
+	rec, err := c.findByUsername(ctx, username)
+	if err == gorm.ErrRecordNotFound {
+		return authenticationcontroller.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.db.Where("username=?", username).Delete(&userRecord{}).Error; err != nil {
+		return err
+	}
+	c.forgetLegacyPassword(rec.Password)
+	return nil
+}
+
+func (rec *userRecord) storedUser() *authenticationcontroller.StoredUser {
+	return &authenticationcontroller.StoredUser{
+		User: &entities.User{
+			Username:    rec.Username,
+			Email:       rec.Email,
+			DisplayName: rec.DisplayName,
+		},
+		Disabled: rec.Disabled,
+	}
+}
+
+// ChangePassword stores the hash of the new password. The row is only
+// updated if it still holds the hash the current password was verified
+// against, so a concurrent change is not overwritten.
+func (c *controller) ChangePassword(ctx context.Context, username, currentPassword, newPassword string) error {
+	rec, err := c.findByCredentials(ctx, username, currentPassword)
+	if err != nil {
+		return err
+	}
+	encoded, err := c.passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	db := c.db.Model(&userRecord{}).
+		Where("username=? AND password=?", username, rec.Password).
+		Update("password", encoded)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return errors.New("password of user " + username + " was changed concurrently")
+	}
+	c.forgetLegacyPassword(rec.Password)
+	return nil
+}
@@ -0,0 +1,61 @@
+package authenticationcontroller
+
+import (
+	"context"
+	"errors"
+
+	"github.com/clawio/entities"
+)
+
+var (
+	// ErrUserExists is returned by CreateUser
+	// when the username is already taken.
+	ErrUserExists = errors.New("user already exists")
+
+	// ErrUserDisabled is returned by Authenticate when the
+	// password matches but the user has been disabled.
+	ErrUserDisabled = errors.New("user is disabled")
+)
+
+// StoredUser is an user of a UserStore. Disabled users
+// are kept but can not be authenticated.
+type StoredUser struct {
+	*entities.User
+	Disabled bool `json:"disabled"`
+}
+
+// UserFilter selects the users returned by ListUsers, ordered by username.
+// Query matches a part of the username, email or display name regardless
+// of the case, and Disabled the users in that state; empty and nil match
+// every user. Offset users are skipped and at most Limit returned, zero
+// means no limit.
+type UserFilter struct {
+	Query    string
+	Disabled *bool
+	Offset   int
+	Limit    int
+}
+
+// UserUpdate holds the fields changed by UpdateUser, nil fields are
+// kept. Password is the plaintext password, hashed by the store.
+type UserUpdate struct {
+	Email       *string
+	DisplayName *string
+	Password    *string
+	Disabled    *bool
+}
+
+// UserStore is implemented by the controllers that can manage their
+// users. ErrUserNotFound is returned for usernames that do not exist.
+type UserStore interface {
+	// ListUsers returns a page of the users matching
+	// the filter and the total number of matching users.
+	ListUsers(ctx context.Context, filter *UserFilter) ([]*StoredUser, int, error)
+	GetUser(ctx context.Context, username string) (*StoredUser, error)
+
+	// CreateUser creates the user with the password, empty means
+	// the user can not authenticate until a password is set.
+	CreateUser(ctx context.Context, user *StoredUser, password string) error
+	UpdateUser(ctx context.Context, username string, update *UserUpdate) (*StoredUser, error)
+	DeleteUser(ctx context.Context, username string) error
+}
@@ -0,0 +1,77 @@
+package authorizationcodestore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/clawio/entities"
+)
+
+// DefaultTTL is the lifetime of an authorization code when none is configured.
+const DefaultTTL = time.Minute
+
+// ErrInvalidCode is returned when an authorization code does not
+// exist, has expired or has already been used.
+var ErrInvalidCode = errors.New("authorization code is invalid")
+
+// AuthorizationCode is what an authorization code was issued for.
+// CodeChallenge is the PKCE S256 challenge the code verifier must match.
+// Nonce and AuthTime are the OpenID Connect nonce of the request and
+// the time the user authenticated, copied to the ID token.
+type AuthorizationCode struct {
+	User          *entities.User
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+}
+
+// Verify reports whether the PKCE code verifier (RFC 7636) matches the
+// challenge of the code. Only the S256 method is supported.
+func (c *AuthorizationCode) Verify(codeVerifier string) bool {
+	// RFC 7636 verifiers are 43 to 128 characters long.
+	if len(codeVerifier) < 43 || len(codeVerifier) > 128 || c.CodeChallenge == "" {
+		return false
+	}
+	challenge := S256Challenge(codeVerifier)
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(c.CodeChallenge)) == 1
+}
+
+// S256Challenge returns the S256 PKCE code challenge of a code verifier.
+func S256Challenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthorizationCodeStore defines an interface to issue
+// short-lived single-use authorization codes.
+type AuthorizationCodeStore interface {
+	// Issue returns a new code for the authorization.
+	Issue(code *AuthorizationCode) (string, error)
+	// Consume returns the authorization of a code. A code
+	// can only be consumed once.
+	Consume(code string) (*AuthorizationCode, error)
+}
+
+// NewCode returns a new random opaque code and the hash to store.
+func NewCode() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	return code, Hash(code), nil
+}
+
+// Hash returns the hash of a code stored instead of the code itself.
+func Hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
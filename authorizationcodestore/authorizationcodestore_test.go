@@ -0,0 +1,41 @@
+package authorizationcodestore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) TestS256Challenge() {
+	// example of RFC 7636 appendix B.
+	challenge := S256Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	require.Equal(suite.T(), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", challenge)
+}
+func (suite *TestSuite) TestVerify() {
+	verifier := strings.Repeat("a", 43)
+	code := &AuthorizationCode{CodeChallenge: S256Challenge(verifier)}
+	require.True(suite.T(), code.Verify(verifier))
+	require.False(suite.T(), code.Verify(strings.Repeat("b", 43)))
+}
+func (suite *TestSuite) TestVerify_withShortVerifier() {
+	code := &AuthorizationCode{CodeChallenge: S256Challenge("short")}
+	require.False(suite.T(), code.Verify("short"))
+}
+func (suite *TestSuite) TestVerify_withoutChallenge() {
+	code := &AuthorizationCode{}
+	require.False(suite.T(), code.Verify(strings.Repeat("a", 43)))
+}
+func (suite *TestSuite) TestNewCode() {
+	code, hash, err := NewCode()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), Hash(code), hash)
+}
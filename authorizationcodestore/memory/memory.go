@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the codes, zero means authorizationcodestore.DefaultTTL.
+type Options struct {
+	TTL time.Duration
+}
+
+// New returns an AuthorizationCodeStore that keeps codes in memory.
+// Codes are not shared between instances of the service.
+func New(opts *Options) authorizationcodestore.AuthorizationCodeStore {
+	ttl := authorizationcodestore.DefaultTTL
+	if opts != nil && opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	return &store{
+		ttl:   ttl,
+		codes: map[string]*record{},
+		now:   time.Now,
+	}
+}
+
+type record struct {
+	code      *authorizationcodestore.AuthorizationCode
+	expiresAt time.Time
+}
+
+type store struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	codes map[string]*record
+	now   func() time.Time
+}
+
+func (s *store) Issue(code *authorizationcodestore.AuthorizationCode) (string, error) {
+	c, hash, err := authorizationcodestore.NewCode()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for h, rec := range s.codes {
+		if now.After(rec.expiresAt) {
+			delete(s.codes, h)
+		}
+	}
+	s.codes[hash] = &record{code: code, expiresAt: now.Add(s.ttl)}
+	return c, nil
+}
+
+func (s *store) Consume(code string) (*authorizationcodestore.AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := authorizationcodestore.Hash(code)
+	rec, ok := s.codes[hash]
+	if !ok {
+		return nil, authorizationcodestore.ErrInvalidCode
+	}
+	delete(s.codes, hash)
+	if s.now().After(rec.expiresAt) {
+		return nil, authorizationcodestore.ErrInvalidCode
+	}
+	return rec.code, nil
+}
@@ -0,0 +1,130 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/entities"
+	_ "github.com/go-sql-driver/mysql" // enable mysql driver
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // enable postgresql driver
+	_ "github.com/mattn/go-sqlite3" // enable sqlite3 driver
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the codes, zero means authorizationcodestore.DefaultTTL.
+type Options struct {
+	Driver, DSN string
+	TTL         time.Duration
+}
+
+// New returns an AuthorizationCodeStore that uses a SQL database, so codes
+// issued by an instance of the service can be redeemed on any other.
+// Only the hashes of the codes are stored.
+func New(opts *Options) (authorizationcodestore.AuthorizationCodeStore, error) {
+	db, err := gorm.Open(opts.Driver, opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&authorizationCodeRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	ttl := authorizationcodestore.DefaultTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	return &store{db: db, ttl: ttl, now: time.Now}, nil
+}
+
+type store struct {
+	db  *gorm.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+func (s *store) Issue(code *authorizationcodestore.AuthorizationCode) (string, error) {
+	c, hash, err := authorizationcodestore.NewCode()
+	if err != nil {
+		return "", err
+	}
+	now := s.now()
+	err = s.db.Where("expires_at < ?", now).Delete(&authorizationCodeRecord{}).Error
+	if err != nil {
+		return "", err
+	}
+	rec := &authorizationCodeRecord{
+		Hash:          hash,
+		Username:      code.User.Username,
+		Email:         code.User.Email,
+		DisplayName:   code.User.DisplayName,
+		ClientID:      code.ClientID,
+		RedirectURI:   code.RedirectURI,
+		Scope:         code.Scope,
+		CodeChallenge: code.CodeChallenge,
+		Nonce:         code.Nonce,
+		AuthTime:      code.AuthTime,
+		ExpiresAt:     now.Add(s.ttl),
+	}
+	if err := s.db.Create(rec).Error; err != nil {
+		return "", err
+	}
+	return c, nil
+}
+
+func (s *store) Consume(code string) (*authorizationcodestore.AuthorizationCode, error) {
+	hash := authorizationcodestore.Hash(code)
+	rec := &authorizationCodeRecord{}
+	err := s.db.Where("hash=?", hash).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, authorizationcodestore.ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	// only the request that deletes the code can use it, so
+	// the same code redeemed concurrently is accepted once.
+	res := s.db.Where("hash=?", hash).Delete(&authorizationCodeRecord{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 || s.now().After(rec.ExpiresAt) {
+		return nil, authorizationcodestore.ErrInvalidCode
+	}
+	return &authorizationcodestore.AuthorizationCode{
+		User: &entities.User{
+			Username:    rec.Username,
+			Email:       rec.Email,
+			DisplayName: rec.DisplayName,
+		},
+		ClientID:      rec.ClientID,
+		RedirectURI:   rec.RedirectURI,
+		Scope:         rec.Scope,
+		CodeChallenge: rec.CodeChallenge,
+		Nonce:         rec.Nonce,
+		AuthTime:      rec.AuthTime,
+	}, nil
+}
+
+type authorizationCodeRecord struct {
+	// Hash is the hash of the code, never the code itself.
+	Hash string `gorm:"primary_key"`
+
+	Username    string
+	Email       string
+	DisplayName string
+
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+	Nonce         string
+	AuthTime      time.Time
+
+	ExpiresAt time.Time
+}
+
+func (r authorizationCodeRecord) TableName() string {
+	return "authorization_codes"
+}
@@ -0,0 +1,85 @@
+package simple
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var code = &authorizationcodestore.AuthorizationCode{
+	User:          &entities.User{Username: "test", Email: "test@test.com", DisplayName: "Test"},
+	ClientID:      "client",
+	RedirectURI:   "http://localhost/callback",
+	Scope:         "read",
+	CodeChallenge: "challenge",
+	Nonce:         "nonce",
+	AuthTime:      time.Unix(1475000000, 0).UTC(),
+}
+
+type TestSuite struct {
+	suite.Suite
+	dir                    string
+	authorizationCodeStore authorizationcodestore.AuthorizationCodeStore
+	store                  *store
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-authorizationcodestore")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+	opts := &Options{
+		Driver: "sqlite3",
+		DSN:    filepath.Join(suite.dir, "authorizationcodestore.db"),
+		TTL:    time.Minute,
+	}
+	authorizationCodeStore, err := New(opts)
+	require.Nil(suite.T(), err)
+	suite.authorizationCodeStore = authorizationCodeStore
+	suite.store = authorizationCodeStore.(*store)
+}
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+func (suite *TestSuite) TestNew_withBadDriver() {
+	_, err := New(&Options{Driver: "thisnotexists", DSN: filepath.Join(suite.dir, "authorizationcodestore.db")})
+	require.NotNil(suite.T(), err)
+}
+
+func (suite *TestSuite) TestConsume() {
+	c, err := suite.authorizationCodeStore.Issue(code)
+	require.Nil(suite.T(), err)
+	consumed, err := suite.authorizationCodeStore.Consume(c)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), code, consumed)
+}
+func (suite *TestSuite) TestConsume_twice() {
+	c, err := suite.authorizationCodeStore.Issue(code)
+	require.Nil(suite.T(), err)
+	_, err = suite.authorizationCodeStore.Consume(c)
+	require.Nil(suite.T(), err)
+	_, err = suite.authorizationCodeStore.Consume(c)
+	require.Equal(suite.T(), authorizationcodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestConsume_withUnknownCode() {
+	_, err := suite.authorizationCodeStore.Consume("unknown")
+	require.Equal(suite.T(), authorizationcodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestConsume_withExpiredCode() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	c, err := suite.authorizationCodeStore.Issue(code)
+	require.Nil(suite.T(), err)
+	now = now.Add(2 * time.Minute)
+	_, err = suite.authorizationCodeStore.Consume(c)
+	require.Equal(suite.T(), authorizationcodestore.ErrInvalidCode, err)
+}
@@ -0,0 +1,37 @@
+package clientregistry
+
+import "errors"
+
+// ErrInvalidClient is returned when the client does not
+// exist or its secret does not match.
+var ErrInvalidClient = errors.New("client id or secret do not match")
+
+// Client is an OAuth 2.0 client, like a service that introspects tokens.
+// The secret is an encoded hash in any format supported by
+// the passwordhasher package, never the plaintext secret.
+// Public clients, like desktop applications, have no secret.
+// RedirectURIs are the URIs the authorization endpoint may redirect to.
+// Scope is the space separated list of scopes the client can request,
+// for its users or for itself with the client credentials grant.
+type Client struct {
+	ID           string   `json:"id"`
+	Secret       string   `json:"secret"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scope        string   `json:"scope"`
+}
+
+// IsPublic reports whether the client has no secret.
+func (c *Client) IsPublic() bool {
+	return c.Secret == ""
+}
+
+// ClientRegistry defines an interface to
+// authenticate OAuth 2.0 clients.
+type ClientRegistry interface {
+	// Authenticate returns the client if the secret matches.
+	// Public clients can not authenticate.
+	Authenticate(id, secret string) (*Client, error)
+	// FindByID returns the client identified by id.
+	FindByID(id string) (*Client, error)
+}
@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/authentication/passwordhasher"
+)
+
+// Options holds the configuration
+// parameters used by the registry.
+type Options struct {
+	Clients []*clientregistry.Client
+}
+
+// New returns a ClientRegistry with the clients given in the configuration.
+func New(opts *Options) (clientregistry.ClientRegistry, error) {
+	// dummyHash is verified when the client does not exist
+	// so unknown ids take as long as wrong secrets.
+	hasher, err := passwordhasher.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	dummyHash, err := hasher.Hash("")
+	if err != nil {
+		return nil, err
+	}
+	return &registry{clients: opts.Clients, dummyHash: dummyHash}, nil
+}
+
+type registry struct {
+	clients   []*clientregistry.Client
+	dummyHash string
+}
+
+func (r *registry) FindByID(id string) (*clientregistry.Client, error) {
+	for _, c := range r.clients {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, clientregistry.ErrInvalidClient
+}
+
+func (r *registry) Authenticate(id, secret string) (*clientregistry.Client, error) {
+	for _, c := range r.clients {
+		if c.ID != id || c.IsPublic() {
+			continue
+		}
+		ok, err := passwordhasher.Verify(c.Secret, secret)
+		if err != nil || !ok {
+			return nil, clientregistry.ErrInvalidClient
+		}
+		return c, nil
+	}
+	passwordhasher.Verify(r.dummyHash, secret)
+	return nil, clientregistry.ErrInvalidClient
+}
@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var clients = []*clientregistry.Client{
+	// bcrypt hash of "secret"
+	{ID: "test", Secret: "$2a$04$T/dwqYcp0JQnEQHTtka7ZOrpG154qRoVI2ci3JgKp6iDBQMOr1Ndi", Name: "Test"},
+	{ID: "badhash", Secret: "$md5$badhash"},
+	{ID: "public", RedirectURIs: []string{"http://localhost/callback"}},
+}
+
+type TestSuite struct {
+	suite.Suite
+	clientRegistry clientregistry.ClientRegistry
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	clientRegistry, err := New(&Options{Clients: clients})
+	require.Nil(suite.T(), err)
+	suite.clientRegistry = clientRegistry
+}
+
+func (suite *TestSuite) TestAuthenticate() {
+	client, err := suite.clientRegistry.Authenticate("test", "secret")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Test", client.Name)
+}
+func (suite *TestSuite) TestAuthenticate_withBadSecret() {
+	_, err := suite.clientRegistry.Authenticate("test", "bad")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestAuthenticate_withUnknownClient() {
+	_, err := suite.clientRegistry.Authenticate("unknown", "secret")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestAuthenticate_withBadHash() {
+	_, err := suite.clientRegistry.Authenticate("badhash", "secret")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestAuthenticate_withPublicClient() {
+	_, err := suite.clientRegistry.Authenticate("public", "")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestFindByID() {
+	client, err := suite.clientRegistry.FindByID("public")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), client.IsPublic())
+	require.Equal(suite.T(), []string{"http://localhost/callback"}, client.RedirectURIs)
+}
+func (suite *TestSuite) TestFindByID_withUnknownClient() {
+	_, err := suite.clientRegistry.FindByID("unknown")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
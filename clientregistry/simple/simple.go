@@ -0,0 +1,96 @@
+package simple
+
+import (
+	"strings"
+
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/authentication/passwordhasher"
+	_ "github.com/go-sql-driver/mysql" // enable mysql driver
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // enable postgresql driver
+	_ "github.com/mattn/go-sqlite3" // enable sqlite3 driver
+)
+
+// Options holds the configuration
+// parameters used by the registry.
+type Options struct {
+	Driver, DSN string
+}
+
+// New returns a ClientRegistry that uses a SQL database for handling clients.
+// Secrets are stored as encoded hashes, as printed by the hash-password command.
+func New(opts *Options) (clientregistry.ClientRegistry, error) {
+	db, err := gorm.Open(opts.Driver, opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&clientRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := passwordhasher.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	// dummyHash is verified when the client does not exist
+	// so unknown ids take as long as wrong secrets.
+	dummyHash, err := hasher.Hash("")
+	if err != nil {
+		return nil, err
+	}
+	return &registry{db: db, dummyHash: dummyHash}, nil
+}
+
+type registry struct {
+	db        *gorm.DB
+	dummyHash string
+}
+
+func (r *registry) FindByID(id string) (*clientregistry.Client, error) {
+	rec := &clientRecord{}
+	err := r.db.Where("id=?", id).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, clientregistry.ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &clientregistry.Client{
+		ID:           rec.ID,
+		Secret:       rec.Secret,
+		Name:         rec.Name,
+		RedirectURIs: strings.Fields(rec.RedirectURIs),
+		Scope:        rec.Scope,
+	}, nil
+}
+
+func (r *registry) Authenticate(id, secret string) (*clientregistry.Client, error) {
+	c, err := r.FindByID(id)
+	if err != nil {
+		passwordhasher.Verify(r.dummyHash, secret)
+		return nil, err
+	}
+	if c.IsPublic() {
+		return nil, clientregistry.ErrInvalidClient
+	}
+	ok, err := passwordhasher.Verify(c.Secret, secret)
+	if err != nil || !ok {
+		return nil, clientregistry.ErrInvalidClient
+	}
+	return c, nil
+}
+
+type clientRecord struct {
+	ID string `gorm:"primary_key"`
+	// Secret holds the encoded secret hash, never the plaintext secret.
+	// It is empty for public clients.
+	Secret string
+	Name   string
+	// RedirectURIs is the space separated list of redirect URIs.
+	RedirectURIs string
+	Scope        string
+}
+
+func (c clientRecord) TableName() string {
+	return "clients"
+}
@@ -0,0 +1,79 @@
+package simple
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// bcrypt hash of "secret"
+const secretHash = "$2a$04$T/dwqYcp0JQnEQHTtka7ZOrpG154qRoVI2ci3JgKp6iDBQMOr1Ndi"
+
+type TestSuite struct {
+	suite.Suite
+	dir            string
+	clientRegistry clientregistry.ClientRegistry
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-clientregistry")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+	clientRegistry, err := New(&Options{Driver: "sqlite3", DSN: filepath.Join(suite.dir, "clientregistry.db")})
+	require.Nil(suite.T(), err)
+	suite.clientRegistry = clientRegistry
+
+	db, err := sql.Open("sqlite3", filepath.Join(suite.dir, "clientregistry.db"))
+	require.Nil(suite.T(), err)
+	defer db.Close()
+	sqlStmt := `insert into clients (id, secret, name, redirect_uris, scope) values (?, ?, ?, ?, ?)`
+	_, err = db.Exec(sqlStmt, "data", secretHash, "Data", "", "metadata:read metadata:write")
+	require.Nil(suite.T(), err)
+	_, err = db.Exec(sqlStmt, "desktop", "", "Desktop", "http://127.0.0.1/callback http://[::1]/callback", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+func (suite *TestSuite) TestNew_withBadDriver() {
+	_, err := New(&Options{Driver: "thisnotexists", DSN: filepath.Join(suite.dir, "clientregistry.db")})
+	require.NotNil(suite.T(), err)
+}
+
+func (suite *TestSuite) TestAuthenticate() {
+	client, err := suite.clientRegistry.Authenticate("data", "secret")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "Data", client.Name)
+	require.Equal(suite.T(), "metadata:read metadata:write", client.Scope)
+}
+func (suite *TestSuite) TestAuthenticate_withBadSecret() {
+	_, err := suite.clientRegistry.Authenticate("data", "bad")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestAuthenticate_withUnknownClient() {
+	_, err := suite.clientRegistry.Authenticate("unknown", "secret")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestAuthenticate_withPublicClient() {
+	_, err := suite.clientRegistry.Authenticate("desktop", "")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
+func (suite *TestSuite) TestFindByID() {
+	client, err := suite.clientRegistry.FindByID("desktop")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), client.IsPublic())
+	require.Equal(suite.T(), []string{"http://127.0.0.1/callback", "http://[::1]/callback"}, client.RedirectURIs)
+}
+func (suite *TestSuite) TestFindByID_withUnknownClient() {
+	_, err := suite.clientRegistry.FindByID("unknown")
+	require.Equal(suite.T(), clientregistry.ErrInvalidClient, err)
+}
@@ -0,0 +1,130 @@
+package devicecodestore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/clawio/entities"
+)
+
+const (
+	// DefaultTTL is the lifetime of a device code when none is configured.
+	DefaultTTL = 10 * time.Minute
+
+	// DefaultInterval is the minimum time between two polls of
+	// a device code when none is configured.
+	DefaultInterval = 5 * time.Second
+
+	// SlowDownInterval is added to the polling interval of a
+	// device code every time it is polled too fast (RFC 8628 section 3.5).
+	SlowDownInterval = 5 * time.Second
+)
+
+var (
+	// ErrInvalidCode is returned when a code does not exist, or a
+	// user code has expired or has already been approved or denied.
+	ErrInvalidCode = errors.New("device code is invalid")
+
+	// ErrAuthorizationPending is returned while the user has
+	// not approved or denied the authorization yet.
+	ErrAuthorizationPending = errors.New("authorization is pending")
+
+	// ErrSlowDown is returned when a device code is polled
+	// before its polling interval elapsed.
+	ErrSlowDown = errors.New("device code is polled too fast")
+
+	// ErrAccessDenied is returned when the user denied the authorization.
+	ErrAccessDenied = errors.New("authorization was denied")
+
+	// ErrExpiredToken is returned when a device code has expired.
+	ErrExpiredToken = errors.New("device code has expired")
+)
+
+// DeviceAuthorization is what a device code was issued for.
+// User is nil until the user approves the authorization.
+type DeviceAuthorization struct {
+	ClientID string
+	Scope    string
+	User     *entities.User
+}
+
+// Codes are the codes issued for a device authorization. The device
+// code is kept by the device and the user code is entered by the user
+// on the verification page.
+type Codes struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
+
+// DeviceCodeStore defines an interface to keep the authorizations
+// of the device authorization grant (RFC 8628).
+type DeviceCodeStore interface {
+	// Issue returns new codes for the authorization.
+	Issue(authorization *DeviceAuthorization) (*Codes, error)
+	// Find returns the pending authorization of a user code.
+	Find(userCode string) (*DeviceAuthorization, error)
+	// Approve grants the pending authorization of a user code to the user.
+	Approve(userCode string, user *entities.User) error
+	// Deny denies the pending authorization of a user code.
+	Deny(userCode string) error
+	// Poll returns the authorization of a device code once the user
+	// approved it. A device code can only be exchanged once.
+	Poll(deviceCode string) (*DeviceAuthorization, error)
+}
+
+// userCodeAlphabet has no vowels, so user codes do not spell
+// words, and no characters that are easily mistaken for each other.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// NewDeviceCode returns a new random opaque device code and the hash to store.
+func NewDeviceCode() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	return code, Hash(code), nil
+}
+
+// NewUserCode returns a new random user code, formatted as
+// XXXX-XXXX, and the hash to store.
+func NewUserCode() (string, string, error) {
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+		}
+		if _, err := rand.Read(b); err != nil {
+			return "", "", err
+		}
+		// bytes above the last multiple of the alphabet
+		// length are discarded so every character is equally likely.
+		if int(b[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code), HashUserCode(string(code)), nil
+}
+
+// HashUserCode returns the hash of a user code as typed by the user.
+// Case, dashes and spaces are ignored.
+func HashUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.NewReplacer("-", "", " ", "").Replace(userCode)
+	return Hash(userCode)
+}
+
+// Hash returns the hash of a code stored instead of the code itself.
+func Hash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
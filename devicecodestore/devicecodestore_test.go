@@ -0,0 +1,33 @@
+package devicecodestore
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) TestNewDeviceCode() {
+	code, hash, err := NewDeviceCode()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), Hash(code), hash)
+}
+func (suite *TestSuite) TestNewUserCode() {
+	code, hash, err := NewUserCode()
+	require.Nil(suite.T(), err)
+	require.Regexp(suite.T(), regexp.MustCompile("^["+userCodeAlphabet+"]{4}-["+userCodeAlphabet+"]{4}$"), code)
+	require.Equal(suite.T(), HashUserCode(code), hash)
+}
+func (suite *TestSuite) TestHashUserCode() {
+	require.Equal(suite.T(), HashUserCode("BCDF-GHJK"), HashUserCode("bcdf ghjk"))
+	require.Equal(suite.T(), HashUserCode("BCDF-GHJK"), HashUserCode("BCDFGHJK"))
+	require.NotEqual(suite.T(), HashUserCode("BCDF-GHJK"), HashUserCode("BCDF-GHJL"))
+}
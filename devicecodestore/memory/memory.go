@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/devicecodestore"
+	"github.com/clawio/entities"
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the codes, zero means devicecodestore.DefaultTTL.
+// Interval is the minimum time between polls, zero means devicecodestore.DefaultInterval.
+type Options struct {
+	TTL      time.Duration
+	Interval time.Duration
+}
+
+// New returns a DeviceCodeStore that keeps codes in memory.
+// Codes are not shared between instances of the service.
+func New(opts *Options) devicecodestore.DeviceCodeStore {
+	ttl := devicecodestore.DefaultTTL
+	interval := devicecodestore.DefaultInterval
+	if opts != nil && opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	if opts != nil && opts.Interval > 0 {
+		interval = opts.Interval
+	}
+	return &store{
+		ttl:      ttl,
+		interval: interval,
+		codes:    map[string]*record{},
+		now:      time.Now,
+	}
+}
+
+const (
+	pending = iota
+	approved
+	denied
+)
+
+type record struct {
+	authorization *devicecodestore.DeviceAuthorization
+	userCodeHash  string
+	status        int
+	interval      time.Duration
+	lastPolledAt  time.Time
+	expiresAt     time.Time
+}
+
+type store struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	interval time.Duration
+	// codes is indexed by the hash of the device code.
+	codes map[string]*record
+	now   func() time.Time
+}
+
+func (s *store) Issue(authorization *devicecodestore.DeviceAuthorization) (*devicecodestore.Codes, error) {
+	deviceCode, deviceCodeHash, err := devicecodestore.NewDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	userCodes := map[string]bool{}
+	for h, rec := range s.codes {
+		// expired codes are kept for another TTL so
+		// devices polling them get expired_token.
+		if now.After(rec.expiresAt.Add(s.ttl)) {
+			delete(s.codes, h)
+			continue
+		}
+		userCodes[rec.userCodeHash] = true
+	}
+	// user codes are short, so they are drawn again
+	// until they do not match the code of another device.
+	var userCode, userCodeHash string
+	for userCodeHash == "" || userCodes[userCodeHash] {
+		userCode, userCodeHash, err = devicecodestore.NewUserCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.codes[deviceCodeHash] = &record{
+		authorization: &devicecodestore.DeviceAuthorization{
+			ClientID: authorization.ClientID,
+			Scope:    authorization.Scope,
+		},
+		userCodeHash: userCodeHash,
+		interval:     s.interval,
+		expiresAt:    now.Add(s.ttl),
+	}
+	return &devicecodestore.Codes{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ExpiresIn:  s.ttl,
+		Interval:   s.interval,
+	}, nil
+}
+
+func (s *store) Find(userCode string) (*devicecodestore.DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.findPending(userCode)
+	if rec == nil {
+		return nil, devicecodestore.ErrInvalidCode
+	}
+	authorization := *rec.authorization
+	return &authorization, nil
+}
+
+func (s *store) Approve(userCode string, user *entities.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.findPending(userCode)
+	if rec == nil {
+		return devicecodestore.ErrInvalidCode
+	}
+	rec.status = approved
+	rec.authorization.User = user
+	return nil
+}
+
+func (s *store) Deny(userCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.findPending(userCode)
+	if rec == nil {
+		return devicecodestore.ErrInvalidCode
+	}
+	rec.status = denied
+	return nil
+}
+
+func (s *store) Poll(deviceCode string) (*devicecodestore.DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := devicecodestore.Hash(deviceCode)
+	rec, ok := s.codes[hash]
+	if !ok {
+		return nil, devicecodestore.ErrInvalidCode
+	}
+	now := s.now()
+	if now.After(rec.expiresAt) {
+		delete(s.codes, hash)
+		return nil, devicecodestore.ErrExpiredToken
+	}
+	switch rec.status {
+	case approved:
+		delete(s.codes, hash)
+		return rec.authorization, nil
+	case denied:
+		delete(s.codes, hash)
+		return nil, devicecodestore.ErrAccessDenied
+	}
+	lastPolledAt := rec.lastPolledAt
+	rec.lastPolledAt = now
+	if now.Before(lastPolledAt.Add(rec.interval)) {
+		rec.interval += devicecodestore.SlowDownInterval
+		return nil, devicecodestore.ErrSlowDown
+	}
+	return nil, devicecodestore.ErrAuthorizationPending
+}
+
+func (s *store) findPending(userCode string) *record {
+	hash := devicecodestore.HashUserCode(userCode)
+	now := s.now()
+	for _, rec := range s.codes {
+		if rec.userCodeHash == hash && rec.status == pending && !now.After(rec.expiresAt) {
+			return rec
+		}
+	}
+	return nil
+}
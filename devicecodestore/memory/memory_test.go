@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/devicecodestore"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var (
+	authorization = &devicecodestore.DeviceAuthorization{ClientID: "cli", Scope: "read"}
+	user          = &entities.User{Username: "test", Email: "test@test.com", DisplayName: "Test"}
+)
+
+type TestSuite struct {
+	suite.Suite
+	deviceCodeStore devicecodestore.DeviceCodeStore
+	store           *store
+	now             time.Time
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	suite.deviceCodeStore = New(&Options{TTL: time.Minute, Interval: 5 * time.Second})
+	suite.store = suite.deviceCodeStore.(*store)
+	suite.now = time.Now()
+	suite.store.now = func() time.Time { return suite.now }
+}
+
+func (suite *TestSuite) TestIssue() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	require.NotEmpty(suite.T(), codes.DeviceCode)
+	require.NotEmpty(suite.T(), codes.UserCode)
+	require.Equal(suite.T(), time.Minute, codes.ExpiresIn)
+	require.Equal(suite.T(), 5*time.Second, codes.Interval)
+}
+func (suite *TestSuite) TestFind() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	found, err := suite.deviceCodeStore.Find(codes.UserCode)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), authorization, found)
+}
+func (suite *TestSuite) TestFind_withUnknownCode() {
+	_, err := suite.deviceCodeStore.Find("BCDF-GHJK")
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestFind_withExpiredCode() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	suite.now = suite.now.Add(2 * time.Minute)
+	_, err = suite.deviceCodeStore.Find(codes.UserCode)
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestPoll() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrAuthorizationPending, err)
+
+	require.Nil(suite.T(), suite.deviceCodeStore.Approve(codes.UserCode, user))
+	polled, err := suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "cli", polled.ClientID)
+	require.Equal(suite.T(), "read", polled.Scope)
+	require.Equal(suite.T(), user, polled.User)
+
+	// a device code can only be exchanged once.
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestPoll_withUnknownCode() {
+	_, err := suite.deviceCodeStore.Poll("unknown")
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, err)
+}
+func (suite *TestSuite) TestPoll_withDeniedCode() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.deviceCodeStore.Deny(codes.UserCode))
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrAccessDenied, err)
+}
+func (suite *TestSuite) TestPoll_withExpiredCode() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	suite.now = suite.now.Add(2 * time.Minute)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrExpiredToken, err)
+}
+func (suite *TestSuite) TestPoll_tooFast() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrAuthorizationPending, err)
+	suite.now = suite.now.Add(time.Second)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrSlowDown, err)
+
+	// the interval grows by five seconds after slow_down.
+	suite.now = suite.now.Add(6 * time.Second)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrSlowDown, err)
+	suite.now = suite.now.Add(15 * time.Second)
+	_, err = suite.deviceCodeStore.Poll(codes.DeviceCode)
+	require.Equal(suite.T(), devicecodestore.ErrAuthorizationPending, err)
+}
+func (suite *TestSuite) TestApprove_twice() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.deviceCodeStore.Approve(codes.UserCode, user))
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, suite.deviceCodeStore.Approve(codes.UserCode, user))
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, suite.deviceCodeStore.Deny(codes.UserCode))
+}
+func (suite *TestSuite) TestApprove_withExpiredCode() {
+	codes, err := suite.deviceCodeStore.Issue(authorization)
+	require.Nil(suite.T(), err)
+	suite.now = suite.now.Add(2 * time.Minute)
+	require.Equal(suite.T(), devicecodestore.ErrInvalidCode, suite.deviceCodeStore.Approve(codes.UserCode, user))
+}
@@ -0,0 +1,217 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/clawio/authentication/devicecodestore"
+	"github.com/clawio/entities"
+	_ "github.com/go-sql-driver/mysql" // enable mysql driver
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // enable postgresql driver
+	_ "github.com/mattn/go-sqlite3" // enable sqlite3 driver
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the codes, zero means devicecodestore.DefaultTTL.
+// Interval is the minimum time between polls, zero means devicecodestore.DefaultInterval.
+type Options struct {
+	Driver, DSN string
+	TTL         time.Duration
+	Interval    time.Duration
+}
+
+// New returns a DeviceCodeStore that uses a SQL database, so a device
+// can poll any instance of the service. Only the hashes of the codes are stored.
+func New(opts *Options) (devicecodestore.DeviceCodeStore, error) {
+	db, err := gorm.Open(opts.Driver, opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&deviceCodeRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	ttl := devicecodestore.DefaultTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	interval := devicecodestore.DefaultInterval
+	if opts.Interval > 0 {
+		interval = opts.Interval
+	}
+	return &store{db: db, ttl: ttl, interval: interval, now: time.Now}, nil
+}
+
+const (
+	pending  = "pending"
+	approved = "approved"
+	denied   = "denied"
+)
+
+type store struct {
+	db       *gorm.DB
+	ttl      time.Duration
+	interval time.Duration
+	now      func() time.Time
+}
+
+func (s *store) Issue(authorization *devicecodestore.DeviceAuthorization) (*devicecodestore.Codes, error) {
+	deviceCode, deviceCodeHash, err := devicecodestore.NewDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+	userCode, userCodeHash, err := devicecodestore.NewUserCode()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	// expired codes are kept for another TTL so
+	// devices polling them get expired_token.
+	err = s.db.Where("expires_at < ?", now.Add(-s.ttl)).Delete(&deviceCodeRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	rec := &deviceCodeRecord{
+		Hash:         deviceCodeHash,
+		UserCodeHash: userCodeHash,
+		ClientID:     authorization.ClientID,
+		Scope:        authorization.Scope,
+		Status:       pending,
+		Interval:     int64(s.interval / time.Second),
+		ExpiresAt:    now.Add(s.ttl),
+	}
+	// the unique index on the user code rejects the
+	// unlikely code that matches the code of another device.
+	if err := s.db.Create(rec).Error; err != nil {
+		return nil, err
+	}
+	return &devicecodestore.Codes{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ExpiresIn:  s.ttl,
+		Interval:   s.interval,
+	}, nil
+}
+
+func (s *store) Find(userCode string) (*devicecodestore.DeviceAuthorization, error) {
+	rec := &deviceCodeRecord{}
+	err := s.db.Where("user_code_hash=? AND status=? AND expires_at >= ?", devicecodestore.HashUserCode(userCode), pending, s.now()).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, devicecodestore.ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &devicecodestore.DeviceAuthorization{ClientID: rec.ClientID, Scope: rec.Scope}, nil
+}
+
+func (s *store) Approve(userCode string, user *entities.User) error {
+	return s.decide(userCode, map[string]interface{}{
+		"status":       approved,
+		"username":     user.Username,
+		"email":        user.Email,
+		"display_name": user.DisplayName,
+	})
+}
+
+func (s *store) Deny(userCode string) error {
+	return s.decide(userCode, map[string]interface{}{"status": denied})
+}
+
+// decide updates a pending code only, so a code approved
+// or denied concurrently is decided once.
+func (s *store) decide(userCode string, values map[string]interface{}) error {
+	res := s.db.Model(&deviceCodeRecord{}).
+		Where("user_code_hash=? AND status=? AND expires_at >= ?", devicecodestore.HashUserCode(userCode), pending, s.now()).
+		Updates(values)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return devicecodestore.ErrInvalidCode
+	}
+	return nil
+}
+
+func (s *store) Poll(deviceCode string) (*devicecodestore.DeviceAuthorization, error) {
+	hash := devicecodestore.Hash(deviceCode)
+	rec := &deviceCodeRecord{}
+	err := s.db.Where("hash=?", hash).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, devicecodestore.ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if now.After(rec.ExpiresAt) || rec.Status != pending {
+		// only the request that deletes the code can use it, so
+		// the same code polled concurrently is exchanged once.
+		res := s.db.Where("hash=?", hash).Delete(&deviceCodeRecord{})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, devicecodestore.ErrInvalidCode
+		}
+	}
+	if now.After(rec.ExpiresAt) {
+		return nil, devicecodestore.ErrExpiredToken
+	}
+	switch rec.Status {
+	case approved:
+		return &devicecodestore.DeviceAuthorization{
+			ClientID: rec.ClientID,
+			Scope:    rec.Scope,
+			User: &entities.User{
+				Username:    rec.Username,
+				Email:       rec.Email,
+				DisplayName: rec.DisplayName,
+			},
+		}, nil
+	case denied:
+		return nil, devicecodestore.ErrAccessDenied
+	}
+
+	values := map[string]interface{}{"last_polled_at": now}
+	interval := time.Duration(rec.Interval) * time.Second
+	slowDown := rec.LastPolledAt != nil && now.Before(rec.LastPolledAt.Add(interval))
+	if slowDown {
+		values["interval"] = int64((interval + devicecodestore.SlowDownInterval) / time.Second)
+	}
+	err = s.db.Model(&deviceCodeRecord{}).Where("hash=?", hash).Updates(values).Error
+	if err != nil {
+		return nil, err
+	}
+	if slowDown {
+		return nil, devicecodestore.ErrSlowDown
+	}
+	return nil, devicecodestore.ErrAuthorizationPending
+}
+
+type deviceCodeRecord struct {
+	// Hash is the hash of the device code, never the code itself.
+	Hash         string `gorm:"primary_key"`
+	UserCodeHash string `gorm:"unique_index"`
+
+	ClientID string
+	Scope    string
+
+	// Status is pending until the user approves or denies the code.
+	Status string
+
+	// the user that approved the code.
+	Username    string
+	Email       string
+	DisplayName string
+
+	// Interval is the minimum time between polls in seconds.
+	Interval     int64
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+}
+
+func (r deviceCodeRecord) TableName() string {
+	return "device_codes"
+}
@@ -0,0 +1,237 @@
+package federation
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/entities"
+)
+
+// DefaultLoginTTL is how long an user has to sign in
+// with the upstream provider once a login started.
+const DefaultLoginTTL = 10 * time.Minute
+
+// DefaultTimeout bounds the requests to the
+// providers made with the default client.
+const DefaultTimeout = 10 * time.Second
+
+// maxResponseSize bounds the responses of the providers.
+const maxResponseSize = 1 << 20
+
+// defaultClient is used when the Options have no Client.
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+var (
+	// ErrUnknownProvider is returned when a login names
+	// a provider that is not configured.
+	ErrUnknownProvider = errors.New("identity provider does not exist")
+
+	// ErrInvalidState is returned when a callback does not match
+	// a login in progress, or the login has expired.
+	ErrInvalidState = errors.New("login state is invalid")
+
+	// ErrUserNotAllowed is returned when the upstream claims of
+	// an user do not satisfy the Require rules of the provider.
+	ErrUserNotAllowed = errors.New("user is not allowed by the identity provider rules")
+)
+
+// Provisioner creates or updates the users signed in through an upstream
+// provider, so they exist in the user store of the service. provider is the
+// ID of the provider the user signed in with: existing users that were not
+// provisioned by it must be refused, so that an upstream account never takes
+// over a local one or one of another provider.
+type Provisioner interface {
+	Provision(provider string, user *entities.User) error
+}
+
+// Options holds the configuration
+// parameters used by the federation.
+// When Provisioner is nil users are not provisioned.
+// LoginTTL is the time to complete a login, zero means DefaultLoginTTL.
+// Client makes the requests to the providers, nil means a
+// client that times out after DefaultTimeout.
+type Options struct {
+	Providers   []*ProviderConfig
+	Provisioner Provisioner
+	LoginTTL    time.Duration
+	Client      *http.Client
+}
+
+// Federation runs the OpenID Connect relying party flow against upstream
+// identity providers. A login starts with AuthCodeURL, which redirects the
+// user to the provider, and finishes with Exchange when the provider
+// redirects the user back with a code.
+// Logins in progress are kept in memory, so the callback must reach
+// the instance of the service that started the login.
+type Federation struct {
+	providers   map[string]*provider
+	order       []*provider
+	provisioner Provisioner
+	ttl         time.Duration
+
+	mu     sync.Mutex
+	logins map[string]*login
+	now    func() time.Time
+}
+
+// login is a login in progress, indexed by its state.
+type login struct {
+	provider     *provider
+	redirectURI  string
+	nonce        string
+	codeVerifier string
+	data         string
+	expiresAt    time.Time
+}
+
+// New returns a Federation for the configured providers. The metadata
+// of the providers is discovered on their first login.
+func New(opts *Options) (*Federation, error) {
+	client := opts.Client
+	if client == nil {
+		client = defaultClient
+	}
+	ttl := DefaultLoginTTL
+	if opts.LoginTTL > 0 {
+		ttl = opts.LoginTTL
+	}
+	f := &Federation{
+		providers:   map[string]*provider{},
+		provisioner: opts.Provisioner,
+		ttl:         ttl,
+		logins:      map[string]*login{},
+		now:         time.Now,
+	}
+	for _, cfg := range opts.Providers {
+		p, err := newProvider(cfg, client)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := f.providers[p.cfg.ID]; ok {
+			return nil, errors.New("identity provider " + p.cfg.ID + " is configured twice")
+		}
+		f.providers[p.cfg.ID] = p
+		f.order = append(f.order, p)
+	}
+	return f, nil
+}
+
+// Providers returns the configured providers in configuration order.
+func (f *Federation) Providers() []*ProviderConfig {
+	providers := []*ProviderConfig{}
+	for _, p := range f.order {
+		providers = append(providers, p.cfg)
+	}
+	return providers
+}
+
+// AuthCodeURL starts a login with the provider and returns the URL of its
+// authorization endpoint, where the user must be redirected. The provider
+// redirects the user back to redirectURI. data is returned by Exchange.
+func (f *Federation) AuthCodeURL(providerID, redirectURI, data string) (string, error) {
+	p, ok := f.providers[providerID]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	now := f.now()
+	for s, l := range f.logins {
+		if now.After(l.expiresAt) {
+			delete(f.logins, s)
+		}
+	}
+	f.logins[state] = &login{
+		provider:     p,
+		redirectURI:  redirectURI,
+		nonce:        nonce,
+		codeVerifier: codeVerifier,
+		data:         data,
+		expiresAt:    now.Add(f.ttl),
+	}
+	f.mu.Unlock()
+	return p.authCodeURL(metadata, redirectURI, state, nonce, codeVerifier), nil
+}
+
+// Exchange finishes the login of state: it redeems the code on the token
+// endpoint of the provider, verifies the ID token and maps its claims to
+// an user, provisioned when a Provisioner is configured. It returns the
+// user and the data given to AuthCodeURL, also on errors once the state
+// is valid. A login can only finish once.
+func (f *Federation) Exchange(state, code string) (*entities.User, string, error) {
+	f.mu.Lock()
+	l, ok := f.logins[state]
+	delete(f.logins, state)
+	f.mu.Unlock()
+	if !ok || f.now().After(l.expiresAt) {
+		return nil, "", ErrInvalidState
+	}
+
+	claims, err := l.provider.exchange(code, l.redirectURI, l.nonce, l.codeVerifier)
+	if err != nil {
+		return nil, l.data, err
+	}
+	user, err := l.provider.user(claims)
+	if err != nil {
+		return nil, l.data, err
+	}
+	if f.provisioner != nil {
+		if err := f.provisioner.Provision(l.provider.cfg.ID, user); err != nil {
+			return nil, l.data, err
+		}
+	}
+	return user, l.data, nil
+}
+
+// IsFederated reports whether the username is in the namespace
+// of a configured provider, that is whether it ends with @ID.
+func (f *Federation) IsFederated(username string) bool {
+	for _, p := range f.order {
+		if strings.HasSuffix(username, "@"+p.cfg.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cancel forgets the login of state, when the provider redirects the user
+// back with an error. It returns the data given to AuthCodeURL.
+func (f *Federation) Cancel(state string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.logins[state]
+	delete(f.logins, state)
+	if !ok || f.now().After(l.expiresAt) {
+		return "", ErrInvalidState
+	}
+	return l.data, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,235 @@
+package federation
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/federation/federationtest"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const redirectURI = "http://localhost/federation/callback"
+
+var claims = map[string]interface{}{
+	"sub":                "248289761001",
+	"preferred_username": "jdoe",
+	"email":              "jdoe@campus.example.org",
+	"name":               "Jane Doe",
+	"groups":             []interface{}{"staff", "students"},
+}
+
+type provisioner struct {
+	provider string
+	users    []*entities.User
+	err      error
+}
+
+func (p *provisioner) Provision(provider string, user *entities.User) error {
+	p.provider = provider
+	p.users = append(p.users, user)
+	return p.err
+}
+
+type TestSuite struct {
+	suite.Suite
+	issuer      *federationtest.Issuer
+	provider    *ProviderConfig
+	provisioner *provisioner
+	federation  *Federation
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	suite.issuer = federationtest.NewIssuer("clawio", "secret")
+	suite.provider = &ProviderConfig{
+		ID:           "campus",
+		Name:         "Campus",
+		Issuer:       suite.issuer.URL,
+		ClientID:     "clawio",
+		ClientSecret: "secret",
+	}
+	suite.provisioner = &provisioner{}
+	f, err := New(&Options{Providers: []*ProviderConfig{suite.provider}, Provisioner: suite.provisioner})
+	require.Nil(suite.T(), err)
+	suite.federation = f
+}
+func (suite *TestSuite) TearDownTest() {
+	suite.issuer.Close()
+}
+
+func (suite *TestSuite) TestNew_withoutIssuer() {
+	_, err := New(&Options{Providers: []*ProviderConfig{{ID: "campus", ClientID: "clawio"}}})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withDuplicatedProvider() {
+	_, err := New(&Options{Providers: []*ProviderConfig{suite.provider, suite.provider}})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestProviders() {
+	require.Equal(suite.T(), []*ProviderConfig{suite.provider}, suite.federation.Providers())
+}
+func (suite *TestSuite) TestAuthCodeURL() {
+	authCodeURL, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.Nil(suite.T(), err)
+	u, err := url.Parse(authCodeURL)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), suite.issuer.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(suite.T(), "clawio", u.Query().Get("client_id"))
+	require.Equal(suite.T(), redirectURI, u.Query().Get("redirect_uri"))
+	require.Equal(suite.T(), DefaultScope, u.Query().Get("scope"))
+	require.Equal(suite.T(), "S256", u.Query().Get("code_challenge_method"))
+	require.NotEmpty(suite.T(), u.Query().Get("state"))
+	require.NotEmpty(suite.T(), u.Query().Get("nonce"))
+}
+func (suite *TestSuite) TestAuthCodeURL_withUnknownProvider() {
+	_, err := suite.federation.AuthCodeURL("unknown", redirectURI, "data")
+	require.Equal(suite.T(), ErrUnknownProvider, err)
+}
+func (suite *TestSuite) TestAuthCodeURL_withUnreachableIssuer() {
+	suite.provider.Issuer = "http://127.0.0.1:1"
+	_, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestExchange() {
+	user, data, err := suite.login(claims)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "data", data)
+	expected := &entities.User{Username: "jdoe@campus", Email: "jdoe@campus.example.org", DisplayName: "Jane Doe"}
+	require.Equal(suite.T(), expected, user)
+	require.Equal(suite.T(), []*entities.User{expected}, suite.provisioner.users)
+	require.Equal(suite.T(), "campus", suite.provisioner.provider)
+}
+func (suite *TestSuite) TestExchange_withLocalUsername() {
+	// an upstream admin does not become the local admin.
+	user, _, err := suite.login(withClaim("preferred_username", "admin"))
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "admin@campus", user.Username)
+	require.True(suite.T(), suite.federation.IsFederated(user.Username))
+	require.False(suite.T(), suite.federation.IsFederated("admin"))
+	require.False(suite.T(), suite.federation.IsFederated("admin@other"))
+}
+func (suite *TestSuite) TestExchange_twice() {
+	authCodeURL, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.Nil(suite.T(), err)
+	callback, err := suite.issuer.Authorize(authCodeURL, claims)
+	require.Nil(suite.T(), err)
+	_, _, err = suite.federation.Exchange(callback.Query().Get("state"), callback.Query().Get("code"))
+	require.Nil(suite.T(), err)
+	_, _, err = suite.federation.Exchange(callback.Query().Get("state"), callback.Query().Get("code"))
+	require.Equal(suite.T(), ErrInvalidState, err)
+}
+func (suite *TestSuite) TestExchange_withExpiredLogin() {
+	now := time.Now()
+	suite.federation.now = func() time.Time { return now }
+	authCodeURL, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.Nil(suite.T(), err)
+	callback, err := suite.issuer.Authorize(authCodeURL, claims)
+	require.Nil(suite.T(), err)
+	now = now.Add(DefaultLoginTTL + time.Second)
+	_, _, err = suite.federation.Exchange(callback.Query().Get("state"), callback.Query().Get("code"))
+	require.Equal(suite.T(), ErrInvalidState, err)
+}
+func (suite *TestSuite) TestExchange_withBadNonce() {
+	_, data, err := suite.login(withClaim("nonce", "other"))
+	require.Equal(suite.T(), ErrInvalidIDToken, err)
+	require.Equal(suite.T(), "data", data)
+}
+func (suite *TestSuite) TestExchange_withOtherAudience() {
+	_, _, err := suite.login(withClaim("aud", "other"))
+	require.Equal(suite.T(), ErrInvalidIDToken, err)
+}
+func (suite *TestSuite) TestExchange_withAudienceList() {
+	_, _, err := suite.login(withClaim("aud", []interface{}{"other", "clawio"}))
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestExchange_withOtherIssuer() {
+	_, _, err := suite.login(withClaim("iss", "https://evil.example.org"))
+	require.Equal(suite.T(), ErrInvalidIDToken, err)
+}
+func (suite *TestSuite) TestExchange_withExpiredIDToken() {
+	_, _, err := suite.login(withClaim("exp", float64(time.Now().Add(-time.Hour).Unix())))
+	require.Equal(suite.T(), ErrInvalidIDToken, err)
+}
+func (suite *TestSuite) TestExchange_withBadClientSecret() {
+	suite.provider.ClientSecret = "bad"
+	_, _, err := suite.login(claims)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestExchange_withClaimMapping() {
+	suite.provider.Claims = &ClaimMapping{
+		Username:    "{sub}",
+		Email:       "{email}",
+		DisplayName: "{given_name} {name}",
+	}
+	user, _, err := suite.login(claims)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "248289761001@campus", user.Username)
+	require.Equal(suite.T(), "Jane Doe", user.DisplayName)
+}
+func (suite *TestSuite) TestExchange_withoutUsernameClaim() {
+	_, _, err := suite.login(withClaim("preferred_username", nil))
+	require.Equal(suite.T(), ErrUnmappedUser, err)
+}
+func (suite *TestSuite) TestExchange_withRequire() {
+	suite.provider.Require = map[string]string{"groups": "staff"}
+	_, _, err := suite.login(claims)
+	require.Nil(suite.T(), err)
+
+	suite.provider.Require = map[string]string{"groups": "faculty"}
+	_, _, err = suite.login(claims)
+	require.Equal(suite.T(), ErrUserNotAllowed, err)
+}
+func (suite *TestSuite) TestExchange_withProvisionError() {
+	suite.provisioner.err = errors.New("test error")
+	_, _, err := suite.login(claims)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestCancel() {
+	authCodeURL, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.Nil(suite.T(), err)
+	u, err := url.Parse(authCodeURL)
+	require.Nil(suite.T(), err)
+	data, err := suite.federation.Cancel(u.Query().Get("state"))
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "data", data)
+	_, err = suite.federation.Cancel(u.Query().Get("state"))
+	require.Equal(suite.T(), ErrInvalidState, err)
+}
+func (suite *TestSuite) Testexpand() {
+	s, ok := expand("{a} and {b}", map[string]interface{}{"a": "x", "b": float64(1)})
+	require.True(suite.T(), ok)
+	require.Equal(suite.T(), "x and 1", s)
+	s, ok = expand("{a} {missing}", map[string]interface{}{"a": "x"})
+	require.False(suite.T(), ok)
+	require.Equal(suite.T(), "x", s)
+}
+
+// login runs a login where the user signs in upstream with the claims.
+func (suite *TestSuite) login(claims map[string]interface{}) (*entities.User, string, error) {
+	authCodeURL, err := suite.federation.AuthCodeURL("campus", redirectURI, "data")
+	require.Nil(suite.T(), err)
+	callback, err := suite.issuer.Authorize(authCodeURL, claims)
+	require.Nil(suite.T(), err)
+	return suite.federation.Exchange(callback.Query().Get("state"), callback.Query().Get("code"))
+}
+
+// withClaim returns the test claims with a claim replaced.
+// A nil value removes the claim.
+func withClaim(name string, value interface{}) map[string]interface{} {
+	c := map[string]interface{}{}
+	for n, v := range claims {
+		c[n] = v
+	}
+	if value == nil {
+		delete(c, name)
+	} else {
+		c[name] = value
+	}
+	return c
+}
@@ -0,0 +1,174 @@
+// Package federationtest provides a stand-in OpenID Connect provider
+// to test the federation without an upstream identity provider.
+package federationtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/authentication/lib"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Issuer is an OpenID Connect provider served by an httptest.Server. It
+// implements discovery, the JWKS endpoint and the token endpoint of the
+// authorization code flow with PKCE. Its ID tokens are signed with ES256.
+type Issuer struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *lib.Key
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+type authorization struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+// NewIssuer starts an Issuer for a single client.
+// The issuer URL is the URL of the server.
+func NewIssuer(clientID, clientSecret string) *Issuer {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	i := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key: &lib.Key{
+			ID:              "test",
+			SigningMethod:   "ES256",
+			SigningKey:      priv,
+			VerificationKey: &priv.PublicKey,
+		},
+		codes: map[string]*authorization{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/jwks.json", i.jwks)
+	mux.HandleFunc("/token", i.token)
+	i.Server = httptest.NewServer(mux)
+	return i
+}
+
+// Authorize signs in an user with the claims at the authorization URL of a
+// relying party, as the user would in the browser, and returns the callback
+// URL the provider redirects the user to. Claims like aud or nonce can be
+// set to override the ones of the ID token.
+func (i *Issuer) Authorize(authCodeURL string, claims map[string]interface{}) (*url.URL, error) {
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if query.Get("client_id") != i.ClientID || query.Get("code_challenge_method") != "S256" {
+		return nil, errors.New("authorization request is invalid")
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	code := jwt.EncodeSegment(b)
+	i.mu.Lock()
+	i.codes[code] = &authorization{
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        claims,
+	}
+	i.mu.Unlock()
+
+	callback, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return nil, err
+	}
+	params := callback.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	callback.RawQuery = params.Encode()
+	return callback, nil
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks.json",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lib.NewJSONWebKeySet([]*lib.Key{i.key}))
+}
+
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != i.ClientID || secret != i.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	code := r.PostForm.Get("code")
+	i.mu.Lock()
+	auth, ok := i.codes[code]
+	delete(i.codes, code)
+	i.mu.Unlock()
+	if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+		authorizationcodestore.S256Challenge(r.PostForm.Get("code_verifier")) != auth.codeChallenge {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	token := jwt.New(jwt.GetSigningMethod(i.key.SigningMethod))
+	token.Header["kid"] = i.key.ID
+	token.Claims["iss"] = i.URL
+	token.Claims["aud"] = i.ClientID
+	token.Claims["nonce"] = auth.nonce
+	token.Claims["iat"] = now.Unix()
+	token.Claims["exp"] = now.Add(time.Hour).Unix()
+	for name, value := range auth.claims {
+		token.Claims[name] = value
+	}
+	idToken, err := token.SignedString(i.key.SigningKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "upstream",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,304 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/entities"
+)
+
+// DefaultScope is the scope requested from the providers when none is configured.
+const DefaultScope = "openid profile email"
+
+// clockSkew is tolerated when validating the times of upstream ID tokens.
+const clockSkew = time.Minute
+
+var (
+	// ErrInvalidIDToken is returned when the ID token of a provider
+	// is not valid for the login: bad signature, issuer, audience or nonce.
+	ErrInvalidIDToken = errors.New("upstream id token is invalid")
+
+	// ErrUnmappedUser is returned when the claims of the ID token
+	// do not provide the claims used by the username mapping.
+	ErrUnmappedUser = errors.New("upstream claims do not map to an username")
+)
+
+// DefaultClaimMapping is the mapping used when none is configured.
+var DefaultClaimMapping = &ClaimMapping{
+	Username:    "{preferred_username}",
+	Email:       "{email}",
+	DisplayName: "{name}",
+}
+
+// ProviderConfig holds the configuration of an upstream OpenID Connect provider.
+type ProviderConfig struct {
+	// ID names the provider in the login URLs and Name is shown to the users.
+	ID   string
+	Name string
+
+	// Issuer is the issuer URL, where the discovery document is served.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// Scope is the scope requested from the provider, DefaultScope when empty.
+	Scope string
+
+	// Claims maps the claims of the ID token to the user,
+	// DefaultClaimMapping when nil.
+	Claims *ClaimMapping
+
+	// Require are the claims the ID token must have to sign in, like
+	// {"hd": "campus.example.org"}. Array claims must contain the value.
+	Require map[string]string
+}
+
+// ClaimMapping holds the templates of the user fields. Every {claim}
+// is replaced with the value of the claim of the upstream ID token, like
+// "{preferred_username}" or "{given_name} {family_name}". Missing claims
+// are replaced with nothing, but the username must have all its claims.
+// The username is always suffixed with @ID, the namespace of the provider,
+// so the upstream accounts can not take the names of the local users or
+// of the users of other providers.
+type ClaimMapping struct {
+	Username    string
+	Email       string
+	DisplayName string
+}
+
+// metadata is the part of the discovery document used by the relying party.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type provider struct {
+	cfg    *ProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	authenticator *lib.Authenticator
+}
+
+func newProvider(cfg *ProviderConfig, client *http.Client) (*provider, error) {
+	if cfg.ID == "" || cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("identity provider needs an id, an issuer and a client id")
+	}
+	return &provider{cfg: cfg, client: client}, nil
+}
+
+// discover returns the metadata of the provider, fetched on first use.
+// Failed fetches are retried on the next login.
+func (p *provider) discover() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	res, err := p.client.Get(strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("discovery of " + p.cfg.Issuer + " failed with status " + res.Status)
+	}
+	m := &metadata{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(m); err != nil {
+		return nil, err
+	}
+	// the issuer of the document must be the configured one
+	// (OpenID Connect Discovery section 4.3).
+	if m.Issuer != p.cfg.Issuer {
+		return nil, errors.New("discovery of " + p.cfg.Issuer + " returned issuer " + m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("discovery of " + p.cfg.Issuer + " is missing endpoints")
+	}
+
+	keySet := lib.NewRemoteKeySet(m.JWKSURI)
+	keySet.Client = p.client
+	authenticator := lib.NewAuthenticatorWithKeySet(keySet)
+	authenticator.Leeway = clockSkew
+	p.metadata = m
+	p.authenticator = authenticator
+	return m, nil
+}
+
+func (p *provider) authCodeURL(m *metadata, redirectURI, state, nonce, codeVerifier string) string {
+	scope := p.cfg.Scope
+	if scope == "" {
+		scope = DefaultScope
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {scope},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {authorizationcodestore.S256Challenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return m.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// tokenResponse is the part of the token response used by the relying party.
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// exchange redeems the code and returns the claims of the verified ID token.
+func (p *provider) exchange(code, redirectURI, nonce, codeVerifier string) (map[string]interface{}, error) {
+	m, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequest("POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client credentials are form encoded before the
+	// basic authentication (RFC 6749 section 2.3.1).
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	tokenRes := &tokenResponse{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(tokenRes); err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK || tokenRes.IDToken == "" {
+		return nil, errors.New("token request to " + p.cfg.Issuer + " failed with status " + res.Status + " " + tokenRes.Error)
+	}
+	return p.verify(m, tokenRes.IDToken, nonce)
+}
+
+// verify validates the ID token following OpenID Connect Core section 3.1.3.7.
+func (p *provider) verify(m *metadata, idToken, nonce string) (map[string]interface{}, error) {
+	claims, err := p.authenticator.Claims(idToken)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if iss, _ := claims["iss"].(string); iss != m.Issuer {
+		return nil, ErrInvalidIDToken
+	}
+	if !hasAudience(claims["aud"], p.cfg.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// user maps the claims to an user after checking the Require rules.
+func (p *provider) user(claims map[string]interface{}) (*entities.User, error) {
+	for name, value := range p.cfg.Require {
+		if !hasClaimValue(claims[name], value) {
+			return nil, ErrUserNotAllowed
+		}
+	}
+	mapping := p.cfg.Claims
+	if mapping == nil {
+		mapping = DefaultClaimMapping
+	}
+	username, ok := expand(mapping.Username, claims)
+	if !ok || username == "" {
+		return nil, ErrUnmappedUser
+	}
+	username += "@" + p.cfg.ID
+	email, _ := expand(mapping.Email, claims)
+	displayName, _ := expand(mapping.DisplayName, claims)
+	return &entities.User{
+		Username:    username,
+		Email:       email,
+		DisplayName: displayName,
+	}, nil
+}
+
+func hasClaimValue(claim interface{}, value string) bool {
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if s, ok := claimString(v); ok && s == value {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := claimString(claim)
+	return ok && s == value
+}
+
+var claimPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// expand replaces the {claim} references of the template. It reports
+// whether every referenced claim exists.
+func expand(template string, claims map[string]interface{}) (string, bool) {
+	complete := true
+	expanded := claimPattern.ReplaceAllStringFunc(template, func(ref string) string {
+		s, ok := claimString(claims[ref[1:len(ref)-1]])
+		if !ok {
+			complete = false
+		}
+		return s
+	})
+	return strings.TrimSpace(expanded), complete
+}
+
+func claimString(claim interface{}) (string, bool) {
+	switch v := claim.(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"crypto"
+	_ "crypto/sha256" // register the hashes used by at_hash
+	_ "crypto/sha512"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/clawio/entities"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// IDToken is what an OpenID Connect ID token is issued for.
+// Audience is the client id of the relying party. AccessToken is the access
+// token issued with the ID token, bound to it with the at_hash claim.
+// Scope selects the user claims: profile adds name and preferred_username,
+// email adds email.
+type IDToken struct {
+	Issuer      string
+	Audience    string
+	Nonce       string
+	AuthTime    time.Time
+	AccessToken string
+	Scope       string
+}
+
+// ErrSymmetricKey is returned when an ID token would be signed with a HMAC
+// key, which relying parties could only verify knowing the secret of the
+// service, and so could forge ID tokens and access tokens.
+var ErrSymmetricKey = errors.New("id tokens can not be signed with a HMAC key")
+
+// CreateIDToken creates an OpenID Connect ID token for the user. Its
+// subject is the username. ID tokens have no username claim, so
+// CreateUserFromToken and JWTHandlerFunc do not accept them as access tokens.
+// The signing key must be asymmetric, ErrSymmetricKey is returned otherwise.
+func (a *Authenticator) CreateIDToken(user *entities.User, idToken *IDToken) (string, error) {
+	if user == nil {
+		return "", errors.New("user is nil")
+	}
+	if idToken == nil || idToken.Issuer == "" || idToken.Audience == "" {
+		return "", errors.New("id token has no issuer or audience")
+	}
+	key, err := a.keys().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	if isHMAC(key.SigningMethod) {
+		return "", ErrSymmetricKey
+	}
+	claims := UserInfoClaims(user, idToken.Scope)
+	claims["iss"] = idToken.Issuer
+	claims["aud"] = idToken.Audience
+	if idToken.Nonce != "" {
+		claims["nonce"] = idToken.Nonce
+	}
+	if !idToken.AuthTime.IsZero() {
+		claims["auth_time"] = idToken.AuthTime.Unix()
+	}
+	if idToken.AccessToken != "" {
+		atHash, err := tokenHash(key.SigningMethod, idToken.AccessToken)
+		if err != nil {
+			return "", err
+		}
+		claims["at_hash"] = atHash
+	}
+	return a.signTokenWithKey(key, claims)
+}
+
+// SignsIDTokens reports whether the signing key can sign ID
+// tokens, that is whether it is an asymmetric key.
+func (a *Authenticator) SignsIDTokens() bool {
+	key, err := a.keys().SigningKey()
+	return err == nil && !isHMAC(key.SigningMethod)
+}
+
+// IsAccessToken reports whether the claims are those of an access token,
+// issued to an user or to a service, and not those of an ID token.
+func IsAccessToken(claims map[string]interface{}) bool {
+	if isServiceToken(claims) {
+		return true
+	}
+	_, ok := claims["username"].(string)
+	return ok
+}
+
+// UserInfoClaims returns the standard OpenID Connect claims of the user
+// granted by the scope: sub always, name and preferred_username with
+// profile and email with email.
+func UserInfoClaims(user *entities.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.Username}
+	for _, s := range strings.Fields(scope) {
+		switch s {
+		case "profile":
+			claims["preferred_username"] = user.Username
+			if user.DisplayName != "" {
+				claims["name"] = user.DisplayName
+			}
+		case "email":
+			if user.Email != "" {
+				claims["email"] = user.Email
+			}
+		}
+	}
+	return claims
+}
+
+// tokenHash returns the at_hash of an access token: the left half of its
+// hash with the hash function of the signing method, base64url encoded
+// (OpenID Connect Core section 3.1.3.6). EdDSA uses SHA-512 as Ed25519 does.
+func tokenHash(method, token string) (string, error) {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(method, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(method, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(method, "512"), method == "EdDSA":
+		hash = crypto.SHA512
+	default:
+		return "", errors.New("signing method " + method + " has no hash for at_hash")
+	}
+	h := hash.New()
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return jwt.EncodeSegment(sum[:len(sum)/2]), nil
+}
@@ -0,0 +1,102 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"time"
+
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestCreateIDToken() {
+	suite.useAsymmetricKey()
+	authTime := time.Unix(1475000000, 0)
+	user := &entities.User{Username: "test", Email: "test@example.org", DisplayName: "Test"}
+	token, err := suite.authenticator.CreateIDToken(user, &IDToken{
+		Issuer:      "https://auth.example.org",
+		Audience:    "web",
+		Nonce:       "n-0S6_WzA2Mj",
+		AuthTime:    authTime,
+		AccessToken: "access",
+		Scope:       "openid profile email",
+	})
+	require.Nil(suite.T(), err)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "https://auth.example.org", claims["iss"])
+	require.Equal(suite.T(), user.Username, claims["sub"])
+	require.Equal(suite.T(), "web", claims["aud"])
+	require.Equal(suite.T(), "n-0S6_WzA2Mj", claims["nonce"])
+	require.Equal(suite.T(), float64(authTime.Unix()), claims["auth_time"])
+	require.Equal(suite.T(), user.Username, claims["preferred_username"])
+	require.Equal(suite.T(), "Test", claims["name"])
+	require.Equal(suite.T(), "test@example.org", claims["email"])
+	sum := sha256.Sum256([]byte("access"))
+	require.Equal(suite.T(), base64.RawURLEncoding.EncodeToString(sum[:16]), claims["at_hash"])
+}
+func (suite *TestSuite) TestCreateIDToken_withoutProfileScope() {
+	suite.useAsymmetricKey()
+	token, err := suite.authenticator.CreateIDToken(user, &IDToken{Issuer: "https://auth.example.org", Audience: "web", Scope: "openid"})
+	require.Nil(suite.T(), err)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), claims["email"])
+	require.Nil(suite.T(), claims["name"])
+	require.Nil(suite.T(), claims["nonce"])
+	require.Nil(suite.T(), claims["at_hash"])
+}
+func (suite *TestSuite) TestCreateIDToken_withoutAudience() {
+	_, err := suite.authenticator.CreateIDToken(user, &IDToken{Issuer: "https://auth.example.org"})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestCreateIDToken_withHMACKey() {
+	require.False(suite.T(), suite.authenticator.SignsIDTokens())
+	_, err := suite.authenticator.CreateIDToken(user, &IDToken{Issuer: "https://auth.example.org", Audience: "web", Scope: "openid"})
+	require.Equal(suite.T(), ErrSymmetricKey, err)
+}
+func (suite *TestSuite) TestCreateUserFromToken_withIDToken() {
+	suite.useAsymmetricKey()
+	token, err := suite.authenticator.CreateIDToken(user, &IDToken{Issuer: "https://auth.example.org", Audience: "web", Scope: "openid profile email"})
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.CreateUserFromToken(token)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestIsAccessToken() {
+	suite.useAsymmetricKey()
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), IsAccessToken(claims))
+	token, err = suite.authenticator.CreateServiceToken(&ServicePrincipal{ClientID: "data"})
+	require.Nil(suite.T(), err)
+	claims, err = suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), IsAccessToken(claims))
+	token, err = suite.authenticator.CreateIDToken(user, &IDToken{Issuer: "https://auth.example.org", Audience: "web", Scope: "openid profile email"})
+	require.Nil(suite.T(), err)
+	claims, err = suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), IsAccessToken(claims))
+}
+func (suite *TestSuite) TesttokenHash() {
+	atHash, err := tokenHash("ES384", "access")
+	require.Nil(suite.T(), err)
+	sum := sha512.Sum384([]byte("access"))
+	require.Equal(suite.T(), base64.RawURLEncoding.EncodeToString(sum[:24]), atHash)
+	_, err = tokenHash("none", "token")
+	require.NotNil(suite.T(), err)
+}
+
+// useAsymmetricKey makes the authenticator of the suite sign with an ES256 key.
+func (suite *TestSuite) useAsymmetricKey() {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	suite.authenticator = NewAuthenticatorWithKey(&Key{SigningMethod: "ES256", SigningKey: priv, VerificationKey: &priv.PublicKey})
+	require.True(suite.T(), suite.authenticator.SignsIDTokens())
+}
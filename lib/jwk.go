@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JSONWebKey is the public part of a Key encoded as a JSON Web Key (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of JSON Web Keys as served on a jwks.json endpoint.
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+// ErrNotPublicKey is returned when a JSON Web Key is requested
+// for a HMAC key, as its secret must never be published.
+var ErrNotPublicKey = errors.New("key has no public key")
+
+// NewJSONWebKey returns the JSON Web Key for the verification key of an
+// asymmetric key.
+func NewJSONWebKey(key *Key) (*JSONWebKey, error) {
+	jwk := &JSONWebKey{Use: "sig", Kid: key.ID, Alg: key.SigningMethod}
+	switch pub := key.VerificationKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = jwt.EncodeSegment(pub.N.Bytes())
+		jwk.E = jwt.EncodeSegment(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = jwt.EncodeSegment(padLeft(pub.X.Bytes(), size))
+		jwk.Y = jwt.EncodeSegment(padLeft(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = jwt.EncodeSegment(pub)
+	default:
+		return nil, ErrNotPublicKey
+	}
+	return jwk, nil
+}
+
+// Key returns the verification key encoded in the JSON Web Key.
+// When the alg member is missing the signing method is inferred
+// from the key type: RS256 for RSA, ES256, ES384 or ES512 depending on
+// the curve for EC and EdDSA for Ed25519 keys.
+func (jwk *JSONWebKey) Key() (*Key, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, errors.New("key " + jwk.Kid + " is not a signature key")
+	}
+	key := &Key{ID: jwk.Kid, SigningMethod: jwk.Alg}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := jwt.DecodeSegment(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwt.DecodeSegment(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		key.VerificationKey = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.SigningMethod == "" {
+			key.SigningMethod = "RS256"
+		}
+	case "EC":
+		var method string
+		for m, curve := range curves {
+			if curve.Params().Name == jwk.Crv {
+				method = m
+			}
+		}
+		if method == "" {
+			return nil, errors.New("key " + jwk.Kid + " curve " + jwk.Crv + " is not supported")
+		}
+		x, err := jwt.DecodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwt.DecodeSegment(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curves[method],
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("key " + jwk.Kid + " is not on curve " + jwk.Crv)
+		}
+		key.VerificationKey = pub
+		if key.SigningMethod == "" {
+			key.SigningMethod = method
+		}
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New("key " + jwk.Kid + " curve " + jwk.Crv + " is not supported")
+		}
+		x, err := jwt.DecodeSegment(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		key.VerificationKey = ed25519.PublicKey(x)
+		if key.SigningMethod == "" {
+			key.SigningMethod = SigningMethodEdDSA.Alg()
+		}
+	default:
+		return nil, errors.New("key " + jwk.Kid + " type " + jwk.Kty + " is not supported")
+	}
+	if err := checkKeyType(key.SigningMethod, key.VerificationKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewJSONWebKeySet returns the JSON Web Key Set of the asymmetric keys.
+// HMAC keys are skipped.
+func NewJSONWebKeySet(keys []*Key) *JSONWebKeySet {
+	set := &JSONWebKeySet{Keys: []*JSONWebKey{}}
+	for _, k := range keys {
+		jwk, err := NewJSONWebKey(k)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// Thumbprint returns the JWK thumbprint (RFC 7638) of the verification key
+// of an asymmetric key. It is a stable identifier that can be used as key ID.
+func Thumbprint(key *Key) (string, error) {
+	jwk, err := NewJSONWebKey(key)
+	if err != nil {
+		return "", err
+	}
+	// the required members in lexicographic order.
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return jwt.EncodeSegment(sum[:]), nil
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestThumbprint() {
+	// example of RFC 7638 section 3.1.
+	n, err := jwt.DecodeSegment("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.Nil(suite.T(), err)
+	key := &Key{SigningMethod: "RS256", VerificationKey: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}}
+	thumbprint, err := Thumbprint(key)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+func (suite *TestSuite) TestThumbprint_withHMACKey() {
+	key, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+	_, err = Thumbprint(key)
+	require.Equal(suite.T(), ErrNotPublicKey, err)
+}
+func (suite *TestSuite) TestNewJSONWebKeySet() {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(suite.T(), err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(suite.T(), err)
+	hmacKey, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+
+	set := NewJSONWebKeySet([]*Key{
+		{ID: "rsa", SigningMethod: "RS256", VerificationKey: &rsaKey.PublicKey},
+		{ID: "ec", SigningMethod: "ES256", VerificationKey: &ecKey.PublicKey},
+		{ID: "ed", SigningMethod: "EdDSA", VerificationKey: edKey},
+		hmacKey,
+	})
+	require.Len(suite.T(), set.Keys, 3)
+	require.Equal(suite.T(), "RSA", set.Keys[0].Kty)
+	require.Equal(suite.T(), "AQAB", set.Keys[0].E)
+	require.Equal(suite.T(), "EC", set.Keys[1].Kty)
+	require.Equal(suite.T(), "P-256", set.Keys[1].Crv)
+	require.Len(suite.T(), set.Keys[1].X, 43)
+	require.Equal(suite.T(), "OKP", set.Keys[2].Kty)
+	require.Equal(suite.T(), "Ed25519", set.Keys[2].Crv)
+	require.Equal(suite.T(), "ed", set.Keys[2].Kid)
+	require.Equal(suite.T(), "EdDSA", set.Keys[2].Alg)
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Key is a key bound to a JWT signing method.
+// For HMAC methods both keys are the shared secret as a []byte.
+// For asymmetric methods SigningKey is a *rsa.PrivateKey, *ecdsa.PrivateKey
+// or ed25519.PrivateKey and VerificationKey the matching public key.
+// SigningKey is nil for keys that can only verify tokens, like the ones
+// configured in services that consume the tokens.
+// ID is set as the kid header of the tokens signed with the key.
+type Key struct {
+	ID              string
+	SigningMethod   string
+	SigningKey      interface{}
+	VerificationKey interface{}
+}
+
+// NewHMACKey returns a Key for a HMAC signing method using a shared secret.
+func NewHMACKey(secret, method string) (*Key, error) {
+	if !isHMAC(method) {
+		return nil, errors.New("signing method " + method + " is not a HMAC method")
+	}
+	if jwt.GetSigningMethod(method) == nil {
+		return nil, errors.New("signing method " + method + " does not exist")
+	}
+	return &Key{
+		SigningMethod:   method,
+		SigningKey:      []byte(secret),
+		VerificationKey: []byte(secret),
+	}, nil
+}
+
+// isHMAC reports whether the signing method uses a shared secret.
+func isHMAC(method string) bool {
+	return strings.HasPrefix(method, "HS")
+}
+
+// LoadKey returns a Key for an asymmetric signing method (RS*, PS*, ES* or EdDSA)
+// reading PEM encoded keys from disk. The private key file can be empty to get
+// a key that only verifies tokens. The public key file can be empty if a private
+// key file is given, as the public key is derived from it.
+func LoadKey(method, privateKeyFile, publicKeyFile string) (*Key, error) {
+	if privateKeyFile == "" && publicKeyFile == "" {
+		return nil, errors.New("a private or public key file is required")
+	}
+	key := &Key{SigningMethod: method}
+	if privateKeyFile != "" {
+		data, err := ioutil.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		key.SigningKey = signer
+		key.VerificationKey = signer.Public()
+	}
+	if publicKeyFile != "" {
+		data, err := ioutil.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := ParsePublicKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		key.VerificationKey = pub
+	}
+	if err := checkKeyType(method, key.VerificationKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded PKCS#8, PKCS#1 (RSA) or SEC 1 (ECDSA) private key.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("private key type is not supported")
+		}
+		return signer, nil
+	default:
+		return nil, errors.New("PEM block type " + block.Type + " is not a supported private key")
+	}
+}
+
+// ParsePublicKeyPEM parses a PEM encoded PKIX or PKCS#1 (RSA) public key,
+// or the public key of a X.509 certificate.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, errors.New("PEM block type " + block.Type + " is not a supported public key")
+	}
+}
+
+// checkKeyType verifies that the public key can be used with the signing method.
+func checkKeyType(method string, pub crypto.PublicKey) error {
+	if jwt.GetSigningMethod(method) == nil {
+		return errors.New("signing method " + method + " does not exist")
+	}
+	var ok bool
+	switch {
+	case strings.HasPrefix(method, "RS"), strings.HasPrefix(method, "PS"):
+		_, ok = pub.(*rsa.PublicKey)
+	case strings.HasPrefix(method, "ES"):
+		var k *ecdsa.PublicKey
+		k, ok = pub.(*ecdsa.PublicKey)
+		ok = ok && k.Curve == curves[method]
+	case method == SigningMethodEdDSA.Alg():
+		_, ok = pub.(ed25519.PublicKey)
+	default:
+		return errors.New("signing method " + method + " is not an asymmetric method")
+	}
+	if !ok {
+		return errors.New("key type does not match signing method " + method)
+	}
+	return nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestNewHMACKey_withAsymmetricMethod() {
+	_, err := NewHMACKey("secret", "RS256")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestLoadKey_withRSA() {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(suite.T(), err)
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	suite.testSignAndVerify("RS256", pkcs1, suite.encodePublicKey(&priv.PublicKey))
+	suite.testSignAndVerify("PS256", suite.encodePKCS8(priv), suite.encodePublicKey(&priv.PublicKey))
+}
+func (suite *TestSuite) TestLoadKey_withECDSA() {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	der, err := x509.MarshalECPrivateKey(priv)
+	require.Nil(suite.T(), err)
+	sec1 := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	suite.testSignAndVerify("ES256", sec1, suite.encodePublicKey(&priv.PublicKey))
+	suite.testSignAndVerify("ES256", suite.encodePKCS8(priv), suite.encodePublicKey(&priv.PublicKey))
+}
+func (suite *TestSuite) TestLoadKey_withEd25519() {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(suite.T(), err)
+	suite.testSignAndVerify("EdDSA", suite.encodePKCS8(priv), suite.encodePublicKey(pub))
+}
+func (suite *TestSuite) TestLoadKey_withMismatchedMethod() {
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.Nil(suite.T(), err)
+	privateKeyFile := suite.writeFile(suite.encodePKCS8(priv))
+	defer os.Remove(privateKeyFile)
+	_, err = LoadKey("RS256", privateKeyFile, "")
+	require.NotNil(suite.T(), err)
+	_, err = LoadKey("ES256", privateKeyFile, "")
+	require.NotNil(suite.T(), err)
+	_, err = LoadKey("HS256", privateKeyFile, "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestLoadKey_withoutFiles() {
+	_, err := LoadKey("RS256", "", "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestLoadKey_withBadPEM() {
+	file := suite.writeFile([]byte("not a key"))
+	defer os.Remove(file)
+	_, err := LoadKey("RS256", file, "")
+	require.NotNil(suite.T(), err)
+	_, err = LoadKey("RS256", "", file)
+	require.NotNil(suite.T(), err)
+}
+
+// testSignAndVerify checks that tokens signed with the private key
+// are accepted by an authenticator that only holds the public key.
+func (suite *TestSuite) testSignAndVerify(method string, privatePEM, publicPEM []byte) {
+	privateKeyFile := suite.writeFile(privatePEM)
+	defer os.Remove(privateKeyFile)
+	publicKeyFile := suite.writeFile(publicPEM)
+	defer os.Remove(publicKeyFile)
+
+	signingKey, err := LoadKey(method, privateKeyFile, "")
+	require.Nil(suite.T(), err)
+	verificationKey, err := LoadKey(method, "", publicKeyFile)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), verificationKey.SigningKey)
+
+	token, err := NewAuthenticatorWithKey(signingKey).CreateToken(user)
+	require.Nil(suite.T(), err)
+	verifier := NewAuthenticatorWithKey(verificationKey)
+	u, err := verifier.CreateUserFromToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), user.Username, u.Username)
+	_, err = verifier.CreateToken(user)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) encodePKCS8(key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(suite.T(), err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+func (suite *TestSuite) encodePublicKey(key crypto.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.Nil(suite.T(), err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+func (suite *TestSuite) writeFile(data []byte) string {
+	f, err := ioutil.TempFile("", "clawio-key")
+	require.Nil(suite.T(), err)
+	defer f.Close()
+	_, err = f.Write(data)
+	require.Nil(suite.T(), err)
+	return f.Name()
+}
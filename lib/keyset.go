@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrTokenUnknownKey is returned when the kid header of a token
+// does not match any of the verification keys.
+var ErrTokenUnknownKey = errors.New("token key is unknown")
+
+// KeySet defines an interface to provide the keys
+// used to sign and verify tokens.
+type KeySet interface {
+	// SigningKey returns the key used to sign new tokens.
+	SigningKey() (*Key, error)
+	// VerificationKey returns the key identified by kid.
+	VerificationKey(kid string) (*Key, error)
+	// VerificationKeys returns all the keys tokens can be verified with.
+	VerificationKeys() ([]*Key, error)
+}
+
+// ScheduledKey is a key that becomes the signing key at ActiveFrom.
+// A zero ActiveFrom means the key is active since the beginning.
+type ScheduledKey struct {
+	*Key
+	ActiveFrom time.Time
+}
+
+// RotatingKeySet is a KeySet that rotates the signing key following a schedule.
+// The signing key is the key with the latest ActiveFrom that is not in the future.
+// Keys scheduled for the future are already returned as verification keys so
+// consumers can fetch them before they are used. A key replaced by a newer one
+// keeps verifying tokens during the grace period, which should be the token
+// lifetime plus the leeway, so tokens signed before the rotation stay
+// valid until they expire.
+type RotatingKeySet struct {
+	keys        []*ScheduledKey
+	gracePeriod time.Duration
+
+	// Now returns the current time. It can be replaced
+	// to control the rotation in tests.
+	Now func() time.Time
+}
+
+// NewRotatingKeySet returns a RotatingKeySet for the scheduled keys.
+// Key IDs must be unique.
+func NewRotatingKeySet(keys []*ScheduledKey, gracePeriod time.Duration) (*RotatingKeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key set has no keys")
+	}
+	ids := map[string]bool{}
+	for _, k := range keys {
+		if k == nil || k.Key == nil {
+			return nil, errors.New("key set has a nil key")
+		}
+		if ids[k.ID] {
+			return nil, errors.New("key id " + k.ID + " is duplicated")
+		}
+		ids[k.ID] = true
+	}
+	sorted := make([]*ScheduledKey, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActiveFrom.Before(sorted[j].ActiveFrom)
+	})
+	return &RotatingKeySet{keys: sorted, gracePeriod: gracePeriod, Now: time.Now}, nil
+}
+
+// NewSingleKeySet returns a KeySet that always signs and verifies with the same key.
+func NewSingleKeySet(key *Key) KeySet {
+	s, _ := NewRotatingKeySet([]*ScheduledKey{{Key: key}}, 0)
+	return s
+}
+
+func (s *RotatingKeySet) SigningKey() (*Key, error) {
+	now := s.now()
+	var active *Key
+	for _, k := range s.keys {
+		if k.ActiveFrom.After(now) {
+			break
+		}
+		active = k.Key
+	}
+	if active == nil {
+		return nil, errors.New("no signing key is active yet")
+	}
+	return active, nil
+}
+
+// VerificationKey returns the key identified by kid. A key without ID, like the
+// ones of NewAuthenticator and of consumers configured with a single key, has no
+// kid to compare with, so it verifies tokens whatever their kid header.
+func (s *RotatingKeySet) VerificationKey(kid string) (*Key, error) {
+	var anonymous *Key
+	for _, k := range s.usableKeys() {
+		if k.ID == kid {
+			return k, nil
+		}
+		if k.ID == "" && anonymous == nil {
+			anonymous = k
+		}
+	}
+	if anonymous != nil {
+		return anonymous, nil
+	}
+	return nil, ErrTokenUnknownKey
+}
+
+func (s *RotatingKeySet) VerificationKeys() ([]*Key, error) {
+	return s.usableKeys(), nil
+}
+
+// usableKeys returns the keys that are not past their grace period.
+func (s *RotatingKeySet) usableKeys() []*Key {
+	now := s.now()
+	keys := []*Key{}
+	for i, k := range s.keys {
+		if i+1 < len(s.keys) {
+			replacedAt := s.keys[i+1].ActiveFrom
+			if !replacedAt.After(now) && now.After(replacedAt.Add(s.gracePeriod)) {
+				continue
+			}
+		}
+		keys = append(keys, k.Key)
+	}
+	return keys
+}
+
+func (s *RotatingKeySet) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+// invalidKeySet is the KeySet of an Authenticator that is misconfigured.
+type invalidKeySet struct {
+	err error
+}
+
+func (s *invalidKeySet) SigningKey() (*Key, error)                { return nil, s.err }
+func (s *invalidKeySet) VerificationKey(kid string) (*Key, error) { return nil, s.err }
+func (s *invalidKeySet) VerificationKeys() ([]*Key, error)        { return nil, s.err }
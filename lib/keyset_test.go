@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestNewRotatingKeySet_withoutKeys() {
+	_, err := NewRotatingKeySet(nil, time.Hour)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNewRotatingKeySet_withDuplicatedID() {
+	key1, err := NewHMACKey("secret1", "HS256")
+	require.Nil(suite.T(), err)
+	key2, err := NewHMACKey("secret2", "HS256")
+	require.Nil(suite.T(), err)
+	_, err = NewRotatingKeySet([]*ScheduledKey{{Key: key1}, {Key: key2}}, time.Hour)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRotatingKeySet_withFutureKey() {
+	key, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+	keySet, err := NewRotatingKeySet([]*ScheduledKey{{Key: key, ActiveFrom: time.Now().Add(time.Hour)}}, time.Hour)
+	require.Nil(suite.T(), err)
+	_, err = keySet.SigningKey()
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRotatingKeySet() {
+	now := time.Now()
+	rotation := now.Add(time.Hour)
+	key1, err := NewHMACKey("secret1", "HS256")
+	require.Nil(suite.T(), err)
+	key1.ID = "key1"
+	key2, err := NewHMACKey("secret2", "HS256")
+	require.Nil(suite.T(), err)
+	key2.ID = "key2"
+	keySet, err := NewRotatingKeySet([]*ScheduledKey{
+		{Key: key2, ActiveFrom: rotation},
+		{Key: key1},
+	}, DefaultTTL)
+	require.Nil(suite.T(), err)
+	keySet.Now = func() time.Time { return now }
+	authenticator := NewAuthenticatorWithKeySet(keySet)
+	authenticator.Now = keySet.Now
+
+	// before the rotation key1 signs and key2 is already published.
+	signingKey, err := keySet.SigningKey()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "key1", signingKey.ID)
+	keys, err := keySet.VerificationKeys()
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), keys, 2)
+
+	now = rotation.Add(-time.Minute)
+	token, err := authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	rawToken, err := authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "key1", rawToken.Header["kid"])
+
+	// after the rotation key2 signs and key1 tokens are still valid.
+	now = rotation
+	signingKey, err = keySet.SigningKey()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "key2", signingKey.ID)
+	_, err = authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+
+	// after the grace period key1 is dropped.
+	now = rotation.Add(DefaultTTL + time.Second)
+	keys, err = keySet.VerificationKeys()
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), keys, 1)
+	_, err = keySet.VerificationKey("key1")
+	require.Equal(suite.T(), ErrTokenUnknownKey, err)
+}
+func (suite *TestSuite) TestparseToken_withUnknownKey() {
+	key, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+	key.ID = "other"
+	token, err := NewAuthenticatorWithKey(key).CreateToken(user)
+	require.Nil(suite.T(), err)
+	verificationKey, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+	verificationKey.ID = "key1"
+	_, err = NewAuthenticatorWithKey(verificationKey).parseToken(token)
+	require.Equal(suite.T(), ErrTokenUnknownKey, err)
+}
+func (suite *TestSuite) TestparseToken_withKeyWithoutID() {
+	key, err := NewHMACKey("secret", "HS256")
+	require.Nil(suite.T(), err)
+	key.ID = "default"
+	token, err := NewAuthenticatorWithKey(key).CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+}
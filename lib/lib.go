@@ -1,11 +1,13 @@
 package lib
 
 import (
+	"crypto/rand"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/clawio/authentication/revocationstore"
 	"github.com/clawio/entities"
 	"github.com/clawio/keys"
 	"github.com/dgrijalva/jwt-go"
@@ -15,9 +17,45 @@ import (
 const DefaultJWTKey = "secret"
 const DefaultJWTSigningMethod = "HS256"
 
+// Errors returned when a token is rejected, so callers can tell
+// the reason apart.
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenAlgorithm        = errors.New("token signing algorithm is not allowed")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+)
+
+// ErrTokenNotRevocable is returned when revoking a token without a jti
+// claim, like the ones issued before the claim was added.
+var ErrTokenNotRevocable = errors.New("token has no jti claim")
+
+// DefaultTTL is the lifetime of the tokens created by an Authenticator
+// when none is configured.
+const DefaultTTL = time.Hour
+
 type Authenticator struct {
 	JWTKey           string
 	JWTSigningMethod string
+
+	// Keys provides the keys to sign and verify the tokens. When nil
+	// a HMAC key is built from JWTKey and JWTSigningMethod.
+	Keys KeySet
+
+	// TTL is the lifetime of the tokens created by CreateToken.
+	TTL time.Duration
+	// Leeway is the clock skew tolerated when validating
+	// the exp, nbf and iat claims of a token.
+	Leeway time.Duration
+	// Now returns the current time. It can be replaced
+	// to control token expiry in tests.
+	Now func() time.Time
+
+	// RevocationStore is consulted to reject tokens revoked
+	// before they expire. Revocation is disabled when nil.
+	RevocationStore revocationstore.RevocationStore
 }
 
 func NewAuthenticator(key, method string) *Authenticator {
@@ -27,30 +65,191 @@ func NewAuthenticator(key, method string) *Authenticator {
 	if method == "" {
 		method = DefaultJWTSigningMethod
 	}
-	return &Authenticator{JWTKey: key, JWTSigningMethod: method}
+	return &Authenticator{
+		JWTKey:           key,
+		JWTSigningMethod: method,
+		TTL:              DefaultTTL,
+		Now:              time.Now,
+	}
+}
+
+// NewAuthenticatorWithKey returns an Authenticator that signs and verifies tokens
+// with the given key. Authenticators for services that only consume tokens are
+// created with a key that holds just the public key of the authentication service.
+func NewAuthenticatorWithKey(key *Key) *Authenticator {
+	return NewAuthenticatorWithKeySet(NewSingleKeySet(key))
+}
+
+// NewAuthenticatorWithKeySet returns an Authenticator that signs tokens with
+// the signing key of the key set and verifies them with the key named by
+// their kid header.
+func NewAuthenticatorWithKeySet(keys KeySet) *Authenticator {
+	return &Authenticator{
+		Keys: keys,
+		TTL:  DefaultTTL,
+		Now:  time.Now,
+	}
 }
 
 func (a *Authenticator) CreateToken(user *entities.User) (string, error) {
+	return a.CreateTokenWithClaims(user, nil)
+}
+
+// CreateTokenWithClaims creates a token with additional claims, like scope.
+// The claims set from the user and the registered claims (iat, nbf, exp
+// and jti) can not be overridden.
+func (a *Authenticator) CreateTokenWithClaims(user *entities.User, claims map[string]interface{}) (string, error) {
 	if user == nil {
 		return "", errors.New("user is nil")
 	}
-	token := jwt.New(jwt.GetSigningMethod(a.JWTSigningMethod))
-	token.Claims["username"] = user.Username
-	token.Claims["email"] = user.Email
-	token.Claims["display_name"] = user.DisplayName
-	token.Claims["exp"] = time.Now().Add(time.Second * 3600).UnixNano()
-	return token.SignedString([]byte(a.JWTKey))
+	all := map[string]interface{}{}
+	for name, value := range claims {
+		all[name] = value
+	}
+	all["username"] = user.Username
+	all["email"] = user.Email
+	all["display_name"] = user.DisplayName
+	return a.signToken(all)
+}
+
+// signToken signs a token with the claims plus the registered
+// claims iat, nbf, exp and jti.
+func (a *Authenticator) signToken(claims map[string]interface{}) (string, error) {
+	key, err := a.keys().SigningKey()
+	if err != nil {
+		return "", err
+	}
+	return a.signTokenWithKey(key, claims)
+}
+
+// signTokenWithKey is like signToken with the signing key already chosen,
+// for claims that depend on the signing method.
+func (a *Authenticator) signTokenWithKey(key *Key, claims map[string]interface{}) (string, error) {
+	if key.SigningKey == nil {
+		return "", errors.New("authenticator has no signing key")
+	}
+	method := jwt.GetSigningMethod(key.SigningMethod)
+	if method == nil {
+		return "", errors.New("signing method " + key.SigningMethod + " does not exist")
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := a.now()
+	token := jwt.New(method)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	for name, value := range claims {
+		token.Claims[name] = value
+	}
+	token.Claims["iat"] = now.Unix()
+	token.Claims["nbf"] = now.Unix()
+	token.Claims["exp"] = now.Add(a.ttl()).Unix()
+	token.Claims["jti"] = jti
+	return token.SignedString(key.SigningKey)
 }
 
 func (a *Authenticator) CreateUserFromToken(token string) (*entities.User, error) {
-	rawToken, err := a.parseToken(token)
+	rawToken, err := a.verifyToken(token)
 	if err != nil {
 		return nil, err
 	}
 	return a.getUserFromRawToken(rawToken)
 }
 
+// Claims returns the claims of a token. The token is validated
+// like in CreateUserFromToken, revocation included.
+func (a *Authenticator) Claims(token string) (map[string]interface{}, error) {
+	rawToken, err := a.verifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return rawToken.Claims, nil
+}
+
+// verifyToken parses a token and checks it has not been revoked.
+func (a *Authenticator) verifyToken(token string) (*jwt.Token, error) {
+	rawToken, err := a.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkRevoked(rawToken.Claims); err != nil {
+		return nil, err
+	}
+	return rawToken, nil
+}
+
+// RevokeToken revokes a token so it is rejected before it expires.
+// Tokens that fail verification, like expired ones, are rejected anyway
+// and are ignored.
+func (a *Authenticator) RevokeToken(token string) error {
+	if a.RevocationStore == nil {
+		return errors.New("authenticator has no revocation store")
+	}
+	rawToken, err := a.parseToken(token)
+	if err != nil {
+		return nil
+	}
+	jti, ok := rawToken.Claims["jti"].(string)
+	if !ok || jti == "" {
+		return ErrTokenNotRevocable
+	}
+	exp, _ := numericDate(rawToken.Claims, "exp")
+	// the token is accepted until exp plus the leeway.
+	expiresAt := time.Unix(exp, 0).Add(a.Leeway)
+	return a.RevocationStore.Revoke(jti, expiresAt)
+}
+
+// RevokeUserTokens revokes every token issued to the user until now,
+// as when the password is changed or the user is disabled.
+func (a *Authenticator) RevokeUserTokens(username string) error {
+	if a.RevocationStore == nil {
+		return errors.New("authenticator has no revocation store")
+	}
+	now := a.now()
+	// the tokens issued until now are accepted until they expire plus the leeway.
+	return a.RevocationStore.RevokeUser(username, now, now.Add(a.ttl()+a.Leeway))
+}
+
+// checkRevoked rejects the token if its jti claim has been revoked, or
+// if it was issued to an user whose tokens were revoked after its iat.
+// Tokens without jti can only be revoked with the tokens of their user.
+func (a *Authenticator) checkRevoked(claims map[string]interface{}) error {
+	if a.RevocationStore == nil {
+		return nil
+	}
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		revoked, err := a.RevocationStore.IsRevoked(jti)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+	username, ok := claims["username"].(string)
+	if !ok || isServiceToken(claims) {
+		return nil
+	}
+	notBefore, err := a.RevocationStore.UserNotBefore(username)
+	if err != nil {
+		return err
+	}
+	// iat is in seconds, so the tokens issued during the second
+	// of the cutoff, like the ones of a refresh right after it, are kept.
+	iat, _ := numericDate(claims, "iat")
+	if !notBefore.IsZero() && iat < notBefore.Unix() {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
 func (a *Authenticator) getUserFromRawToken(rawToken *jwt.Token) (*entities.User, error) {
+	if isServiceToken(rawToken.Claims) {
+		return nil, ErrTokenServicePrincipal
+	}
 	username, ok := rawToken.Claims["username"].(string)
 	if !ok {
 		return nil, errors.New("token username claim failed cast to string")
@@ -71,10 +270,136 @@ func (a *Authenticator) getUserFromRawToken(rawToken *jwt.Token) (*entities.User
 		DisplayName: displayName,
 	}, nil
 }
+
+// parseToken parses and verifies a token with the key named by its kid header.
+// The token is only verified with the key if its alg header is the signing
+// method bound to the key, which rejects alg none and algorithm confusion
+// attacks like a HS256 token signed with the public key of a RSA key.
+// The time based claims are validated with the authenticator clock and leeway
+// instead of the ones used by the jwt package, so its expiry errors are ignored here.
 func (a *Authenticator) parseToken(token string) (*jwt.Token, error) {
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(a.JWTKey), nil
+	keySet := a.keys()
+	rawToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := keySet.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method == nil || token.Method.Alg() != key.SigningMethod {
+			return nil, ErrTokenAlgorithm
+		}
+		return key.VerificationKey, nil
 	})
+	if err != nil {
+		vErr, ok := err.(*jwt.ValidationError)
+		if !ok {
+			return nil, ErrTokenMalformed
+		}
+		if vErr.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+			return nil, tokenError(vErr)
+		}
+	}
+	if err := a.validateTimes(rawToken.Claims); err != nil {
+		return nil, err
+	}
+	rawToken.Valid = true
+	return rawToken, nil
+}
+
+// validateTimes checks the exp, nbf and iat claims, expressed in seconds
+// since the epoch as RFC 7519 NumericDate values. A token is expired
+// from exp on, plus the leeway.
+// exp and iat are required so tokens issued before they were
+// set correctly are rejected.
+func (a *Authenticator) validateTimes(claims map[string]interface{}) error {
+	now := a.now().Unix()
+	leeway := int64(a.Leeway / time.Second)
+
+	exp, ok := numericDate(claims, "exp")
+	if !ok {
+		return ErrTokenMalformed
+	}
+	if now >= exp+leeway {
+		return ErrTokenExpired
+	}
+
+	iat, ok := numericDate(claims, "iat")
+	if !ok {
+		return ErrTokenMalformed
+	}
+	if now < iat-leeway {
+		return ErrTokenNotValidYet
+	}
+
+	if nbf, ok := numericDate(claims, "nbf"); ok && now < nbf-leeway {
+		return ErrTokenNotValidYet
+	}
+	return nil
+}
+
+// tokenError maps the errors of the jwt package to the errors of this package.
+func tokenError(vErr *jwt.ValidationError) error {
+	switch {
+	case vErr.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrTokenMalformed
+	case vErr.Errors&jwt.ValidationErrorUnverifiable != 0:
+		// the keyfunc could not provide a key for the token
+		// or the jwt package does not know the algorithm.
+		if vErr.Inner != nil {
+			return vErr.Inner
+		}
+		return ErrTokenAlgorithm
+	default:
+		return ErrTokenSignatureInvalid
+	}
+}
+
+func numericDate(claims map[string]interface{}, name string) (int64, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// newTokenID returns a random value for the jti claim.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(b), nil
+}
+
+func (a *Authenticator) keys() KeySet {
+	if a.Keys != nil {
+		return a.Keys
+	}
+	key, err := NewHMACKey(a.JWTKey, a.JWTSigningMethod)
+	if err != nil {
+		return &invalidKeySet{err: err}
+	}
+	return NewSingleKeySet(key)
+}
+
+func (a *Authenticator) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func (a *Authenticator) ttl() time.Duration {
+	if a.TTL <= 0 {
+		return DefaultTTL
+	}
+	return a.TTL
+}
+
+// TokenFromRequest returns the token sent in the Authorization
+// header or in the access_token query parameter.
+func (a *Authenticator) TokenFromRequest(r *http.Request) string {
+	return a.getTokenFromRequest(r)
 }
 
 func (a *Authenticator) getTokenFromRequest(r *http.Request) string {
@@ -1,11 +1,17 @@
 package lib
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/clawio/authentication/revocationstore/memory"
 	"github.com/clawio/entities"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -38,9 +44,101 @@ func (suite *TestSuite) TestCreateToken_withNilUser() {
 	_, err := suite.authenticator.CreateToken(nil)
 	require.NotNil(suite.T(), err)
 }
+func (suite *TestSuite) TestCreateToken_withTimeClaims() {
+	now := time.Unix(1500000000, 0)
+	suite.authenticator.Now = func() time.Time { return now }
+	suite.authenticator.TTL = 10 * time.Minute
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	jwtToken, err := suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), float64(now.Unix()), jwtToken.Claims["iat"])
+	require.Equal(suite.T(), float64(now.Unix()), jwtToken.Claims["nbf"])
+	require.Equal(suite.T(), float64(now.Add(10*time.Minute).Unix()), jwtToken.Claims["exp"])
+}
+func (suite *TestSuite) TestparseToken_withExpiredToken() {
+	now := time.Now()
+	suite.authenticator.Now = func() time.Time { return now }
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	now = now.Add(DefaultTTL - time.Second)
+	_, err = suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	now = now.Add(time.Second)
+	_, err = suite.authenticator.parseToken(token)
+	require.Equal(suite.T(), ErrTokenExpired, err)
+}
+func (suite *TestSuite) TestparseToken_withLeeway() {
+	now := time.Now()
+	suite.authenticator.Now = func() time.Time { return now }
+	suite.authenticator.Leeway = time.Minute
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	now = now.Add(DefaultTTL + time.Minute - time.Second)
+	_, err = suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	now = now.Add(time.Second)
+	_, err = suite.authenticator.parseToken(token)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestparseToken_withTokenNotValidYet() {
+	now := time.Now()
+	suite.authenticator.Now = func() time.Time { return now }
+	suite.authenticator.Leeway = time.Minute
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	now = now.Add(-time.Minute)
+	_, err = suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	now = now.Add(-time.Second)
+	_, err = suite.authenticator.parseToken(token)
+	require.Equal(suite.T(), ErrTokenNotValidYet, err)
+}
+func (suite *TestSuite) TestparseToken_withoutIssuedAt() {
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Claims["username"] = "test"
+	token.Claims["exp"] = time.Now().Add(time.Hour).UnixNano()
+	signed, err := token.SignedString([]byte("secret"))
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.parseToken(signed)
+	require.Equal(suite.T(), ErrTokenMalformed, err)
+}
 func (suite *TestSuite) TestparseToken_withBadToken() {
 	_, err := suite.authenticator.parseToken("")
-	require.NotNil(suite.T(), err)
+	require.Equal(suite.T(), ErrTokenMalformed, err)
+}
+func (suite *TestSuite) TestparseToken_withAlgNone() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	parts := strings.Split(token, ".")
+	header := jwt.EncodeSegment([]byte(`{"alg":"none","typ":"JWT"}`))
+	_, err = suite.authenticator.parseToken(header + "." + parts[1] + ".")
+	require.Equal(suite.T(), ErrTokenAlgorithm, err)
+}
+func (suite *TestSuite) TestparseToken_withOtherAlgorithm() {
+	other := NewAuthenticator("secret", "HS512")
+	token, err := other.CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.parseToken(token)
+	require.Equal(suite.T(), ErrTokenAlgorithm, err)
+}
+func (suite *TestSuite) TestparseToken_withPublicKeyAsHMACSecret() {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(suite.T(), err)
+	pub := suite.encodePublicKey(&priv.PublicKey)
+	verifier := NewAuthenticatorWithKey(&Key{SigningMethod: "RS256", VerificationKey: &priv.PublicKey})
+	attacker := NewAuthenticator(string(pub), "HS256")
+	token, err := attacker.CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = verifier.parseToken(token)
+	require.Equal(suite.T(), ErrTokenAlgorithm, err)
+}
+func (suite *TestSuite) TestparseToken_withBadSignature() {
+	other := NewAuthenticator("othersecret", "HS256")
+	token, err := other.CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.parseToken(token)
+	require.Equal(suite.T(), ErrTokenSignatureInvalid, err)
 }
 func (suite *TestSuite) TestparseToken() {
 	token, err := suite.authenticator.CreateToken(user)
@@ -58,6 +156,114 @@ func (suite *TestSuite) TestcreateUserFromToken_withBadToken() {
 	_, err := suite.authenticator.CreateUserFromToken("")
 	require.NotNil(suite.T(), err)
 }
+func (suite *TestSuite) TestCreateTokenWithClaims() {
+	token, err := suite.authenticator.CreateTokenWithClaims(user, map[string]interface{}{
+		"scope":    "read",
+		"username": "other",
+	})
+	require.Nil(suite.T(), err)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "read", claims["scope"])
+	require.Equal(suite.T(), user.Username, claims["username"])
+}
+func (suite *TestSuite) TestClaims() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), user.Username, claims["username"])
+}
+func (suite *TestSuite) TestClaims_withRevokedToken() {
+	suite.authenticator.RevocationStore = memory.New()
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.authenticator.RevokeToken(token))
+	_, err = suite.authenticator.Claims(token)
+	require.Equal(suite.T(), ErrTokenRevoked, err)
+}
+func (suite *TestSuite) TestCreateToken_withUniqueID() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	other, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	jwtToken, err := suite.authenticator.parseToken(token)
+	require.Nil(suite.T(), err)
+	otherJWTToken, err := suite.authenticator.parseToken(other)
+	require.Nil(suite.T(), err)
+	require.NotEmpty(suite.T(), jwtToken.Claims["jti"])
+	require.NotEqual(suite.T(), jwtToken.Claims["jti"], otherJWTToken.Claims["jti"])
+}
+func (suite *TestSuite) TestRevokeToken() {
+	suite.authenticator.RevocationStore = memory.New()
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	other, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	err = suite.authenticator.RevokeToken(token)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.CreateUserFromToken(token)
+	require.Equal(suite.T(), ErrTokenRevoked, err)
+	_, err = suite.authenticator.CreateUserFromToken(other)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevokeToken_withJWTMiddleware() {
+	suite.authenticator.RevocationStore = memory.New()
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.authenticator.RevokeToken(token))
+	r, err := http.NewRequest("GET", "", nil)
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	suite.middleware(w, r)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestRevokeToken_withBadToken() {
+	suite.authenticator.RevocationStore = memory.New()
+	err := suite.authenticator.RevokeToken("")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevokeToken_withoutID() {
+	suite.authenticator.RevocationStore = memory.New()
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Claims["iat"] = time.Now().Unix()
+	token.Claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signed, err := token.SignedString([]byte("secret"))
+	require.Nil(suite.T(), err)
+	err = suite.authenticator.RevokeToken(signed)
+	require.Equal(suite.T(), ErrTokenNotRevocable, err)
+}
+func (suite *TestSuite) TestRevokeToken_withoutRevocationStore() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	err = suite.authenticator.RevokeToken(token)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevokeUserTokens() {
+	suite.authenticator.RevocationStore = memory.New()
+	now := time.Now()
+	suite.authenticator.Now = func() time.Time { return now }
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	other, err := suite.authenticator.CreateToken(&entities.User{Username: "other"})
+	require.Nil(suite.T(), err)
+
+	now = now.Add(time.Minute)
+	require.Nil(suite.T(), suite.authenticator.RevokeUserTokens(user.Username))
+	_, err = suite.authenticator.CreateUserFromToken(token)
+	require.Equal(suite.T(), ErrTokenRevoked, err)
+	_, err = suite.authenticator.CreateUserFromToken(other)
+	require.Nil(suite.T(), err)
+	// tokens issued after the cutoff are valid.
+	token, err = suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.CreateUserFromToken(token)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevokeUserTokens_withoutRevocationStore() {
+	require.NotNil(suite.T(), suite.authenticator.RevokeUserTokens(user.Username))
+}
 func (suite *TestSuite) TestgetUserFromRawToken_withBadUsername() {
 	token, err := suite.authenticator.CreateToken(user)
 	require.Nil(suite.T(), err)
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/context"
+)
+
+// servicePrincipal is the value of the principal
+// claim of the tokens issued to services.
+const servicePrincipal = "service"
+
+var (
+	// ErrTokenServicePrincipal is returned when a token
+	// of a service is used where an user token is expected.
+	ErrTokenServicePrincipal = errors.New("token belongs to a service principal")
+	// ErrTokenNotServicePrincipal is returned when a token
+	// of an user is used where a service token is expected.
+	ErrTokenNotServicePrincipal = errors.New("token does not belong to a service principal")
+)
+
+type contextKey int
+
+// ServicePrincipalKey is the gorilla context key of the
+// ServicePrincipal set by ServiceJWTHandlerFunc.
+const ServicePrincipalKey contextKey = 0
+
+// ServicePrincipal is the identity of a service authenticated with its
+// client credentials, as opposed to an user. Scope is the space separated
+// list of scopes granted to the service.
+type ServicePrincipal struct {
+	ClientID string
+	Scope    string
+}
+
+// CreateServiceToken creates a token for the service principal. The token has
+// no user claims so CreateUserFromToken and JWTHandlerFunc reject it.
+func (a *Authenticator) CreateServiceToken(principal *ServicePrincipal) (string, error) {
+	if principal == nil || principal.ClientID == "" {
+		return "", errors.New("service principal has no client id")
+	}
+	claims := map[string]interface{}{
+		"sub":       principal.ClientID,
+		"client_id": principal.ClientID,
+		"principal": servicePrincipal,
+	}
+	if principal.Scope != "" {
+		claims["scope"] = principal.Scope
+	}
+	return a.signToken(claims)
+}
+
+// CreateServicePrincipalFromToken returns the service principal of a token.
+// User tokens are rejected.
+func (a *Authenticator) CreateServicePrincipalFromToken(token string) (*ServicePrincipal, error) {
+	rawToken, err := a.verifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return getServicePrincipalFromRawToken(rawToken)
+}
+
+func getServicePrincipalFromRawToken(rawToken *jwt.Token) (*ServicePrincipal, error) {
+	if !isServiceToken(rawToken.Claims) {
+		return nil, ErrTokenNotServicePrincipal
+	}
+	clientID, ok := rawToken.Claims["client_id"].(string)
+	if !ok || clientID == "" {
+		return nil, errors.New("token client_id claim failed cast to string")
+	}
+	scope, _ := rawToken.Claims["scope"].(string)
+	return &ServicePrincipal{ClientID: clientID, Scope: scope}, nil
+}
+
+func isServiceToken(claims map[string]interface{}) bool {
+	principal, _ := claims["principal"].(string)
+	return principal == servicePrincipal
+}
+
+// ServiceJWTHandlerFunc is like JWTHandlerFunc for endpoints that are only
+// called by other services. The ServicePrincipal is set in the gorilla
+// context with ServicePrincipalKey.
+func (a *Authenticator) ServiceJWTHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := a.getTokenFromRequest(r)
+		principal, err := a.CreateServicePrincipalFromToken(token)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		context.Set(r, ServicePrincipalKey, principal)
+		handler(w, r)
+	}
+}
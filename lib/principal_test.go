@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/context"
+	"github.com/stretchr/testify/require"
+)
+
+var principal = &ServicePrincipal{ClientID: "data", Scope: "metadata:read"}
+
+func (suite *TestSuite) TestCreateServiceToken() {
+	token, err := suite.authenticator.CreateServiceToken(principal)
+	require.Nil(suite.T(), err)
+	p, err := suite.authenticator.CreateServicePrincipalFromToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), principal, p)
+	claims, err := suite.authenticator.Claims(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "data", claims["sub"])
+	require.Nil(suite.T(), claims["username"])
+}
+func (suite *TestSuite) TestCreateServiceToken_withoutClientID() {
+	_, err := suite.authenticator.CreateServiceToken(&ServicePrincipal{})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestCreateUserFromToken_withServiceToken() {
+	token, err := suite.authenticator.CreateServiceToken(principal)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.CreateUserFromToken(token)
+	require.Equal(suite.T(), ErrTokenServicePrincipal, err)
+}
+func (suite *TestSuite) TestCreateServicePrincipalFromToken_withUserToken() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	_, err = suite.authenticator.CreateServicePrincipalFromToken(token)
+	require.Equal(suite.T(), ErrTokenNotServicePrincipal, err)
+}
+func (suite *TestSuite) TestServiceJWTHandlerFunc() {
+	token, err := suite.authenticator.CreateServiceToken(principal)
+	require.Nil(suite.T(), err)
+	r, err := http.NewRequest("GET", "", nil)
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	var p interface{}
+	suite.authenticator.ServiceJWTHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p = context.Get(r, ServicePrincipalKey)
+	}).ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), principal, p)
+}
+func (suite *TestSuite) TestServiceJWTHandlerFunc_withUserToken() {
+	token, err := suite.authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	r, err := http.NewRequest("GET", "", nil)
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	suite.authenticator.ServiceJWTHandlerFunc(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestJWTMiddleware_withServiceToken() {
+	token, err := suite.authenticator.CreateServiceToken(principal)
+	require.Nil(suite.T(), err)
+	r, err := http.NewRequest("GET", "", nil)
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	suite.middleware(w, r)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
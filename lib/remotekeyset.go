@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRemoteKeySetMaxAge is how long the keys fetched by a
+// RemoteKeySet are cached when the response has no cache headers.
+const DefaultRemoteKeySetMaxAge = 5 * time.Minute
+
+// DefaultRemoteKeySetMinRefreshInterval is the minimum time between two
+// fetches triggered by tokens signed with an unknown key.
+const DefaultRemoteKeySetMinRefreshInterval = 30 * time.Second
+
+// DefaultRemoteKeySetTimeout bounds the requests to the JWKS endpoint
+// made with the default client.
+const DefaultRemoteKeySetTimeout = 10 * time.Second
+
+// maxKeySetSize bounds the responses of the JWKS endpoint.
+const maxKeySetSize = 1 << 20
+
+// defaultRemoteKeySetClient is used when the RemoteKeySet has no Client.
+var defaultRemoteKeySetClient = &http.Client{Timeout: DefaultRemoteKeySetTimeout}
+
+// RemoteKeySet is a KeySet that verifies tokens with the keys published on
+// the JWKS endpoint of the authentication service, so services that consume
+// tokens do not need any key material in their configuration.
+// The keys are cached following the Cache-Control max-age or Expires headers
+// of the response, and refetched when a token names an unknown key.
+// Keys are never fetched more than once per MinRefreshInterval, zero
+// meaning DefaultRemoteKeySetMinRefreshInterval, and if a fetch fails
+// the cached keys are kept. A RemoteKeySet cannot sign tokens.
+//
+// Fetches run without holding the lock of the cache and only one runs at a
+// time: while the cache is refreshed the other callers are served the cached
+// keys, and only the callers that need a key not cached yet wait for it.
+type RemoteKeySet struct {
+	URL                string
+	Client             *http.Client
+	MinRefreshInterval time.Duration
+
+	// Now returns the current time. It can be replaced
+	// to control the cache expiry in tests.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	keys        []*Key
+	expiresAt   time.Time
+	lastFetchAt time.Time
+	fetching    *keySetFetch
+}
+
+// keySetFetch is a fetch of the key set shared by the callers waiting for it.
+// err is set before done is closed.
+type keySetFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemoteKeySet returns a RemoteKeySet for the JWKS endpoint at url,
+// like https://auth.example.org/api/auth/jwks.json.
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:                url,
+		Client:             defaultRemoteKeySetClient,
+		MinRefreshInterval: DefaultRemoteKeySetMinRefreshInterval,
+		Now:                time.Now,
+	}
+}
+
+// NewRemoteAuthenticator returns an Authenticator that verifies tokens with
+// the keys published on the JWKS endpoint at url.
+func NewRemoteAuthenticator(url string) *Authenticator {
+	return NewAuthenticatorWithKeySet(NewRemoteKeySet(url))
+}
+
+func (s *RemoteKeySet) SigningKey() (*Key, error) {
+	return nil, errors.New("remote key set cannot sign tokens")
+}
+
+func (s *RemoteKeySet) VerificationKey(kid string) (*Key, error) {
+	now := s.now()
+	keys, err := s.cachedKeys(now)
+	if err != nil {
+		return nil, err
+	}
+	if k := findKey(keys, kid); k != nil {
+		return k, nil
+	}
+
+	// the key may have been rotated since the last fetch.
+	s.mu.Lock()
+	if s.fetching == nil && now.Sub(s.lastFetchAt) < s.minRefreshInterval() {
+		s.mu.Unlock()
+		return nil, ErrTokenUnknownKey
+	}
+	f, run := s.startFetch(now)
+	s.mu.Unlock()
+	if run {
+		s.runFetch(f, now)
+	} else {
+		<-f.done
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	s.mu.Lock()
+	keys = s.keys
+	s.mu.Unlock()
+	if k := findKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, ErrTokenUnknownKey
+}
+
+func (s *RemoteKeySet) VerificationKeys() ([]*Key, error) {
+	return s.cachedKeys(s.now())
+}
+
+// cachedKeys returns the cached keys, refreshing them if the cache expired.
+// Only the first fetch is waited for by every caller, the cached keys are
+// served to the others while the caller that started a refresh runs it.
+func (s *RemoteKeySet) cachedKeys(now time.Time) ([]*Key, error) {
+	s.mu.Lock()
+	keys := s.keys
+	if keys != nil && (!now.After(s.expiresAt) || now.Sub(s.lastFetchAt) < s.minRefreshInterval()) {
+		s.mu.Unlock()
+		return keys, nil
+	}
+	f, run := s.startFetch(now)
+	s.mu.Unlock()
+
+	switch {
+	case run:
+		s.runFetch(f, now)
+	case keys != nil:
+		return keys, nil
+	default:
+		<-f.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		return nil, f.err
+	}
+	return s.keys, nil
+}
+
+// startFetch returns the running fetch, or a new one when run is true, which
+// the caller must run with runFetch. It must be called with mu held.
+func (s *RemoteKeySet) startFetch(now time.Time) (f *keySetFetch, run bool) {
+	if s.fetching != nil {
+		return s.fetching, false
+	}
+	s.lastFetchAt = now
+	s.fetching = &keySetFetch{done: make(chan struct{})}
+	return s.fetching, true
+}
+
+// runFetch downloads the key set without holding mu, caches
+// it and wakes up the callers waiting for the fetch.
+func (s *RemoteKeySet) runFetch(f *keySetFetch, now time.Time) {
+	keys, expiresAt, err := s.fetch(now)
+	s.mu.Lock()
+	if err == nil {
+		s.keys, s.expiresAt = keys, expiresAt
+	}
+	s.fetching = nil
+	s.mu.Unlock()
+	f.err = err
+	close(f.done)
+}
+
+// fetch downloads the key set and returns
+// its keys and when they expire.
+func (s *RemoteKeySet) fetch(now time.Time) ([]*Key, time.Time, error) {
+	client := s.Client
+	if client == nil {
+		client = defaultRemoteKeySetClient
+	}
+	res, err := client.Get(s.URL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, time.Time{}, errors.New("fetching key set from " + s.URL + " failed with status " + res.Status)
+	}
+
+	set := &JSONWebKeySet{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxKeySetSize)).Decode(set); err != nil {
+		return nil, time.Time{}, err
+	}
+	keys := []*Key{}
+	for _, jwk := range set.Keys {
+		// keys of unsupported types are skipped
+		// so they do not break the whole set.
+		if k, err := jwk.Key(); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, now.Add(maxAge(res.Header, now)), nil
+}
+
+func (s *RemoteKeySet) minRefreshInterval() time.Duration {
+	if s.MinRefreshInterval <= 0 {
+		return DefaultRemoteKeySetMinRefreshInterval
+	}
+	return s.MinRefreshInterval
+}
+
+func (s *RemoteKeySet) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func findKey(keys []*Key, kid string) *Key {
+	for _, k := range keys {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// maxAge returns how long a response can be cached
+// following its Cache-Control and Expires headers.
+func maxAge(header http.Header, now time.Time) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache", directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		if d := expires.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return DefaultRemoteKeySetMaxAge
+}
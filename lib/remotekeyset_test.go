@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// jwksServer is a stand-in for the JWKS endpoint of the authentication service.
+type jwksServer struct {
+	*httptest.Server
+	keys         []*Key
+	cacheControl string
+	requests     int
+}
+
+func newJWKSServer(keys ...*Key) *jwksServer {
+	s := &jwksServer{keys: keys, cacheControl: "max-age=60"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		w.Header().Set("Cache-Control", s.cacheControl)
+		json.NewEncoder(w).Encode(NewJSONWebKeySet(s.keys))
+	}))
+	return s
+}
+
+func (suite *TestSuite) newECKey(id string) *Key {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	return &Key{ID: id, SigningMethod: "ES256", SigningKey: priv, VerificationKey: &priv.PublicKey}
+}
+
+func (suite *TestSuite) TestRemoteKeySet() {
+	key := suite.newECKey("key1")
+	server := newJWKSServer(key)
+	defer server.Close()
+
+	token, err := NewAuthenticatorWithKey(key).CreateToken(user)
+	require.Nil(suite.T(), err)
+	authenticator := NewRemoteAuthenticator(server.URL)
+	u, err := authenticator.CreateUserFromToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), user.Username, u.Username)
+	_, err = authenticator.CreateUserFromToken(token)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 1, server.requests)
+}
+func (suite *TestSuite) TestRemoteKeySet_withExpiredCache() {
+	server := newJWKSServer(suite.newECKey("key1"))
+	defer server.Close()
+	now := time.Now()
+	keySet := NewRemoteKeySet(server.URL)
+	keySet.Now = func() time.Time { return now }
+
+	_, err := keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+	now = now.Add(59 * time.Second)
+	_, err = keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 1, server.requests)
+	now = now.Add(2 * time.Second)
+	_, err = keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), 2, server.requests)
+}
+func (suite *TestSuite) TestRemoteKeySet_withUnknownKey() {
+	server := newJWKSServer(suite.newECKey("key1"))
+	defer server.Close()
+	now := time.Now()
+	keySet := NewRemoteKeySet(server.URL)
+	keySet.Now = func() time.Time { return now }
+
+	_, err := keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+
+	// unknown keys do not trigger a fetch before MinRefreshInterval.
+	key2 := suite.newECKey("key2")
+	server.keys = append(server.keys, key2)
+	_, err = keySet.VerificationKey("key2")
+	require.Equal(suite.T(), ErrTokenUnknownKey, err)
+	require.Equal(suite.T(), 1, server.requests)
+
+	now = now.Add(DefaultRemoteKeySetMinRefreshInterval)
+	k, err := keySet.VerificationKey("key2")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "ES256", k.SigningMethod)
+	require.Equal(suite.T(), 2, server.requests)
+}
+func (suite *TestSuite) TestRemoteKeySet_withoutMinRefreshInterval() {
+	server := newJWKSServer(suite.newECKey("key1"))
+	defer server.Close()
+	now := time.Now()
+	keySet := &RemoteKeySet{URL: server.URL, Now: func() time.Time { return now }}
+	_, err := keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+	for i := 0; i < 3; i++ {
+		_, err = keySet.VerificationKey("unknown")
+		require.Equal(suite.T(), ErrTokenUnknownKey, err)
+	}
+	require.Equal(suite.T(), 1, server.requests)
+}
+func (suite *TestSuite) TestRemoteKeySet_withServerError() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	_, err := NewRemoteKeySet(server.URL).VerificationKey("key1")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRemoteKeySet_withServerErrorAndCachedKeys() {
+	server := newJWKSServer(suite.newECKey("key1"))
+	defer server.Close()
+	now := time.Now()
+	keySet := NewRemoteKeySet(server.URL)
+	keySet.Now = func() time.Time { return now }
+	_, err := keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+
+	server.Close()
+	now = now.Add(time.Hour)
+	_, err = keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRemoteKeySet_withSlowRefresh() {
+	server := newJWKSServer(suite.newECKey("key1"))
+	defer server.Close()
+	now := time.Now()
+	keySet := NewRemoteKeySet(server.URL)
+	keySet.Now = func() time.Time { return now }
+	_, err := keySet.VerificationKey("key1")
+	require.Nil(suite.T(), err)
+
+	// the refresh blocks until released, the cached keys are served meanwhile.
+	handler := server.Config.Handler
+	started, release := make(chan struct{}), make(chan struct{})
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		handler.ServeHTTP(w, r)
+	})
+	now = now.Add(time.Hour)
+	refreshed := make(chan error)
+	go func() {
+		_, err := keySet.VerificationKey("key1")
+		refreshed <- err
+	}()
+	<-started
+	for i := 0; i < 3; i++ {
+		_, err = keySet.VerificationKey("key1")
+		require.Nil(suite.T(), err)
+	}
+	close(release)
+	require.Nil(suite.T(), <-refreshed)
+	require.Equal(suite.T(), 2, server.requests)
+}
+func (suite *TestSuite) TestRemoteKeySet_withTimeout() {
+	require.Equal(suite.T(), DefaultRemoteKeySetTimeout, NewRemoteKeySet("http://localhost").Client.Timeout)
+}
+func (suite *TestSuite) TestRemoteKeySet_cannotSign() {
+	_, err := NewRemoteAuthenticator("http://localhost").CreateToken(user)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestmaxAge() {
+	now := time.Now()
+	header := http.Header{}
+	require.Equal(suite.T(), DefaultRemoteKeySetMaxAge, maxAge(header, now))
+	header.Set("Cache-Control", "public, max-age=120")
+	require.Equal(suite.T(), 2*time.Minute, maxAge(header, now))
+	header.Set("Cache-Control", "no-cache")
+	require.Equal(suite.T(), time.Duration(0), maxAge(header, now))
+	header.Del("Cache-Control")
+	header.Set("Expires", now.Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.InDelta(suite.T(), float64(time.Hour), float64(maxAge(header, now)), float64(time.Second))
+}
+func (suite *TestSuite) TestJSONWebKey_Key() {
+	key := suite.newECKey("key1")
+	jwk, err := NewJSONWebKey(key)
+	require.Nil(suite.T(), err)
+	jwk.Alg = ""
+	parsed, err := jwk.Key()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "ES256", parsed.SigningMethod)
+	require.Equal(suite.T(), key.VerificationKey, parsed.VerificationKey)
+}
+func (suite *TestSuite) TestJSONWebKey_KeyWithEncryptionKey() {
+	jwk, err := NewJSONWebKey(suite.newECKey("key1"))
+	require.Nil(suite.T(), err)
+	jwk.Use = "enc"
+	_, err = jwk.Key()
+	require.NotNil(suite.T(), err)
+}
@@ -0,0 +1,101 @@
+package passwordhasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2DefaultTime = 3
+	argon2Memory      = 64 * 1024
+	argon2Threads     = 4
+	argon2SaltLength  = 16
+	argon2KeyLength   = 32
+
+	// the parameters of stored hashes are bounded so a crafted
+	// hash can not make a login allocate or compute without limit.
+	argon2MaxTime      = 32
+	argon2MaxMemory    = 1024 * 1024
+	argon2MaxThreads   = 16
+	argon2MaxKeyLength = 64
+)
+
+type argon2idHasher struct {
+	time uint32
+}
+
+func newArgon2id(time int) (PasswordHasher, error) {
+	if time == 0 {
+		time = argon2DefaultTime
+	}
+	if time < 1 || time > argon2MaxTime {
+		return nil, errors.New("argon2id cost is out of range")
+	}
+	return &argon2idHasher{time: uint32(time)}, nil
+}
+
+// Hash returns a PHC string like $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+func (h *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, argon2Memory, argon2Threads, argon2KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version,
+		argon2Memory, h.time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *argon2idHasher) Verify(encoded, password string) (bool, error) {
+	return Verify(encoded, password)
+}
+
+func (h *argon2idHasher) NeedsRehash(encoded string) bool {
+	if Format(encoded) != Argon2id {
+		return true
+	}
+	memory, time, _, _, _, err := decodeArgon2id(encoded)
+	return err != nil || time < h.time || memory < argon2Memory
+}
+
+func verifyArgon2id(encoded, password string) (bool, error) {
+	memory, time, threads, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeArgon2id(encoded string) (memory, time uint32, threads uint8, salt, key []byte, err error) {
+	fields, err := parsePHC(encoded)
+	if err != nil || len(fields) != 4 {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(fields[1], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	if time < 1 || time > argon2MaxTime || memory > argon2MaxMemory ||
+		threads < 1 || threads > argon2MaxThreads {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	key, err = base64.RawStdEncoding.DecodeString(fields[3])
+	if err != nil || len(key) == 0 || len(key) > argon2MaxKeyLength {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	return memory, time, threads, salt, key, nil
+}
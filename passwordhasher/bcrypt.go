@@ -0,0 +1,85 @@
+package passwordhasher
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptMaxCost bounds the cost of stored hashes so a crafted hash
+// can not make a login compute without limit. A cost of 16 already
+// takes seconds, far above what is configured in practice.
+const bcryptMaxCost = 16
+
+type bcryptHasher struct {
+	cost int
+}
+
+func newBcrypt(cost int) (PasswordHasher, error) {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost || cost > bcryptMaxCost {
+		return nil, errors.New("bcrypt cost is out of range")
+	}
+	return &bcryptHasher{cost: cost}, nil
+}
+
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *bcryptHasher) Verify(encoded, password string) (bool, error) {
+	return Verify(encoded, password)
+}
+
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	if Format(encoded) != Bcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.cost
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// bcryptLength is the length of the hashes in modular crypt format.
+const bcryptLength = 60
+
+func validBcrypt(encoded string) error {
+	if len(encoded) != bcryptLength {
+		return ErrUnknownFormat
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return err
+	}
+	if cost > bcryptMaxCost {
+		return ErrUnknownFormat
+	}
+	return nil
+}
+
+// verifyBcrypt compares in constant time thanks to bcrypt.CompareHashAndPassword.
+func verifyBcrypt(encoded, password string) (bool, error) {
+	if err := validBcrypt(encoded); err != nil {
+		return false, err
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,139 @@
+package passwordhasher
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+// Supported hashing algorithms.
+const (
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
+	Argon2id = "argon2id"
+)
+
+// Plaintext is the format of legacy passwords stored without hashing.
+// They are still accepted by Verify so they can be migrated on login.
+const Plaintext = "plaintext"
+
+// DefaultAlgorithm is the algorithm used when none is configured.
+const DefaultAlgorithm = Bcrypt
+
+// ErrUnknownFormat is returned when an encoded hash
+// is not in any of the supported formats.
+var ErrUnknownFormat = errors.New("password hash format is unknown")
+
+// PasswordHasher defines an interface to hash passwords
+// and verify them against their encoded hashes.
+type PasswordHasher interface {
+	// Hash returns the salted hash of the password in
+	// a self-describing format (PHC string or modular crypt).
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	// Comparisons are done in constant time.
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether the encoded hash is a legacy
+	// format or is below the configured algorithm and cost.
+	NeedsRehash(encoded string) bool
+}
+
+// Options holds the configuration
+// parameters used by the PasswordHasher.
+// The meaning of Cost depends on the algorithm:
+// the bcrypt cost up to 16 for bcrypt, log2(N) up to 20 for scrypt and
+// the number of iterations up to 32 for argon2id.
+// A zero Cost selects the algorithm default.
+type Options struct {
+	Algorithm string
+	Cost      int
+}
+
+// New returns a PasswordHasher that hashes new passwords with
+// the configured algorithm and cost. Its Verify method accepts
+// hashes produced by any of the supported algorithms, so the algorithm
+// can be changed without invalidating existing passwords.
+func New(opts *Options) (PasswordHasher, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	switch opts.Algorithm {
+	case "", Bcrypt:
+		return newBcrypt(opts.Cost)
+	case Scrypt:
+		return newScrypt(opts.Cost)
+	case Argon2id:
+		return newArgon2id(opts.Cost)
+	default:
+		return nil, errors.New("password hasher algorithm " + opts.Algorithm + " does not exist")
+	}
+}
+
+// Verify reports whether the password matches the encoded hash,
+// whatever supported algorithm produced it.
+func Verify(encoded, password string) (bool, error) {
+	switch Format(encoded) {
+	case Bcrypt:
+		return verifyBcrypt(encoded, password)
+	case Scrypt:
+		return verifyScrypt(encoded, password)
+	case Argon2id:
+		return verifyArgon2id(encoded, password)
+	case SHACrypt:
+		return verifySHACrypt(encoded, password)
+	case Plaintext:
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1, nil
+	default:
+		return false, ErrUnknownFormat
+	}
+}
+
+// Format returns the algorithm that produced the encoded hash,
+// Plaintext for values that are not hashes, or an empty
+// string for hashes in an unsupported format.
+func Format(encoded string) string {
+	switch {
+	case isBcrypt(encoded):
+		return Bcrypt
+	case strings.HasPrefix(encoded, "$"+Scrypt+"$"):
+		return Scrypt
+	case strings.HasPrefix(encoded, "$"+Argon2id+"$"):
+		return Argon2id
+	case isSHACrypt(encoded):
+		return SHACrypt
+	case encoded != "" && !strings.HasPrefix(encoded, "$"):
+		return Plaintext
+	default:
+		return ""
+	}
+}
+
+// Valid reports whether the encoded hash is well formed in one of the
+// supported algorithms. Plaintext values are not valid hashes.
+func Valid(encoded string) bool {
+	var err error
+	switch Format(encoded) {
+	case Bcrypt:
+		err = validBcrypt(encoded)
+	case Scrypt:
+		_, _, _, _, _, err = decodeScrypt(encoded)
+	case Argon2id:
+		_, _, _, _, _, err = decodeArgon2id(encoded)
+	case SHACrypt:
+		_, _, _, _, err = decodeSHACrypt(encoded)
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// parsePHC splits a PHC string of the form
+// $<id>$<params>$<salt>$<hash> into its fields.
+// Fields are returned without the leading id.
+func parsePHC(encoded string) ([]string, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 2 || parts[0] != "" {
+		return nil, ErrUnknownFormat
+	}
+	return parts[2:], nil
+}
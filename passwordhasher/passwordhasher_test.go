@@ -0,0 +1,197 @@
+package passwordhasher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+
+func (suite *TestSuite) TestNew() {
+	for _, algorithm := range []string{"", Bcrypt, Scrypt, Argon2id} {
+		h, err := New(&Options{Algorithm: algorithm})
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), h)
+	}
+}
+func (suite *TestSuite) TestNew_withNilOptions() {
+	h, err := New(nil)
+	require.Nil(suite.T(), err)
+	require.NotNil(suite.T(), h)
+}
+func (suite *TestSuite) TestNew_withBadAlgorithm() {
+	_, err := New(&Options{Algorithm: "md5"})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadCost() {
+	_, err := New(&Options{Algorithm: Bcrypt, Cost: 100})
+	require.NotNil(suite.T(), err)
+	_, err = New(&Options{Algorithm: Scrypt, Cost: 100})
+	require.NotNil(suite.T(), err)
+	_, err = New(&Options{Algorithm: Argon2id, Cost: -1})
+	require.NotNil(suite.T(), err)
+	_, err = New(&Options{Algorithm: Argon2id, Cost: 100})
+	require.NotNil(suite.T(), err)
+	_, err = New(&Options{Algorithm: Bcrypt, Cost: 31})
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestHash_withBcrypt() {
+	suite.testHash(&Options{Algorithm: Bcrypt, Cost: 4}, "$2a$04$")
+}
+func (suite *TestSuite) TestHash_withScrypt() {
+	suite.testHash(&Options{Algorithm: Scrypt, Cost: 4}, "$scrypt$ln=4,r=8,p=1$")
+}
+func (suite *TestSuite) TestHash_withArgon2id() {
+	suite.testHash(&Options{Algorithm: Argon2id, Cost: 1}, "$argon2id$v=19$m=65536,t=1,p=4$")
+}
+func (suite *TestSuite) TestHash_isSalted() {
+	h, err := New(&Options{Algorithm: Scrypt, Cost: 4})
+	require.Nil(suite.T(), err)
+	a, err := h.Hash("secret")
+	require.Nil(suite.T(), err)
+	b, err := h.Hash("secret")
+	require.Nil(suite.T(), err)
+	require.NotEqual(suite.T(), a, b)
+}
+func (suite *TestSuite) TestVerify_withOtherAlgorithm() {
+	scrypt, err := New(&Options{Algorithm: Scrypt, Cost: 4})
+	require.Nil(suite.T(), err)
+	encoded, err := scrypt.Hash("secret")
+	require.Nil(suite.T(), err)
+	bcrypt, err := New(&Options{Algorithm: Bcrypt, Cost: 4})
+	require.Nil(suite.T(), err)
+	ok, err := bcrypt.Verify(encoded, "secret")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), ok)
+}
+func (suite *TestSuite) TestVerify_withPlaintext() {
+	ok, err := Verify("secret", "secret")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), ok)
+	ok, err = Verify("secret", "notsecret")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), ok)
+}
+func (suite *TestSuite) TestVerify_withUnknownFormat() {
+	_, err := Verify("$md5$secret", "secret")
+	require.Equal(suite.T(), ErrUnknownFormat, err)
+}
+func (suite *TestSuite) TestVerify_withSHACrypt() {
+	// vectors generated with openssl passwd -5 and -6.
+	for _, v := range []struct{ encoded, password string }{
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"$5$saltstringsaltst$a5C8Ofk71MUIoKve2QuP9FMl.dwNgseF5tR1LGAL7iB", "Hello world!"},
+		{"$5$rounds=10000$roundstoolow$oQpEIYwZHYrOeIVeIijLDHEw2PkF0Nw85WW1s.YffI/", "the minimum number is still observed"},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!"},
+		{"$6$toolongsaltstrin$fyw2UNw3WtFkgW.7HTmZfVPyaMH5x/XhTbYcjGYXDrkD5ND32dHFAFfXlsS0m3HFdcxFgo.GfAmEFKNB91ifR.", "we have a short salt string but not a short password and a long password"},
+	} {
+		ok, err := Verify(v.encoded, v.password)
+		require.Nil(suite.T(), err)
+		require.True(suite.T(), ok, v.encoded)
+		ok, err = Verify(v.encoded, "notsecret")
+		require.Nil(suite.T(), err)
+		require.False(suite.T(), ok, v.encoded)
+	}
+}
+func (suite *TestSuite) TestFormat() {
+	require.Equal(suite.T(), Bcrypt, Format("$2y$10$xxx"))
+	require.Equal(suite.T(), Scrypt, Format("$scrypt$ln=15,r=8,p=1$xxx$xxx"))
+	require.Equal(suite.T(), Argon2id, Format("$argon2id$v=19$m=65536,t=3,p=4$xxx$xxx"))
+	require.Equal(suite.T(), SHACrypt, Format("$5$saltstring$xxx"))
+	require.Equal(suite.T(), SHACrypt, Format("$6$rounds=10000$saltstring$xxx"))
+	require.Equal(suite.T(), Plaintext, Format("secret"))
+	require.Equal(suite.T(), "", Format("$md5$secret"))
+	require.Equal(suite.T(), "", Format(""))
+}
+func (suite *TestSuite) TestValid() {
+	for _, h := range []*Options{{Algorithm: Bcrypt, Cost: 4}, {Algorithm: Scrypt, Cost: 4}, {Algorithm: Argon2id, Cost: 1}} {
+		hasher, err := New(h)
+		require.Nil(suite.T(), err)
+		encoded, err := hasher.Hash("secret")
+		require.Nil(suite.T(), err)
+		require.True(suite.T(), Valid(encoded), encoded)
+	}
+	require.True(suite.T(), Valid("$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"))
+	for _, encoded := range []string{"", "secret", "$md5$secret", "$2a$secret", "$5$salt$secret", "$scrypt$secret", "$argon2id$secret"} {
+		require.False(suite.T(), Valid(encoded), encoded)
+	}
+}
+func (suite *TestSuite) TestNeedsRehash() {
+	weak, err := New(&Options{Algorithm: Scrypt, Cost: 4})
+	require.Nil(suite.T(), err)
+	strong, err := New(&Options{Algorithm: Scrypt, Cost: 5})
+	require.Nil(suite.T(), err)
+	encoded, err := weak.Hash("secret")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), weak.NeedsRehash(encoded))
+	require.True(suite.T(), strong.NeedsRehash(encoded))
+	require.True(suite.T(), strong.NeedsRehash("secret"))
+}
+func (suite *TestSuite) TestNeedsRehash_withOtherAlgorithm() {
+	bcrypt, err := New(&Options{Algorithm: Bcrypt, Cost: 4})
+	require.Nil(suite.T(), err)
+	argon2id, err := New(&Options{Algorithm: Argon2id, Cost: 1})
+	require.Nil(suite.T(), err)
+	encoded, err := bcrypt.Hash("secret")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), bcrypt.NeedsRehash(encoded))
+	require.True(suite.T(), argon2id.NeedsRehash(encoded))
+	encoded, err = argon2id.Hash("secret")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), argon2id.NeedsRehash(encoded))
+	require.True(suite.T(), bcrypt.NeedsRehash(encoded))
+}
+func (suite *TestSuite) TestVerify_withMalformedHash() {
+	for _, encoded := range []string{
+		"$scrypt$ln=4,r=8,p=1$salt",
+		"$scrypt$ln=x$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=1,p=4$c2FsdA",
+		"$argon2id$v=1$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$aGFzaA",
+		"$5$saltstring",
+		"$6$rounds=x$saltstring$aGFzaA",
+		"$5$saltstring$tooshort",
+	} {
+		_, err := Verify(encoded, "secret")
+		require.Equal(suite.T(), ErrUnknownFormat, err, encoded)
+	}
+}
+func (suite *TestSuite) TestVerify_withExcessiveParameters() {
+	for _, encoded := range []string{
+		"$scrypt$ln=31,r=8,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=4,r=1024,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=4,r=8,p=1000000$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=4294967295,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=4294967295,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=1,p=255$c2FsdA$aGFzaA",
+		"$2a$31$De314cF4i52YR6Ybpbx5r.kF3b9t56JnOYpXwQ0CGmyXlwmXALlpG",
+		"$5$rounds=999999999$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+	} {
+		_, err := Verify(encoded, "secret")
+		require.Equal(suite.T(), ErrUnknownFormat, err, encoded)
+	}
+}
+
+func (suite *TestSuite) testHash(opts *Options, prefix string) {
+	h, err := New(opts)
+	require.Nil(suite.T(), err)
+	encoded, err := h.Hash("secret")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), strings.HasPrefix(encoded, prefix), encoded)
+	ok, err := h.Verify(encoded, "secret")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), ok)
+	ok, err = h.Verify(encoded, "notsecret")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), ok)
+}
@@ -0,0 +1,102 @@
+package passwordhasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptDefaultLogN = 15
+	scryptR           = 8
+	scryptP           = 1
+	scryptSaltLength  = 16
+	scryptKeyLength   = 32
+
+	// the parameters of stored hashes are bounded so a crafted
+	// hash can not make a login allocate or compute without limit.
+	// 128*r*N bytes are allocated, up to 1GiB with the maximums.
+	scryptMaxLogN      = 20
+	scryptMaxR         = 8
+	scryptMaxP         = 16
+	scryptMaxKeyLength = 64
+)
+
+type scryptHasher struct {
+	logN int
+}
+
+func newScrypt(logN int) (PasswordHasher, error) {
+	if logN == 0 {
+		logN = scryptDefaultLogN
+	}
+	if logN < 1 || logN > scryptMaxLogN {
+		return nil, errors.New("scrypt cost is out of range")
+	}
+	return &scryptHasher{logN: logN}, nil
+}
+
+// Hash returns a PHC string like $scrypt$ln=15,r=8,p=1$<salt>$<hash>.
+func (h *scryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, scryptSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(h.logN), scryptR, scryptP, scryptKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", Scrypt, h.logN, scryptR, scryptP,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *scryptHasher) Verify(encoded, password string) (bool, error) {
+	return Verify(encoded, password)
+}
+
+func (h *scryptHasher) NeedsRehash(encoded string) bool {
+	if Format(encoded) != Scrypt {
+		return true
+	}
+	logN, _, _, _, _, err := decodeScrypt(encoded)
+	return err != nil || logN < h.logN
+}
+
+func verifyScrypt(encoded, password string) (bool, error) {
+	logN, r, p, salt, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(password), salt, 1<<uint(logN), r, p, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func decodeScrypt(encoded string) (logN, r, p int, salt, key []byte, err error) {
+	fields, err := parsePHC(encoded)
+	if err != nil || len(fields) != 3 {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(fields[0], "ln=%d,r=%d,p=%d", &logN, &r, &p); err != nil {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	if logN < 1 || logN > scryptMaxLogN || r < 1 || r > scryptMaxR || p < 1 || p > scryptMaxP {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	key, err = base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil || len(key) == 0 || len(key) > scryptMaxKeyLength {
+		return 0, 0, 0, nil, nil, ErrUnknownFormat
+	}
+	return logN, r, p, salt, key, nil
+}
@@ -0,0 +1,180 @@
+package passwordhasher
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SHACrypt is the format of the SHA-256 ($5$) and SHA-512 ($6$) crypt
+// hashes of Ulrich Drepper, used by htpasswd -2/-5 and /etc/shadow. They
+// can only be verified; new passwords are hashed with the configured algorithm.
+const SHACrypt = "sha-crypt"
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxSalt       = 16
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// crypt(3) accepts up to 999999999 rounds, stored hashes are bounded
+	// lower so a crafted hash can not make a login compute without limit.
+	shaCryptMaxRounds = 1000000
+)
+
+// the order in which the bytes of the digests are encoded, by groups of three.
+var (
+	sha256CryptOrder = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	sha512CryptOrder = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+func isSHACrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$5$") || strings.HasPrefix(encoded, "$6$")
+}
+
+func verifySHACrypt(encoded, password string) (bool, error) {
+	newHash, order := sha256.New, sha256CryptOrder
+	if strings.HasPrefix(encoded, "$6$") {
+		newHash, order = sha512.New, sha512CryptOrder
+	}
+	rounds, custom, salt, _, err := decodeSHACrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := shaCrypt(newHash, order, []byte(password), []byte(salt), rounds)
+	prefix := encoded[:3]
+	if custom {
+		prefix += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	expected := prefix + salt + "$" + computed
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(expected)) == 1, nil
+}
+
+// decodeSHACrypt returns the fields of the encoded hash, with the minimum
+// rounds and the salt clamped to their limits as crypt(3) does.
+func decodeSHACrypt(encoded string) (rounds int, custom bool, salt, digest string, err error) {
+	fields := strings.Split(encoded[3:], "$")
+	rounds = shaCryptDefaultRounds
+	if strings.HasPrefix(fields[0], "rounds=") {
+		n, err := strconv.Atoi(strings.TrimPrefix(fields[0], "rounds="))
+		if err != nil || n < 1 || n > shaCryptMaxRounds {
+			return 0, false, "", "", ErrUnknownFormat
+		}
+		rounds, custom = n, true
+		fields = fields[1:]
+	}
+	if len(fields) != 2 {
+		return 0, false, "", "", ErrUnknownFormat
+	}
+	digestLength := 43
+	if strings.HasPrefix(encoded, "$6$") {
+		digestLength = 86
+	}
+	salt, digest = fields[0], fields[1]
+	if len(digest) != digestLength || strings.Trim(digest, shaCryptAlphabet) != "" {
+		return 0, false, "", "", ErrUnknownFormat
+	}
+	if rounds < shaCryptMinRounds {
+		rounds = shaCryptMinRounds
+	}
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	return rounds, custom, salt, digest, nil
+}
+
+// shaCrypt returns the encoded digest of the password, following
+// https://www.akkadia.org/drepper/SHA-crypt.txt.
+func shaCrypt(newHash func() hash.Hash, order []int, password, salt []byte, rounds int) string {
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	a.Write(repeat(digestB, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	dp := newHash()
+	for i := 0; i < len(password); i++ {
+		dp.Write(password)
+	}
+	p := repeat(dp.Sum(nil), len(password))
+
+	ds := newHash()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeat(ds.Sum(nil), len(salt))
+
+	c := digestA
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out []byte
+	for i := 0; i < len(order); i += 3 {
+		out = appendCrypt64(out, uint(c[order[i]])<<16|uint(c[order[i+1]])<<8|uint(c[order[i+2]]), 4)
+	}
+	if len(c) == sha256.Size {
+		return string(appendCrypt64(out, uint(c[31])<<8|uint(c[30]), 3))
+	}
+	return string(appendCrypt64(out, uint(c[63]), 2))
+}
+
+// repeat returns the bytes repeated up to n bytes.
+func repeat(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out)+len(b) <= n {
+		out = append(out, b...)
+	}
+	return append(out, b[:n-len(out)]...)
+}
+
+func appendCrypt64(out []byte, v uint, n int) []byte {
+	for i := 0; i < n; i++ {
+		out = append(out, shaCryptAlphabet[v&0x3f])
+		v >>= 6
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMinLength is the minimum number of characters of a password.
+	DefaultMinLength = 8
+
+	// DefaultMaxLength is the maximum length of a password in bytes,
+	// the most bcrypt can hash.
+	DefaultMaxLength = 72
+)
+
+// Options holds the configuration parameters used by the policy.
+// MinLength is a number of characters and MaxLength a number of
+// bytes, zero means DefaultMinLength and DefaultMaxLength.
+type Options struct {
+	MinLength int
+	MaxLength int
+}
+
+// Policy validates the passwords chosen by the users.
+type Policy struct {
+	minLength int
+	maxLength int
+}
+
+// New returns a Policy. Options can be nil to use the defaults.
+func New(opts *Options) *Policy {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p := &Policy{minLength: opts.MinLength, maxLength: opts.MaxLength}
+	if p.minLength <= 0 {
+		p.minLength = DefaultMinLength
+	}
+	if p.maxLength <= 0 {
+		p.maxLength = DefaultMaxLength
+	}
+	return p
+}
+
+// Check returns an error, meant to be shown to the
+// user, if the password of the user is not acceptable.
+func (p *Policy) Check(username, password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return errors.New("password must have at least " + strconv.Itoa(p.minLength) + " characters")
+	}
+	if len(password) > p.maxLength {
+		return errors.New("password must have at most " + strconv.Itoa(p.maxLength) + " bytes")
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return errors.New("password can not be the username")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package passwordpolicy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) TestNew() {
+	p := New(nil)
+	require.Equal(suite.T(), DefaultMinLength, p.minLength)
+	require.Equal(suite.T(), DefaultMaxLength, p.maxLength)
+}
+func (suite *TestSuite) TestCheck() {
+	p := New(nil)
+	require.Nil(suite.T(), p.Check("jdoe", "correct horse"))
+	// characters, not bytes, are counted for the minimum.
+	require.Nil(suite.T(), p.Check("jdoe", "contraseña"))
+	require.Nil(suite.T(), p.Check("jdoe", strings.Repeat("a", DefaultMaxLength)))
+}
+func (suite *TestSuite) TestCheck_withShortPassword() {
+	p := New(&Options{MinLength: 12})
+	require.NotNil(suite.T(), p.Check("jdoe", "correct"))
+	require.NotNil(suite.T(), p.Check("jdoe", "ñññññññ"))
+}
+func (suite *TestSuite) TestCheck_withLongPassword() {
+	p := New(nil)
+	require.NotNil(suite.T(), p.Check("jdoe", strings.Repeat("a", DefaultMaxLength+1)))
+	require.NotNil(suite.T(), p.Check("jdoe", strings.Repeat("ñ", DefaultMaxLength/2+1)))
+}
+func (suite *TestSuite) TestCheck_withUsername() {
+	p := New(nil)
+	require.NotNil(suite.T(), p.Check("JohnDoe1", "johndoe1"))
+}
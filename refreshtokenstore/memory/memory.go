@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/refreshtokenstore"
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the refresh tokens, zero means refreshtokenstore.DefaultTTL.
+// MaxLifetime is the lifetime of their families, zero means refreshtokenstore.DefaultMaxLifetime.
+type Options struct {
+	TTL         time.Duration
+	MaxLifetime time.Duration
+}
+
+// New returns a RefreshTokenStore that keeps refresh tokens in memory.
+// Tokens do not survive restarts. This store is for testing purposes.
+func New(opts *Options) refreshtokenstore.RefreshTokenStore {
+	ttl := refreshtokenstore.DefaultTTL
+	if opts != nil && opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	maxLifetime := refreshtokenstore.DefaultMaxLifetime
+	if opts != nil && opts.MaxLifetime > 0 {
+		maxLifetime = opts.MaxLifetime
+	}
+	return &store{
+		ttl:         ttl,
+		maxLifetime: maxLifetime,
+		tokens:      map[string]*record{},
+		now:         time.Now,
+	}
+}
+
+type record struct {
+	family          string
+	familyExpiresAt time.Time
+	grant           *refreshtokenstore.Grant
+	expiresAt       time.Time
+	used            bool
+}
+
+type store struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	maxLifetime time.Duration
+	tokens      map[string]*record
+	now         func() time.Time
+}
+
+func (s *store) Issue(grant *refreshtokenstore.Grant) (string, error) {
+	family, err := refreshtokenstore.NewFamily()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteExpired()
+	return s.issue(family, s.now().Add(s.maxLifetime), grant)
+}
+
+func (s *store) Rotate(token, clientID, scope string) (*refreshtokenstore.Grant, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.tokens[refreshtokenstore.Hash(token)]
+	if !ok || s.now().After(rec.expiresAt) {
+		return nil, "", refreshtokenstore.ErrInvalidToken
+	}
+	if rec.grant.ClientID != clientID {
+		return nil, "", refreshtokenstore.ErrInvalidToken
+	}
+	if !refreshtokenstore.IsScopeSubset(scope, rec.grant.Scope) {
+		return nil, "", refreshtokenstore.ErrInvalidScope
+	}
+	if rec.used {
+		s.revokeFamily(rec.family)
+		return nil, "", refreshtokenstore.ErrReusedToken
+	}
+	newToken, err := s.issue(rec.family, rec.familyExpiresAt, rec.grant)
+	if err != nil {
+		return nil, "", err
+	}
+	rec.used = true
+	return rec.grant, newToken, nil
+}
+
+func (s *store) Revoke(token, clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.tokens[refreshtokenstore.Hash(token)]
+	if !ok || (clientID != "" && rec.grant.ClientID != "" && rec.grant.ClientID != clientID) {
+		return nil
+	}
+	s.revokeFamily(rec.family)
+	return nil
+}
+
+func (s *store) RevokeUser(username, keepToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keepFamily string
+	if rec, ok := s.tokens[refreshtokenstore.Hash(keepToken)]; ok && keepToken != "" {
+		keepFamily = rec.family
+	}
+	for hash, rec := range s.tokens {
+		if rec.grant.User.Username == username && (keepFamily == "" || rec.family != keepFamily) {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// issue must be called with mu held. The token
+// expires with its family at the latest.
+func (s *store) issue(family string, familyExpiresAt time.Time, grant *refreshtokenstore.Grant) (string, error) {
+	token, hash, err := refreshtokenstore.NewToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := s.now().Add(s.ttl)
+	if expiresAt.After(familyExpiresAt) {
+		expiresAt = familyExpiresAt
+	}
+	s.tokens[hash] = &record{
+		family:          family,
+		familyExpiresAt: familyExpiresAt,
+		grant:           grant,
+		expiresAt:       expiresAt,
+	}
+	return token, nil
+}
+
+// revokeFamily must be called with mu held.
+func (s *store) revokeFamily(family string) {
+	for hash, rec := range s.tokens {
+		if rec.family == family {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// deleteExpired must be called with mu held.
+func (s *store) deleteExpired() {
+	now := s.now()
+	for hash, rec := range s.tokens {
+		if now.After(rec.expiresAt) {
+			delete(s.tokens, hash)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/refreshtokenstore"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+var grant = &refreshtokenstore.Grant{
+	User:     &entities.User{Username: "test", Email: "test@test.com", DisplayName: "Test"},
+	ClientID: "client",
+	Scope:    "read write",
+}
+
+type TestSuite struct {
+	suite.Suite
+	refreshTokenStore refreshtokenstore.RefreshTokenStore
+	store             *store
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	suite.refreshTokenStore = New(&Options{TTL: time.Hour})
+	suite.store = suite.refreshTokenStore.(*store)
+}
+
+func (suite *TestSuite) TestRotate() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	g, newToken, err := suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), grant, g)
+	require.NotEqual(suite.T(), token, newToken)
+	_, _, err = suite.refreshTokenStore.Rotate(newToken, "client", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRotate_withOtherClient() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "other", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	// the token was not exchanged, so it is not reused by its client.
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRotate_withoutClient() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+
+	// tokens issued without a client are not exchanged by any client.
+	token, err = suite.refreshTokenStore.Issue(&refreshtokenstore.Grant{User: grant.User})
+	require.Nil(suite.T(), err)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRotate_withExceedingScope() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "read admin")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidScope, err)
+	// the token was not exchanged, so the retry is not a reuse.
+	g, _, err := suite.refreshTokenStore.Rotate(token, "client", "read")
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), grant, g)
+}
+func (suite *TestSuite) TestRotate_withExpiredFamily() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	suite.store.maxLifetime = 90 * time.Minute
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	now = now.Add(50 * time.Minute)
+	_, token, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+	// the new token expires with its family, before its TTL.
+	now = now.Add(50 * time.Minute)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestRotate_withUnknownToken() {
+	_, _, err := suite.refreshTokenStore.Rotate("unknown", "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestRotate_withExpiredToken() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	now = now.Add(2 * time.Hour)
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestRotate_withReusedToken() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	other, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, newToken, err := suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrReusedToken, err)
+	// the whole family is revoked.
+	_, _, err = suite.refreshTokenStore.Rotate(newToken, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	// other families are not.
+	_, _, err = suite.refreshTokenStore.Rotate(other, "client", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestIssue_deletesExpiredTokens() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	_, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	now = now.Add(2 * time.Hour)
+	_, err = suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), suite.store.tokens, 1)
+}
+func (suite *TestSuite) TestRevoke() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, newToken, err := suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.Revoke(token, "client"))
+	_, _, err = suite.refreshTokenStore.Rotate(newToken, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestRevoke_withOtherClient() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.Revoke(token, "other"))
+	_, token, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.Revoke(token, ""))
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestRevoke_withUnknownToken() {
+	require.Nil(suite.T(), suite.refreshTokenStore.Revoke("unknown", "client"))
+}
+func (suite *TestSuite) TestRevokeUser() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	other := &refreshtokenstore.Grant{User: &entities.User{Username: "other"}, ClientID: "client"}
+	otherToken, err := suite.refreshTokenStore.Issue(other)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.RevokeUser("test", ""))
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	_, _, err = suite.refreshTokenStore.Rotate(otherToken, "client", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevokeUser_withKeepToken() {
+	token, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	_, keepToken, err := suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Nil(suite.T(), err)
+	otherToken, err := suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.RevokeUser("test", keepToken))
+	_, _, err = suite.refreshTokenStore.Rotate(otherToken, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	_, _, err = suite.refreshTokenStore.Rotate(keepToken, "client", "")
+	require.Nil(suite.T(), err)
+
+	// a token of another user does not keep anything.
+	token, err = suite.refreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	other := &refreshtokenstore.Grant{User: &entities.User{Username: "other"}}
+	otherToken, err = suite.refreshTokenStore.Issue(other)
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.refreshTokenStore.RevokeUser("test", otherToken))
+	_, _, err = suite.refreshTokenStore.Rotate(token, "client", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
@@ -0,0 +1,117 @@
+package refreshtokenstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/clawio/entities"
+)
+
+// DefaultTTL is the lifetime of a refresh token when none is configured.
+const DefaultTTL = 30 * 24 * time.Hour
+
+// DefaultMaxLifetime is the lifetime of a token family when none is
+// configured. Once it is over the user must sign in again, so the
+// controller verifies again that the user exists and can sign in.
+const DefaultMaxLifetime = 90 * 24 * time.Hour
+
+var (
+	// ErrInvalidToken is returned when a refresh token
+	// does not exist, has expired or has been revoked.
+	ErrInvalidToken = errors.New("refresh token is invalid")
+	// ErrReusedToken is returned when a refresh token that was already
+	// exchanged is used again. The whole token family is revoked.
+	ErrReusedToken = errors.New("refresh token has already been used")
+	// ErrInvalidScope is returned when a refresh token is exchanged
+	// for a scope that exceeds the granted one. The token is not exchanged.
+	ErrInvalidScope = errors.New("scope exceeds the granted scope")
+)
+
+// Grant is what a refresh token was issued for.
+// ClientID is the client the token is bound to, empty for the tokens issued
+// without a client, which can only be exchanged without a client too,
+// and Scope the space separated scopes granted to it.
+type Grant struct {
+	User     *entities.User
+	ClientID string
+	Scope    string
+}
+
+// RefreshTokenStore defines an interface to issue and exchange
+// long-lived opaque refresh tokens.
+// Refresh tokens rotate on every use: the tokens issued from the same
+// login form a family, and reusing a token that was already exchanged
+// revokes the family, as either the legitimate client or an attacker
+// holds a stolen copy. The grant is the one of the login, the user is not
+// verified again until the family expires, MaxLifetime after the login.
+type RefreshTokenStore interface {
+	// Issue returns a new refresh token for the grant, starting a new family.
+	Issue(grant *Grant) (string, error)
+	// Rotate exchanges a refresh token for the grant it was issued for
+	// and a new refresh token of the same family. Tokens bound to another
+	// client than clientID, or to a client when clientID is empty,
+	// are ErrInvalidToken and are not exchanged, so a client can not
+	// burn the tokens of another one. A scope that is not empty must be
+	// in the granted scope or ErrInvalidScope is returned before the token
+	// is exchanged, so a retry of the client is not taken as a reuse.
+	Rotate(token, clientID, scope string) (*Grant, string, error)
+	// Revoke revokes the family of a refresh token, as on logout. Unknown
+	// tokens and tokens bound to another client than clientID are ignored.
+	// An empty clientID revokes the token whatever its client, for callers
+	// that authenticated the user instead of the client.
+	Revoke(token, clientID string) error
+	// RevokeUser revokes every refresh token issued to the user, as when
+	// the user is disabled, but the family of keepToken if it is a token
+	// of the user. An empty keepToken revokes them all.
+	RevokeUser(username, keepToken string) error
+}
+
+// IsScopeSubset reports whether every scope of the space
+// separated list scope is also in granted.
+func IsScopeSubset(scope, granted string) bool {
+	grantedScopes := map[string]bool{}
+	for _, s := range strings.Fields(granted) {
+		grantedScopes[s] = true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !grantedScopes[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewToken returns a new random opaque token and the hash to store.
+func NewToken() (string, string, error) {
+	token, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	return token, Hash(token), nil
+}
+
+// NewFamily returns a new random family ID.
+func NewFamily() (string, error) {
+	return randomString()
+}
+
+// Hash returns the hash of a token stored instead of the token itself,
+// so a leaked database does not leak usable tokens. Tokens are random so
+// a fast hash is enough.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,26 @@
+package refreshtokenstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) TestNewToken() {
+	token, hash, err := NewToken()
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), Hash(token), hash)
+	require.NotEqual(suite.T(), token, hash)
+
+	other, _, err := NewToken()
+	require.Nil(suite.T(), err)
+	require.NotEqual(suite.T(), token, other)
+}
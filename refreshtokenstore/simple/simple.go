@@ -0,0 +1,211 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/clawio/authentication/refreshtokenstore"
+	"github.com/clawio/entities"
+	_ "github.com/go-sql-driver/mysql" // enable mysql driver
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // enable postgresql driver
+	_ "github.com/mattn/go-sqlite3" // enable sqlite3 driver
+)
+
+// Options holds the configuration
+// parameters used by the store.
+// TTL is the lifetime of the refresh tokens, zero means refreshtokenstore.DefaultTTL.
+// MaxLifetime is the lifetime of their families, zero means refreshtokenstore.DefaultMaxLifetime.
+type Options struct {
+	Driver, DSN string
+	TTL         time.Duration
+	MaxLifetime time.Duration
+}
+
+// New returns a RefreshTokenStore that uses a SQL database.
+// Only the hashes of the tokens are stored.
+func New(opts *Options) (refreshtokenstore.RefreshTokenStore, error) {
+	db, err := gorm.Open(opts.Driver, opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&refreshTokenRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	ttl := refreshtokenstore.DefaultTTL
+	if opts.TTL > 0 {
+		ttl = opts.TTL
+	}
+	maxLifetime := refreshtokenstore.DefaultMaxLifetime
+	if opts.MaxLifetime > 0 {
+		maxLifetime = opts.MaxLifetime
+	}
+	return &store{db: db, ttl: ttl, maxLifetime: maxLifetime, now: time.Now}, nil
+}
+
+type store struct {
+	db          *gorm.DB
+	ttl         time.Duration
+	maxLifetime time.Duration
+	now         func() time.Time
+}
+
+func (s *store) Issue(grant *refreshtokenstore.Grant) (string, error) {
+	family, err := refreshtokenstore.NewFamily()
+	if err != nil {
+		return "", err
+	}
+	// expired tokens can not be exchanged any more
+	// so there is no reason to keep them.
+	err = s.db.Where("expires_at < ?", s.now()).Delete(&refreshTokenRecord{}).Error
+	if err != nil {
+		return "", err
+	}
+	return s.issue(s.db, family, s.now().Add(s.maxLifetime), grant)
+}
+
+func (s *store) Rotate(token, clientID, scope string) (*refreshtokenstore.Grant, string, error) {
+	hash := refreshtokenstore.Hash(token)
+	rec := &refreshTokenRecord{}
+	err := s.db.Where("hash=?", hash).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, "", refreshtokenstore.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if s.now().After(rec.ExpiresAt) {
+		return nil, "", refreshtokenstore.ErrInvalidToken
+	}
+	if rec.ClientID != clientID {
+		return nil, "", refreshtokenstore.ErrInvalidToken
+	}
+	if !refreshtokenstore.IsScopeSubset(scope, rec.Scope) {
+		return nil, "", refreshtokenstore.ErrInvalidScope
+	}
+
+	grant := &refreshtokenstore.Grant{
+		User: &entities.User{
+			Username:    rec.Username,
+			Email:       rec.Email,
+			DisplayName: rec.DisplayName,
+		},
+		ClientID: rec.ClientID,
+		Scope:    rec.Scope,
+	}
+
+	// the token is marked as used and its successor stored in the same
+	// transaction, so a failed insert does not leave the client without
+	// a usable token.
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return nil, "", tx.Error
+	}
+	// the token is marked as used only if it was not, so when the
+	// same token is exchanged concurrently only one request wins.
+	res := tx.Model(&refreshTokenRecord{}).Where("hash=? AND used=?", hash, false).Update("used", true)
+	if res.Error != nil {
+		tx.Rollback()
+		return nil, "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		if err := s.revokeFamily(rec.Family); err != nil {
+			return nil, "", err
+		}
+		return nil, "", refreshtokenstore.ErrReusedToken
+	}
+	newToken, err := s.issue(tx, rec.Family, rec.FamilyExpiresAt, grant)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, "", err
+	}
+	return grant, newToken, nil
+}
+
+func (s *store) Revoke(token, clientID string) error {
+	rec := &refreshTokenRecord{}
+	err := s.db.Where("hash=?", refreshtokenstore.Hash(token)).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if clientID != "" && rec.ClientID != "" && rec.ClientID != clientID {
+		return nil
+	}
+	return s.revokeFamily(rec.Family)
+}
+
+func (s *store) RevokeUser(username, keepToken string) error {
+	db := s.db.Where("username=?", username)
+	if keepToken != "" {
+		rec := &refreshTokenRecord{}
+		err := s.db.Where("hash=? AND username=?", refreshtokenstore.Hash(keepToken), username).First(rec).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if err == nil {
+			db = db.Where("family<>?", rec.Family)
+		}
+	}
+	return db.Delete(&refreshTokenRecord{}).Error
+}
+
+func (s *store) revokeFamily(family string) error {
+	return s.db.Where("family=?", family).Delete(&refreshTokenRecord{}).Error
+}
+
+// issue stores a new token with db, that expires
+// with its family at the latest.
+func (s *store) issue(db *gorm.DB, family string, familyExpiresAt time.Time, grant *refreshtokenstore.Grant) (string, error) {
+	token, hash, err := refreshtokenstore.NewToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := s.now().Add(s.ttl)
+	if expiresAt.After(familyExpiresAt) {
+		expiresAt = familyExpiresAt
+	}
+	rec := &refreshTokenRecord{
+		Hash:            hash,
+		Family:          family,
+		FamilyExpiresAt: familyExpiresAt,
+		Username:        grant.User.Username,
+		Email:           grant.User.Email,
+		DisplayName:     grant.User.DisplayName,
+		ClientID:        grant.ClientID,
+		Scope:           grant.Scope,
+		ExpiresAt:       expiresAt,
+	}
+	if err := db.Create(rec).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+type refreshTokenRecord struct {
+	// Hash is the hash of the token, never the token itself.
+	Hash            string `gorm:"primary_key"`
+	Family          string `gorm:"index"`
+	FamilyExpiresAt time.Time
+
+	// the user the token was issued to, used to sign the new access tokens.
+	Username    string `gorm:"index"`
+	Email       string
+	DisplayName string
+
+	ClientID string
+	Scope    string
+
+	ExpiresAt time.Time
+	Used      bool
+}
+
+func (r refreshTokenRecord) TableName() string {
+	return "refresh_tokens"
+}
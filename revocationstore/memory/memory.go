@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/revocationstore"
+)
+
+// New returns a RevocationStore that keeps revoked tokens in memory.
+// Revocations do not survive restarts nor are shared between instances
+// of the service. This store is for testing purposes.
+func New() revocationstore.RevocationStore {
+	return &store{
+		revoked: map[string]time.Time{},
+		users:   map[string]*userCutoff{},
+		now:     time.Now,
+	}
+}
+
+type userCutoff struct {
+	notBefore, expiresAt time.Time
+}
+
+type store struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	users   map[string]*userCutoff
+	now     func() time.Time
+}
+
+func (s *store) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// expired tokens are rejected anyway so
+	// there is no reason to keep them.
+	now := s.now()
+	for id, exp := range s.revoked {
+		if now.After(exp) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *store) IsRevoked(jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+func (s *store) RevokeUser(username string, notBefore, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for name, cutoff := range s.users {
+		if now.After(cutoff.expiresAt) {
+			delete(s.users, name)
+		}
+	}
+	// a later cutoff revokes the tokens of an earlier one too.
+	if cutoff, ok := s.users[username]; ok && cutoff.notBefore.After(notBefore) {
+		return nil
+	}
+	s.users[username] = &userCutoff{notBefore: notBefore, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *store) UserNotBefore(username string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff, ok := s.users[username]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return cutoff.notBefore, nil
+}
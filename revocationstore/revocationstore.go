@@ -0,0 +1,20 @@
+package revocationstore
+
+import "time"
+
+// RevocationStore defines an interface to keep track of the tokens
+// revoked before they expire, identified by their jti claim.
+type RevocationStore interface {
+	// Revoke revokes the token identified by jti. The revocation only
+	// needs to be kept until expiresAt, when the token expires anyway.
+	Revoke(jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the token identified by jti has been revoked.
+	IsRevoked(jti string) (bool, error)
+	// RevokeUser revokes every token of the user issued before notBefore,
+	// as when the password is changed or the user is disabled. The cutoff
+	// only needs to be kept until expiresAt, when those tokens expire anyway.
+	RevokeUser(username string, notBefore, expiresAt time.Time) error
+	// UserNotBefore returns the time before which the tokens of the user
+	// were revoked, the zero time if they were not.
+	UserNotBefore(username string) (time.Time, error)
+}
@@ -0,0 +1,103 @@
+package simple
+
+import (
+	"time"
+
+	"github.com/clawio/authentication/revocationstore"
+	_ "github.com/go-sql-driver/mysql" // enable mysql driver
+	"github.com/jinzhu/gorm"
+	_ "github.com/lib/pq"           // enable postgresql driver
+	_ "github.com/mattn/go-sqlite3" // enable sqlite3 driver
+)
+
+// Options holds the configuration
+// parameters used by the store.
+type Options struct {
+	Driver, DSN string
+}
+
+// New returns a RevocationStore that uses a SQL database, so revocations
+// are shared by all the instances of the service.
+func New(opts *Options) (revocationstore.RevocationStore, error) {
+	db, err := gorm.Open(opts.Driver, opts.DSN)
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&revokedTokenRecord{}, &revokedUserRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &store{db: db, now: time.Now}, nil
+}
+
+type store struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+func (s *store) Revoke(jti string, expiresAt time.Time) error {
+	// expired tokens are rejected anyway so
+	// there is no reason to keep them.
+	err := s.db.Where("expires_at < ?", s.now()).Delete(&revokedTokenRecord{}).Error
+	if err != nil {
+		return err
+	}
+	return s.db.Save(&revokedTokenRecord{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (s *store) IsRevoked(jti string) (bool, error) {
+	count := 0
+	err := s.db.Model(&revokedTokenRecord{}).Where("jti=?", jti).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *store) RevokeUser(username string, notBefore, expiresAt time.Time) error {
+	err := s.db.Where("expires_at < ?", s.now()).Delete(&revokedUserRecord{}).Error
+	if err != nil {
+		return err
+	}
+	// a later cutoff revokes the tokens of an earlier one too.
+	rec := &revokedUserRecord{}
+	err = s.db.Where("username=?", username).First(rec).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if err == nil && rec.NotBefore.After(notBefore) {
+		return nil
+	}
+	return s.db.Save(&revokedUserRecord{Username: username, NotBefore: notBefore, ExpiresAt: expiresAt}).Error
+}
+
+func (s *store) UserNotBefore(username string) (time.Time, error) {
+	rec := &revokedUserRecord{}
+	err := s.db.Where("username=?", username).First(rec).Error
+	if err == gorm.ErrRecordNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rec.NotBefore, nil
+}
+
+type revokedTokenRecord struct {
+	JTI       string `gorm:"primary_key;column:jti"`
+	ExpiresAt time.Time
+}
+
+func (r revokedTokenRecord) TableName() string {
+	return "revoked_tokens"
+}
+
+type revokedUserRecord struct {
+	Username  string `gorm:"primary_key"`
+	NotBefore time.Time
+	ExpiresAt time.Time
+}
+
+func (r revokedUserRecord) TableName() string {
+	return "revoked_users"
+}
@@ -0,0 +1,105 @@
+package simple
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clawio/authentication/revocationstore"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+	dir             string
+	revocationStore revocationstore.RevocationStore
+	store           *store
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-revocationstore")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+	opts := &Options{
+		Driver: "sqlite3",
+		DSN:    filepath.Join(suite.dir, "revocationstore.db"),
+	}
+	revocationStore, err := New(opts)
+	require.Nil(suite.T(), err)
+	suite.revocationStore = revocationStore
+	suite.store = revocationStore.(*store)
+}
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+func (suite *TestSuite) TestNew_withBadDriver() {
+	_, err := New(&Options{Driver: "thisnotexists", DSN: filepath.Join(suite.dir, "revocationstore.db")})
+	require.NotNil(suite.T(), err)
+}
+
+func (suite *TestSuite) TestRevoke() {
+	revoked, err := suite.revocationStore.IsRevoked("test")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), revoked)
+	err = suite.revocationStore.Revoke("test", time.Now().Add(time.Hour))
+	require.Nil(suite.T(), err)
+	revoked, err = suite.revocationStore.IsRevoked("test")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), revoked)
+}
+func (suite *TestSuite) TestRevoke_twice() {
+	err := suite.revocationStore.Revoke("test", time.Now().Add(time.Hour))
+	require.Nil(suite.T(), err)
+	err = suite.revocationStore.Revoke("test", time.Now().Add(time.Hour))
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevoke_deletesExpiredTokens() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	err := suite.revocationStore.Revoke("expired", now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	now = now.Add(2 * time.Hour)
+	err = suite.revocationStore.Revoke("test", now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	revoked, err := suite.revocationStore.IsRevoked("expired")
+	require.Nil(suite.T(), err)
+	require.False(suite.T(), revoked)
+}
+func (suite *TestSuite) TestRevokeUser() {
+	notBefore, err := suite.revocationStore.UserNotBefore("test")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), notBefore.IsZero())
+	now := time.Now().Truncate(time.Second)
+	err = suite.revocationStore.RevokeUser("test", now, now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	notBefore, err = suite.revocationStore.UserNotBefore("test")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), now.Equal(notBefore))
+	// an earlier cutoff does not undo a later one.
+	err = suite.revocationStore.RevokeUser("test", now.Add(-time.Minute), now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	notBefore, err = suite.revocationStore.UserNotBefore("test")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), now.Equal(notBefore))
+	notBefore, err = suite.revocationStore.UserNotBefore("other")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), notBefore.IsZero())
+}
+func (suite *TestSuite) TestRevokeUser_deletesExpiredCutoffs() {
+	now := time.Now()
+	suite.store.now = func() time.Time { return now }
+	err := suite.revocationStore.RevokeUser("expired", now, now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	now = now.Add(2 * time.Hour)
+	err = suite.revocationStore.RevokeUser("test", now, now.Add(time.Hour))
+	require.Nil(suite.T(), err)
+	notBefore, err := suite.revocationStore.UserNotBefore("expired")
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), notBefore.IsZero())
+}
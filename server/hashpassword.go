@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/clawio/authentication/passwordhasher"
+)
+
+// hashPassword implements the hash-password command.
+// It reads a password from the first line of in and writes its encoded
+// hash to out, ready to be used as a MemoryUsers password or stored
+// in the users table of the simple controller.
+// The password is read from stdin so it does not end up in the shell history.
+func hashPassword(args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	algorithm := flags.String("algorithm", passwordhasher.DefaultAlgorithm, "hashing algorithm: bcrypt, scrypt or argon2id")
+	cost := flags.Int("cost", 0, "algorithm specific cost, 0 uses the algorithm default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	hasher, err := passwordhasher.New(&passwordhasher.Options{Algorithm: *algorithm, Cost: *cost})
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("no password provided on stdin")
+	}
+	password := scanner.Text()
+	if password == "" {
+		return errors.New("password is empty")
+	}
+
+	encoded, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, encoded)
+	return err
+}
+
+func runHashPassword(args []string) {
+	if err := hashPassword(args, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "hash-password:", err)
+		os.Exit(1)
+	}
+}
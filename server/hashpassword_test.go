@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clawio/authentication/passwordhasher"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TestSuite struct {
+	suite.Suite
+}
+
+func Test(t *testing.T) {
+	suite.Run(t, new(TestSuite))
+}
+func (suite *TestSuite) TestHashPassword() {
+	for _, args := range [][]string{
+		{"-cost", "4"},
+		{"-algorithm", "scrypt", "-cost", "4"},
+		{"-algorithm", "argon2id", "-cost", "1"},
+	} {
+		out := &bytes.Buffer{}
+		err := hashPassword(args, strings.NewReader("secret\nignored\n"), out)
+		require.Nil(suite.T(), err, args)
+		encoded := strings.TrimSuffix(out.String(), "\n")
+		require.NotContains(suite.T(), encoded, "\n")
+		ok, err := passwordhasher.Verify(encoded, "secret")
+		require.Nil(suite.T(), err, args)
+		require.True(suite.T(), ok, args)
+	}
+}
+func (suite *TestSuite) TestHashPassword_withBadInput() {
+	for _, v := range []struct {
+		args []string
+		in   string
+	}{
+		{[]string{"-cost", "4"}, ""},
+		{[]string{"-cost", "4"}, "\nsecret\n"},
+		{[]string{"-algorithm", "md5"}, "secret\n"},
+		{[]string{"-algorithm", "bcrypt", "-cost", "99"}, "secret\n"},
+		{[]string{"-cost", "x"}, "secret\n"},
+	} {
+		out := &bytes.Buffer{}
+		err := hashPassword(v.args, strings.NewReader(v.in), out)
+		require.NotNil(suite.T(), err, v.args)
+		require.Empty(suite.T(), out.String(), v.args)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"flag"
+	"log"
+
 	"github.com/NYTimes/gizmo/config"
 	"github.com/NYTimes/gizmo/server"
 	"github.com/clawio/authentication/service"
@@ -9,10 +11,19 @@ import (
 
 func main() {
 	flag.Parse()
+	if flag.Arg(0) == "hash-password" {
+		runHashPassword(flag.Args()[1:])
+		return
+	}
+
 	var cfg *service.Config
 	config.LoadJSONFile(*config.ConfigLocationCLI, &cfg)
 
 	server.Init("authentication-service", cfg.Server)
+	// controllers log with the standard logger.
+	log.SetFlags(0)
+	log.SetOutput(server.Log.Writer())
+
 	svc, err := service.New(cfg)
 	if err != nil {
 		server.Log.Fatal("unable to create service: ", err)
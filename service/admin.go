@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/NYTimes/gizmo/web"
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/codes"
+	"github.com/clawio/entities"
+)
+
+const (
+	// rolesClaim is the claim of the access tokens with
+	// the roles of the user, absent when there is none.
+	rolesClaim = "roles"
+
+	// adminRole is the role of the users of GeneralConfig.AdminUsers.
+	adminRole = "admin"
+)
+
+const (
+	// defaultUsersLimit is the number of users listed when no limit is given.
+	defaultUsersLimit = 50
+	// maxUsersLimit is the maximum number of users listed at once.
+	maxUsersLimit = 500
+)
+
+type (
+	// AdminUserRequest specifies the data received by the CreateUser and
+	// UpdateUser endpoints. The username is only read by CreateUser and
+	// the fields that are not sent are left untouched by UpdateUser.
+	AdminUserRequest struct {
+		Username    string  `json:"username"`
+		Email       *string `json:"email"`
+		DisplayName *string `json:"display_name"`
+		Password    *string `json:"password"`
+		Disabled    *bool   `json:"disabled"`
+	}
+
+	// ListUsersResponse is a page of the users returned by the ListUsers
+	// endpoint and Total the number of users matching the filter.
+	ListUsersResponse struct {
+		Users  []*authenticationcontroller.StoredUser `json:"users"`
+		Total  int                                    `json:"total"`
+		Offset int                                    `json:"offset"`
+		Limit  int                                    `json:"limit"`
+	}
+)
+
+// adminHandlerFunc only lets through the requests with an access token
+// of an user with the admin role. The user management endpoints do not
+// exist when the AuthenticationController can not manage its users.
+func (s *Service) adminHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return s.Authenticator.JWTHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := s.Authenticator.Claims(s.Authenticator.TokenFromRequest(r))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if !hasRole(claims, adminRole) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if s.UserStore == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// ListUsers lists the users ordered by username. The q parameter matches a
+// part of the username, email or display name, disabled=true or false the
+// users in that state, and offset and limit select the page.
+func (s *Service) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &authenticationcontroller.UserFilter{Query: query.Get("q"), Limit: defaultUsersLimit}
+	var err error
+	if v := query.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			writeBadInput(w, http.StatusBadRequest, "disabled must be true or false")
+			return
+		}
+		filter.Disabled = &disabled
+	}
+	if v := query.Get("offset"); v != "" {
+		filter.Offset, err = strconv.Atoi(v)
+		if err != nil || filter.Offset < 0 {
+			writeBadInput(w, http.StatusBadRequest, "offset must be a positive number")
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxUsersLimit {
+			writeBadInput(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxUsersLimit))
+			return
+		}
+	}
+
+	users, total, err := s.UserStore.ListUsers(r.Context(), filter)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, &ListUsersResponse{
+		Users:  users,
+		Total:  total,
+		Offset: filter.Offset,
+		Limit:  filter.Limit,
+	})
+}
+
+// CreateUser creates an user, without a password if none is given.
+func (s *Service) CreateUser(w http.ResponseWriter, r *http.Request) {
+	req := &AdminUserRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeBadInput(w, http.StatusBadRequest, "")
+		return
+	}
+	if req.Username == "" {
+		writeBadInput(w, http.StatusBadRequest, "username is missing")
+		return
+	}
+	user := &authenticationcontroller.StoredUser{User: &entities.User{Username: req.Username}}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+	if req.Disabled != nil {
+		user.Disabled = *req.Disabled
+	}
+	var password string
+	if req.Password != nil {
+		password = *req.Password
+		if err := s.checkPassword(req.Username, password); err != nil {
+			writeBadInput(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	err := s.UserStore.CreateUser(r.Context(), user, password)
+	if err == authenticationcontroller.ErrUserExists {
+		writeBadInput(w, http.StatusConflict, "user already exists")
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// GetUser returns the user of the path.
+func (s *Service) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := s.UserStore.GetUser(r.Context(), web.Vars(r)["username"])
+	if err != nil {
+		s.handleUserStoreError(err, w)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// UpdateUser changes the fields of the request. The tokens of the user
+// are revoked when the user is disabled or the password is changed.
+func (s *Service) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	req := &AdminUserRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeBadInput(w, http.StatusBadRequest, "")
+		return
+	}
+	username := web.Vars(r)["username"]
+	if req.Password != nil {
+		if err := s.checkPassword(username, *req.Password); err != nil {
+			writeBadInput(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	user, err := s.UserStore.UpdateUser(r.Context(), username, &authenticationcontroller.UserUpdate{
+		Email:       req.Email,
+		DisplayName: req.DisplayName,
+		Password:    req.Password,
+		Disabled:    req.Disabled,
+	})
+	if err != nil {
+		s.handleUserStoreError(err, w)
+		return
+	}
+	if (req.Disabled != nil && *req.Disabled) || req.Password != nil {
+		if err := s.revokeUserSessions(username, ""); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// DeleteUser deletes the user of the path and revokes its tokens.
+func (s *Service) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := web.Vars(r)["username"]
+	if err := s.UserStore.DeleteUser(r.Context(), username); err != nil {
+		s.handleUserStoreError(err, w)
+		return
+	}
+	if err := s.revokeUserSessions(username, ""); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserRefreshTokens revokes the refresh tokens of
+// the user but the family of keepToken, if not empty.
+func (s *Service) revokeUserRefreshTokens(username, keepToken string) error {
+	if s.RefreshTokenStore == nil {
+		return nil
+	}
+	return s.RefreshTokenStore.RevokeUser(username, keepToken)
+}
+
+func (s *Service) handleUserStoreError(err error, w http.ResponseWriter) {
+	if err == authenticationcontroller.ErrUserNotFound {
+		e := codes.NewErr(codes.NotFound, "user not found")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// hasRole reports whether the roles claim holds the role.
+func hasRole(claims map[string]interface{}, role string) bool {
+	roles, _ := claims[rolesClaim].([]interface{})
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func writeBadInput(w http.ResponseWriter, status int, message string) {
+	e := codes.NewErr(codes.BadInputData, message)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/authenticationcontroller/simple"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/refreshtokenstore"
+	refreshtokenstorememory "github.com/clawio/authentication/refreshtokenstore/memory"
+	revocationstorememory "github.com/clawio/authentication/revocationstore/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+// setupUserStore enables the user management endpoints
+// and returns an access token of an admin.
+func (suite *TestSuite) setupUserStore() string {
+	c, err := simple.New(&simple.Options{Driver: "sqlite3", DSN: filepath.Join(suite.dir, "adminusers.db")})
+	require.Nil(suite.T(), err)
+	suite.Service.UserStore = c.(authenticationcontroller.UserStore)
+	suite.Service.Config.General.AdminUsers = []string{"admin"}
+	return suite.userToken("admin")
+}
+func (suite *TestSuite) userToken(username string) string {
+	user := &entities.User{Username: username}
+	token, err := suite.Service.Authenticator.CreateTokenWithClaims(user, suite.Service.userClaims(user, ""))
+	require.Nil(suite.T(), err)
+	return token
+}
+
+func (suite *TestSuite) TestAdmin_withoutAdminRole() {
+	suite.setupUserStore()
+	w := suite.admin("GET", "/admin/users", suite.userToken("jdoe"), "")
+	require.Equal(suite.T(), http.StatusForbidden, w.Code)
+	w = suite.admin("GET", "/admin/users", "", "")
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestAdmin_withoutUserStore() {
+	suite.Service.Config.General.AdminUsers = []string{"admin"}
+	w := suite.admin("GET", "/admin/users", suite.userToken("admin"), "")
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestCreateUser() {
+	token := suite.setupUserStore()
+	w := suite.admin("POST", "/admin/users", token, `{"username": "jdoe", "password": "short"}`)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	w = suite.admin("POST", "/admin/users", token, `{"username": "jdoe", "email": "jdoe@example.org", "password": "correct horse"}`)
+	require.Equal(suite.T(), http.StatusCreated, w.Code)
+	user := &authenticationcontroller.StoredUser{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(user))
+	require.Equal(suite.T(), "jdoe@example.org", user.Email)
+	_, err := suite.Service.UserStore.(authenticationcontroller.AuthenticationController).Authenticate(context.Background(), "jdoe", "correct horse")
+	require.Nil(suite.T(), err)
+
+	w = suite.admin("POST", "/admin/users", token, `{"username": "jdoe"}`)
+	require.Equal(suite.T(), http.StatusConflict, w.Code)
+	w = suite.admin("POST", "/admin/users", token, `{"email": "jdoe@example.org"}`)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestListUsers() {
+	token := suite.setupUserStore()
+	for _, body := range []string{
+		`{"username": "adoe", "display_name": "Alice Doe"}`,
+		`{"username": "bdoe", "display_name": "Bob Doe", "disabled": true}`,
+		`{"username": "croe", "display_name": "Carol Roe"}`,
+	} {
+		w := suite.admin("POST", "/admin/users", token, body)
+		require.Equal(suite.T(), http.StatusCreated, w.Code)
+	}
+	list := func(query string) *ListUsersResponse {
+		w := suite.admin("GET", "/admin/users?"+query, token, "")
+		require.Equal(suite.T(), http.StatusOK, w.Code)
+		res := &ListUsersResponse{}
+		require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+		return res
+	}
+	res := list("")
+	require.Len(suite.T(), res.Users, 3)
+	require.Equal(suite.T(), defaultUsersLimit, res.Limit)
+
+	res = list("q=doe&offset=1&limit=1")
+	require.Equal(suite.T(), 2, res.Total)
+	require.Len(suite.T(), res.Users, 1)
+	require.Equal(suite.T(), "bdoe", res.Users[0].Username)
+
+	res = list("disabled=false")
+	require.Equal(suite.T(), 2, res.Total)
+
+	for _, query := range []string{"disabled=maybe", "offset=-1", "limit=0", "limit=501"} {
+		w := suite.admin("GET", "/admin/users?"+query, token, "")
+		require.Equal(suite.T(), http.StatusBadRequest, w.Code, query)
+	}
+}
+func (suite *TestSuite) TestGetUser() {
+	token := suite.setupUserStore()
+	w := suite.admin("POST", "/admin/users", token, `{"username": "jdoe", "email": "jdoe@example.org"}`)
+	require.Equal(suite.T(), http.StatusCreated, w.Code)
+	w = suite.admin("GET", "/admin/users/jdoe", token, "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	user := &authenticationcontroller.StoredUser{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(user))
+	require.Equal(suite.T(), "jdoe@example.org", user.Email)
+
+	w = suite.admin("GET", "/admin/users/notfound", token, "")
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestUpdateUser() {
+	token := suite.setupUserStore()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	w := suite.admin("POST", "/admin/users", token, `{"username": "jdoe", "email": "jdoe@example.org", "password": "correct horse"}`)
+	require.Equal(suite.T(), http.StatusCreated, w.Code)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{User: &entities.User{Username: "jdoe"}})
+	require.Nil(suite.T(), err)
+
+	// changing the profile keeps the sessions.
+	w = suite.admin("PATCH", "/admin/users/jdoe", token, `{"display_name": "Jane Doe"}`)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	user := &authenticationcontroller.StoredUser{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(user))
+	require.Equal(suite.T(), "Jane Doe", user.DisplayName)
+	require.Equal(suite.T(), "jdoe@example.org", user.Email)
+	_, refreshToken, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "", "")
+	require.Nil(suite.T(), err)
+
+	// disabling the user ends them.
+	w = suite.admin("PATCH", "/admin/users/jdoe", token, `{"disabled": true}`)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	_, err = suite.Service.UserStore.(authenticationcontroller.AuthenticationController).Authenticate(context.Background(), "jdoe", "correct horse")
+	require.Equal(suite.T(), authenticationcontroller.ErrUserDisabled, err)
+
+	w = suite.admin("PATCH", "/admin/users/jdoe", token, `{"password": ""}`)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	w = suite.admin("PATCH", "/admin/users/notfound", token, `{"disabled": true}`)
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestDeleteUser() {
+	token := suite.setupUserStore()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	w := suite.admin("POST", "/admin/users", token, `{"username": "jdoe"}`)
+	require.Equal(suite.T(), http.StatusCreated, w.Code)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{User: &entities.User{Username: "jdoe"}})
+	require.Nil(suite.T(), err)
+
+	w = suite.admin("DELETE", "/admin/users/jdoe", token, "")
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+	w = suite.admin("DELETE", "/admin/users/jdoe", token, "")
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestUpdateUser_revokesAccessTokens() {
+	token := suite.setupUserStore()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	now := time.Now()
+	suite.Service.Authenticator.Now = func() time.Time { return now }
+	for _, username := range []string{"jdoe", "jroe"} {
+		w := suite.admin("POST", "/admin/users", token, `{"username": "`+username+`"}`)
+		require.Equal(suite.T(), http.StatusCreated, w.Code)
+	}
+	disabled := suite.userToken("jdoe")
+	deleted := suite.userToken("jroe")
+
+	now = now.Add(time.Minute)
+	token = suite.userToken("admin")
+	w := suite.admin("PATCH", "/admin/users/jdoe", token, `{"disabled": true}`)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	w = suite.admin("DELETE", "/admin/users/jroe", token, "")
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	for _, accessToken := range []string{disabled, deleted} {
+		_, err := suite.Service.Authenticator.CreateUserFromToken(accessToken)
+		require.Equal(suite.T(), lib.ErrTokenRevoked, err)
+	}
+}
+func (suite *TestSuite) TestUserClaims_withAdmin() {
+	suite.Service.Config.General.AdminUsers = []string{"admin"}
+	claims := suite.Service.userClaims(&entities.User{Username: "admin"}, "read")
+	require.Equal(suite.T(), []string{adminRole}, claims[rolesClaim])
+	require.Equal(suite.T(), "read", claims["scope"])
+	require.Nil(suite.T(), suite.Service.userClaims(&entities.User{Username: "jdoe"}, ""))
+}
+
+func (suite *TestSuite) admin(method, urlPath, token, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(method, path.Join(suite.Service.Config.General.BaseURL, urlPath), strings.NewReader(body))
+	require.Nil(suite.T(), err)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
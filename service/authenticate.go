@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/clawio/entities"
+)
+
+// errFactorsRequired is returned when the controller requires
+// additional factors, which no endpoint can collect yet.
+var errFactorsRequired = errors.New("additional authentication factors are required")
+
+// authenticateUser verifies the credentials with the
+// AuthenticationController and returns the user to issue tokens for.
+func (s *Service) authenticateUser(ctx context.Context, username, password string) (*entities.User, error) {
+	res, err := s.AuthenticationController.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Factors) > 0 {
+		return nil, errFactorsRequired
+	}
+	return res.User, nil
+}
+
+// loginError returns the message shown by the login forms.
+func loginError(err error) string {
+	if err == errFactorsRequired {
+		return "Additional authentication factors are required."
+	}
+	return "User or password do not match."
+}
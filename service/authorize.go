@@ -0,0 +1,251 @@
+package service
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/entities"
+)
+
+// authorizeRequest holds the parameters of an authorization request (RFC 6749 section 4.1.1).
+// They are kept in hidden fields of the login page, so they are sent again
+// with the credentials and validated again.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// values returns the parameters of the request, the inverse of newAuthorizeRequest.
+func (req *authorizeRequest) values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+		"nonce":                 req.Nonce,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values
+}
+
+func newAuthorizeRequest(values url.Values) *authorizeRequest {
+	return &authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+// loginPage is the data of the login page template.
+type loginPage struct {
+	Client   *clientregistry.Client
+	Request  *authorizeRequest
+	Username string
+	Error    string
+
+	// Providers are the upstream identity providers
+	// the user can sign in with instead.
+	Providers []*providerLink
+}
+
+// providerLink is a link of the login page to sign in with an upstream provider.
+type providerLink struct {
+	Name string
+	URL  string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+<h1>Sign in{{if .Client}} to {{if .Client.Name}}{{.Client.Name}}{{else}}{{.Client.ID}}{{end}}{{end}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Client}}<form method="post">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<p><label>Username <input name="username" value="{{.Username}}" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+{{range .Providers}}<p><a href="{{.URL}}">Sign in with {{.Name}}</a></p>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// Authorize is the authorization endpoint of the authorization code flow.
+// GET renders a login page for a valid authorization request. POST authenticates
+// the user with the AuthenticationController and redirects to the client with
+// a short-lived single-use code, redeemed on the Token endpoint.
+// Only the code response type with S256 PKCE is supported, and the redirect_uri
+// must be one of the URIs registered for the client.
+// Requests with the openid scope get an ID token with the code, bound
+// to the nonce parameter.
+func (s *Service) Authorize(w http.ResponseWriter, r *http.Request) {
+	if s.AuthorizationCodeStore == nil || s.ClientRegistry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The request is not valid."})
+		return
+	}
+	values := r.Form
+	if r.Method == "POST" {
+		values = r.PostForm
+	}
+	req := newAuthorizeRequest(values)
+
+	// errors about the client or the redirect_uri are never
+	// redirected, as the redirect_uri can not be trusted.
+	client, redirectURI, err := s.checkRedirectURI(req)
+	if err != nil {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The application is not registered or its redirect URI is not allowed."})
+		return
+	}
+	if oErr := s.checkAuthorizeRequest(req, client); oErr != nil {
+		redirect(w, r, redirectURI, url.Values{
+			"error":             {oErr.Code},
+			"error_description": {oErr.Description},
+			"state":             {req.State},
+		})
+		return
+	}
+
+	page := &loginPage{Client: client, Request: req}
+	if r.Method != "POST" {
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	page.Username = values.Get("username")
+	user, err := s.authenticateUser(r.Context(), page.Username, values.Get("password"))
+	if err != nil {
+		page.Error = loginError(err)
+		s.renderLogin(w, http.StatusUnauthorized, page)
+		return
+	}
+	s.issueAuthorizationCode(w, r, user, client, redirectURI, req)
+}
+
+// issueAuthorizationCode redirects the authenticated user
+// to the client with a code for the authorization request.
+func (s *Service) issueAuthorizationCode(w http.ResponseWriter, r *http.Request, user *entities.User, client *clientregistry.Client, redirectURI string, req *authorizeRequest) {
+	code, err := s.AuthorizationCodeStore.Issue(&authorizationcodestore.AuthorizationCode{
+		User:          user,
+		ClientID:      client.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      time.Now(),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	redirect(w, r, redirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+// checkRedirectURI returns the client of the request and the URI to redirect to.
+// The redirect_uri must match one of the registered URIs exactly, and can only
+// be omitted if the client has a single registered URI.
+func (s *Service) checkRedirectURI(req *authorizeRequest) (*clientregistry.Client, string, error) {
+	client, err := s.ClientRegistry.FindByID(req.ClientID)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.RedirectURI == "" {
+		if len(client.RedirectURIs) != 1 {
+			return nil, "", clientregistry.ErrInvalidClient
+		}
+		return client, client.RedirectURIs[0], nil
+	}
+	for _, uri := range client.RedirectURIs {
+		if uri == req.RedirectURI {
+			return client, uri, nil
+		}
+	}
+	return nil, "", clientregistry.ErrInvalidClient
+}
+
+// checkAuthorizeRequest checks the parameters of an authorization request
+// of the client. The scope can not exceed the scope registered for it.
+func (s *Service) checkAuthorizeRequest(req *authorizeRequest, client *clientregistry.Client) *OAuthError {
+	if req.ResponseType != "code" {
+		return newOAuthError(http.StatusBadRequest, "unsupported_response_type", "response_type must be code")
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "PKCE with the S256 method is required")
+	}
+	return s.checkClientScope(req.Scope, client.ID)
+}
+
+// redirect redirects to uri adding the parameters to its query.
+// Empty parameters are skipped.
+func redirect(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	query := u.Query()
+	for name, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(name, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (s *Service) renderLogin(w http.ResponseWriter, code int, page *loginPage) {
+	if s.Federation != nil && page.Client != nil {
+		page.Providers = federationLinks(s.Federation.Providers(), page.Request)
+	}
+	s.renderPage(w, code, loginTemplate, page)
+}
+
+// renderPage renders the HTML pages where users type their credentials.
+func (s *Service) renderPage(w http.ResponseWriter, code int, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the login page must not be framed by other sites.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(code)
+	t.Execute(w, data)
+}
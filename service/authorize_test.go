@@ -0,0 +1,245 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/authorizationcodestore"
+	authorizationcodestorememory "github.com/clawio/authentication/authorizationcodestore/memory"
+	"github.com/clawio/authentication/clientregistry"
+	clientregistrymemory "github.com/clawio/authentication/clientregistry/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	redirectURI  = "http://localhost:8080/callback"
+	codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func (suite *TestSuite) setupAuthorizationCodeFlow() {
+	clients := []*clientregistry.Client{
+		{ID: "desktop", Name: "Desktop", RedirectURIs: []string{redirectURI}, Scope: "openid profile email read"},
+		{ID: "web", Secret: clientSecretHash, RedirectURIs: []string{redirectURI, "https://web/callback"}, Scope: "openid read"},
+	}
+	clientRegistry, err := clientregistrymemory.New(&clientregistrymemory.Options{Clients: clients})
+	require.Nil(suite.T(), err)
+	suite.Service.ClientRegistry = clientRegistry
+	suite.Service.AuthorizationCodeStore = authorizationcodestorememory.New(nil)
+}
+
+func authorizeParams(clientID string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {authorizationcodestore.S256Challenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func (suite *TestSuite) TestAuthorize() {
+	suite.setupAuthorizationCodeFlow()
+	w := suite.authorize("GET", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), "DENY", w.Header().Get("X-Frame-Options"))
+	require.Contains(suite.T(), w.Body.String(), "Sign in to Desktop")
+	require.Contains(suite.T(), w.Body.String(), `name="password"`)
+}
+func (suite *TestSuite) TestAuthorize_withoutAuthorizationCodeStore() {
+	w := suite.authorize("GET", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestAuthorize_withUnknownClient() {
+	suite.setupAuthorizationCodeFlow()
+	w := suite.authorize("GET", authorizeParams("unknown"))
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	require.NotContains(suite.T(), w.Body.String(), "<form")
+}
+func (suite *TestSuite) TestAuthorize_withBadRedirectURI() {
+	suite.setupAuthorizationCodeFlow()
+	params := authorizeParams("desktop")
+	params.Set("redirect_uri", "http://evil/callback")
+	w := suite.authorize("GET", params)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	require.Empty(suite.T(), w.Header().Get("Location"))
+}
+func (suite *TestSuite) TestAuthorize_withoutRedirectURI() {
+	suite.setupAuthorizationCodeFlow()
+	params := authorizeParams("desktop")
+	params.Del("redirect_uri")
+	w := suite.authorize("GET", params)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+
+	// the uri can only be omitted if the client has a single one.
+	params = authorizeParams("web")
+	params.Del("redirect_uri")
+	w = suite.authorize("GET", params)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestAuthorize_withoutPKCE() {
+	suite.setupAuthorizationCodeFlow()
+	params := authorizeParams("desktop")
+	params.Set("code_challenge_method", "plain")
+	w := suite.authorize("GET", params)
+	location := suite.requireRedirect(w)
+	require.Equal(suite.T(), "invalid_request", location.Query().Get("error"))
+	require.Equal(suite.T(), "xyz", location.Query().Get("state"))
+}
+func (suite *TestSuite) TestAuthorize_withBadResponseType() {
+	suite.setupAuthorizationCodeFlow()
+	params := authorizeParams("desktop")
+	params.Set("response_type", "token")
+	w := suite.authorize("GET", params)
+	location := suite.requireRedirect(w)
+	require.Equal(suite.T(), "unsupported_response_type", location.Query().Get("error"))
+}
+func (suite *TestSuite) TestAuthorize_withExceedingScope() {
+	suite.setupAuthorizationCodeFlow()
+	params := authorizeParams("desktop")
+	params.Set("scope", "read admin")
+	w := suite.authorize("GET", params)
+	location := suite.requireRedirect(w)
+	require.Equal(suite.T(), "invalid_scope", location.Query().Get("error"))
+	require.Equal(suite.T(), "xyz", location.Query().Get("state"))
+}
+func (suite *TestSuite) TestAuthorize_withBadCredentials() {
+	suite.setupAuthorizationCodeFlow()
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(nil, errors.New("test error"))
+	params := authorizeParams("desktop")
+	params.Set("username", "test")
+	params.Set("password", "bad")
+	w := suite.authorize("POST", params)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "User or password do not match")
+	require.Contains(suite.T(), w.Body.String(), `value="test"`)
+}
+func (suite *TestSuite) TestAuthorize_withPublicClient() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("desktop")
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Equal(suite.T(), "read", authNRes.Scope)
+	user, err := suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+
+	// codes can only be used once.
+	w = suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+func (suite *TestSuite) TestAuthorize_withConfidentialClient() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("web")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "web", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+}
+func (suite *TestSuite) TestAuthorize_withUnauthenticatedConfidentialClient() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("web")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"web"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+}
+func (suite *TestSuite) TestAuthorize_withBadCodeVerifier() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("desktop")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {strings.Repeat("a", 43)},
+	}
+	w := suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+func (suite *TestSuite) TestAuthorize_withOtherClient() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("web")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+func (suite *TestSuite) TestAuthorize_withOtherRedirectURI() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("web")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://web/callback"},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "web", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+
+// authorizationCode signs in the user test and returns the code.
+func (suite *TestSuite) authorizationCode(clientID string) string {
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	params := authorizeParams(clientID)
+	params.Set("username", "test")
+	params.Set("password", "test")
+	w := suite.authorize("POST", params)
+	location := suite.requireRedirect(w)
+	require.Equal(suite.T(), "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(suite.T(), code)
+	return code
+}
+func (suite *TestSuite) authorize(method string, params url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	var err error
+	if method == "GET" {
+		r, err = http.NewRequest("GET", authorizeURL+"?"+params.Encode(), nil)
+	} else {
+		r, err = http.NewRequest("POST", authorizeURL, strings.NewReader(params.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+func (suite *TestSuite) requireRedirect(w *httptest.ResponseRecorder) *url.URL {
+	require.Equal(suite.T(), http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.Nil(suite.T(), err)
+	require.True(suite.T(), strings.HasPrefix(location.String(), redirectURI))
+	return location
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/clawio/authentication/clientregistry"
+	clientregistrymemory "github.com/clawio/authentication/clientregistry/memory"
+	clientregistrysimple "github.com/clawio/authentication/clientregistry/simple"
+)
+
+func getClientRegistry(cfg *Config) (clientregistry.ClientRegistry, error) {
+	switch cfg.ClientRegistry.Type {
+	case "simple":
+		opts := &clientregistrysimple.Options{
+			Driver: cfg.ClientRegistry.SimpleDriver,
+			DSN:    cfg.ClientRegistry.SimpleDSN,
+		}
+		return clientregistrysimple.New(opts)
+	case "memory":
+		opts := &clientregistrymemory.Options{Clients: cfg.ClientRegistry.MemoryClients}
+		return clientregistrymemory.New(opts)
+	default:
+		return nil, errors.New("clientRegistry type " + cfg.ClientRegistry.Type + " does not exist")
+	}
+}
+
+// authenticateClient authenticates the client of a request with HTTP Basic
+// authentication or, as allowed by RFC 6749, with the client_id and
+// client_secret form parameters. The form must be already parsed.
+func (s *Service) authenticateClient(r *http.Request) (*clientregistry.Client, error) {
+	if s.ClientRegistry == nil {
+		return nil, clientregistry.ErrInvalidClient
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 requires the credentials to be form encoded
+		// before they are sent with HTTP Basic authentication.
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return nil, clientregistry.ErrInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return nil, clientregistry.ErrInvalidClient
+		}
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if id == "" {
+		return nil, clientregistry.ErrInvalidClient
+	}
+	return s.ClientRegistry.Authenticate(id, secret)
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/authentication/devicecodestore"
+)
+
+// DeviceAuthorizationResponse specifies the data returned
+// from the DeviceAuthorize endpoint (RFC 8628 section 3.2).
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceAuthorize is the device authorization endpoint of the device
+// authorization grant, for clients without a browser. It returns a device
+// code, polled by the client on the Token endpoint, and a user code the user
+// enters on the Device page to approve the client.
+func (s *Service) DeviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	if s.DeviceCodeStore == nil || s.ClientRegistry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "body is not form encoded"))
+		return
+	}
+	clientID, oErr := s.tokenClientID(r, &AuthenticateRequest{ClientID: r.PostForm.Get("client_id")})
+	if oErr != nil {
+		writeOAuthError(w, oErr)
+		return
+	}
+	if clientID == "" {
+		writeOAuthError(w, newOAuthError(http.StatusUnauthorized, "invalid_client", "client_id is required"))
+		return
+	}
+	scope := r.PostForm.Get("scope")
+	if oErr := s.checkClientScope(scope, clientID); oErr != nil {
+		writeOAuthError(w, oErr)
+		return
+	}
+	codes, err := s.DeviceCodeStore.Issue(&devicecodestore.DeviceAuthorization{
+		ClientID: clientID,
+		Scope:    scope,
+	})
+	if err != nil {
+		writeOAuthError(w, newServerError())
+		return
+	}
+
+	verificationURI := s.verificationURI()
+	res := &DeviceAuthorizationResponse{
+		DeviceCode:              codes.DeviceCode,
+		UserCode:                codes.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {codes.UserCode}}.Encode(),
+		ExpiresIn:               int64(codes.ExpiresIn / time.Second),
+		Interval:                int64(codes.Interval / time.Second),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// verificationURI returns the address of the Device page,
+// the configured one or the one under the issuer.
+func (s *Service) verificationURI() string {
+	if s.Config.DeviceCodeStore != nil && s.Config.DeviceCodeStore.VerificationURI != "" {
+		return s.Config.DeviceCodeStore.VerificationURI
+	}
+	return s.issuer() + "/device"
+}
+
+// devicePage is the data of the device page template.
+type devicePage struct {
+	Client   *clientregistry.Client
+	Scope    string
+	UserCode string
+	Username string
+	Error    string
+	Message  string
+}
+
+var deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device</title>
+</head>
+<body>
+<h1>Connect {{if .Client}}{{if .Client.Name}}{{.Client.Name}}{{else}}{{.Client.ID}}{{end}}{{else}}a device{{end}}</h1>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+{{if .Message}}<p>{{.Message}}</p>{{else}}{{if .Scope}}<p>The device asks for access to: {{.Scope}}</p>{{end}}
+<form method="post">
+<p><label>Code shown on the device <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+<p><label>Username <input name="username" value="{{.Username}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny">Deny</button></p>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// Device is the verification page of the device authorization grant.
+// GET renders the page, with the user code of the verification_uri_complete
+// filled in. POST approves or denies the authorization of the user code
+// once the user is authenticated with the AuthenticationController. The
+// user codes that can be tried from an address are limited, so that the
+// short codes can not be guessed.
+func (s *Service) Device(w http.ResponseWriter, r *http.Request) {
+	if s.DeviceCodeStore == nil || s.ClientRegistry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.renderPage(w, http.StatusBadRequest, deviceTemplate, &devicePage{Error: "The request is not valid."})
+		return
+	}
+	values := r.Form
+	if r.Method == "POST" {
+		values = r.PostForm
+	}
+	page := &devicePage{UserCode: values.Get("user_code"), Username: values.Get("username")}
+	if page.UserCode == "" {
+		s.renderPage(w, http.StatusOK, deviceTemplate, page)
+		return
+	}
+
+	addr := remoteAddr(r)
+	if !s.userCodeAttempts.allow(addr) {
+		page.Error = "Too many codes were tried, try again later."
+		s.renderPage(w, http.StatusTooManyRequests, deviceTemplate, page)
+		return
+	}
+	authorization, err := s.DeviceCodeStore.Find(page.UserCode)
+	if err == devicecodestore.ErrInvalidCode {
+		s.userCodeAttempts.fail(addr)
+		page.Error = "The code is not valid or has expired."
+		s.renderPage(w, http.StatusBadRequest, deviceTemplate, page)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	page.Client, err = s.ClientRegistry.FindByID(authorization.ClientID)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	page.Scope = authorization.Scope
+	if r.Method != "POST" {
+		s.renderPage(w, http.StatusOK, deviceTemplate, page)
+		return
+	}
+
+	// only the user can decide, a code seen over their shoulder must not be denied by others.
+	user, authErr := s.authenticateUser(r.Context(), page.Username, values.Get("password"))
+	if authErr != nil {
+		page.Error = loginError(authErr)
+		s.renderPage(w, http.StatusUnauthorized, deviceTemplate, page)
+		return
+	}
+	if values.Get("action") == "deny" {
+		err = s.DeviceCodeStore.Deny(page.UserCode)
+		page.Message = "The device has not been connected."
+	} else {
+		err = s.DeviceCodeStore.Approve(page.UserCode, user)
+		page.Message = "The device is connected, you can go back to it."
+	}
+	// the code may have been decided or expired since it was found.
+	if err == devicecodestore.ErrInvalidCode {
+		page.Message = ""
+		page.Error = "The code is not valid or has expired."
+		s.renderPage(w, http.StatusBadRequest, deviceTemplate, page)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	s.renderPage(w, http.StatusOK, deviceTemplate, page)
+}
+
+const (
+	// maxUserCodeAttempts is the number of unknown user codes
+	// an address can try during userCodeAttemptsWindow.
+	maxUserCodeAttempts    = 10
+	userCodeAttemptsWindow = 10 * time.Minute
+)
+
+// attemptLimiter counts the failed attempts of the addresses
+// and refuses them once they reach maxUserCodeAttempts.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+// attempts are the failed attempts of an address since the first one.
+type attempts struct {
+	count int
+	since time.Time
+}
+
+// allow returns false when the address has reached the
+// maximum of failed attempts of the current window.
+func (l *attemptLimiter) allow(addr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.attempts[addr]
+	if !ok || time.Since(a.since) >= userCodeAttemptsWindow {
+		return true
+	}
+	return a.count < maxUserCodeAttempts
+}
+
+// fail counts a failed attempt of the address and
+// forgets the addresses whose window has elapsed.
+func (l *attemptLimiter) fail(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.attempts == nil {
+		l.attempts = map[string]*attempts{}
+	}
+	now := time.Now()
+	for k, a := range l.attempts {
+		if now.Sub(a.since) >= userCodeAttemptsWindow {
+			delete(l.attempts, k)
+		}
+	}
+	a, ok := l.attempts[addr]
+	if !ok {
+		a = &attempts{since: now}
+		l.attempts[addr] = a
+	}
+	a.count++
+}
+
+// remoteAddr returns the IP address the request was sent from.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/clientregistry"
+	clientregistrymemory "github.com/clawio/authentication/clientregistry/memory"
+	devicecodestorememory "github.com/clawio/authentication/devicecodestore/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) setupDeviceFlow(opts *devicecodestorememory.Options) {
+	clients := []*clientregistry.Client{
+		{ID: "cli", Name: "Sync CLI", Scope: "read"},
+		{ID: "web", Secret: clientSecretHash, Scope: "read"},
+	}
+	clientRegistry, err := clientregistrymemory.New(&clientregistrymemory.Options{Clients: clients})
+	require.Nil(suite.T(), err)
+	suite.Service.ClientRegistry = clientRegistry
+	suite.Service.DeviceCodeStore = devicecodestorememory.New(opts)
+}
+
+func (suite *TestSuite) TestDeviceAuthorize() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	require.NotEmpty(suite.T(), res.DeviceCode)
+	require.NotEmpty(suite.T(), res.UserCode)
+	require.Equal(suite.T(), "http://example.com/device", res.VerificationURI)
+	require.Equal(suite.T(), "http://example.com/device?user_code="+res.UserCode, res.VerificationURIComplete)
+	require.Equal(suite.T(), int64(600), res.ExpiresIn)
+	require.Equal(suite.T(), int64(5), res.Interval)
+}
+func (suite *TestSuite) TestDeviceAuthorize_withVerificationURI() {
+	suite.setupDeviceFlow(nil)
+	suite.Service.Config.DeviceCodeStore = &DeviceCodeStoreConfig{VerificationURI: "https://auth.example.org/device"}
+	res := suite.deviceAuthorize("cli")
+	require.Equal(suite.T(), "https://auth.example.org/device", res.VerificationURI)
+}
+func (suite *TestSuite) TestDeviceAuthorize_withoutClient() {
+	suite.setupDeviceFlow(nil)
+	w := suite.deviceAuthorizeForm(url.Values{})
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+}
+func (suite *TestSuite) TestDeviceAuthorize_withConfidentialClientAndNoSecret() {
+	suite.setupDeviceFlow(nil)
+	w := suite.deviceAuthorizeForm(url.Values{"client_id": {"web"}})
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+}
+func (suite *TestSuite) TestDeviceAuthorize_withExceedingScope() {
+	suite.setupDeviceFlow(nil)
+	w := suite.deviceAuthorizeForm(url.Values{"client_id": {"cli"}, "scope": {"read admin"}})
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+}
+func (suite *TestSuite) TestDeviceAuthorize_withoutDeviceCodeStore() {
+	w := suite.deviceAuthorizeForm(url.Values{"client_id": {"cli"}})
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestDevice() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	w := suite.device("GET", url.Values{"user_code": {res.UserCode}})
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), "DENY", w.Header().Get("X-Frame-Options"))
+	require.Contains(suite.T(), w.Body.String(), "Sync CLI")
+	require.Contains(suite.T(), w.Body.String(), "read")
+	require.Contains(suite.T(), w.Body.String(), res.UserCode)
+}
+func (suite *TestSuite) TestDevice_withoutUserCode() {
+	suite.setupDeviceFlow(nil)
+	w := suite.device("GET", url.Values{})
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "<form")
+}
+func (suite *TestSuite) TestDevice_withUnknownUserCode() {
+	suite.setupDeviceFlow(nil)
+	w := suite.device("GET", url.Values{"user_code": {"BCDF-GHJK"}})
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestDevice_withTooManyUnknownUserCodes() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	for i := 0; i < maxUserCodeAttempts; i++ {
+		w := suite.device("GET", url.Values{"user_code": {"BCDF-GHJK"}})
+		require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	}
+	// even the right code is refused until the window elapses.
+	w := suite.device("GET", url.Values{"user_code": {res.UserCode}})
+	require.Equal(suite.T(), http.StatusTooManyRequests, w.Code)
+}
+func (suite *TestSuite) TestDevice_withBadPassword() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(nil, errors.New("test error"))
+	w := suite.device("POST", url.Values{"user_code": {res.UserCode}, "username": {"test"}, "password": {"bad"}})
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+
+	// the device keeps waiting for the user.
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "authorization_pending")
+}
+func (suite *TestSuite) TestAuthenticate_withDeviceCode() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	w := suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "authorization_pending")
+
+	suite.approveDevice(strings.ToLower(res.UserCode))
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Equal(suite.T(), "read", authNRes.Scope)
+	user, err := suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+
+	// the device code can only be exchanged once.
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+func (suite *TestSuite) TestAuthenticate_withDeviceCodeTooFast() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	w := suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "authorization_pending")
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "slow_down")
+}
+func (suite *TestSuite) TestAuthenticate_withDeniedDeviceCode() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	w := suite.device("POST", url.Values{"user_code": {res.UserCode}, "username": {"test"}, "password": {"test"}, "action": {"deny"}})
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "access_denied")
+}
+func (suite *TestSuite) TestAuthenticate_withDeniedDeviceCodeAndBadPassword() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(nil, errors.New("test error"))
+	w := suite.device("POST", url.Values{"user_code": {res.UserCode}, "username": {"test"}, "password": {"bad"}, "action": {"deny"}})
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	w = suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "authorization_pending")
+}
+func (suite *TestSuite) TestAuthenticate_withExpiredDeviceCode() {
+	suite.setupDeviceFlow(&devicecodestorememory.Options{TTL: time.Nanosecond})
+	res := suite.deviceAuthorize("cli")
+	time.Sleep(time.Millisecond)
+	w := suite.tokenForm(deviceTokenForm("cli", res.DeviceCode), "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "expired_token")
+}
+func (suite *TestSuite) TestAuthenticate_withDeviceCodeOfOtherClient() {
+	suite.setupDeviceFlow(nil)
+	res := suite.deviceAuthorize("cli")
+	suite.approveDevice(res.UserCode)
+	w := suite.tokenForm(deviceTokenForm("", res.DeviceCode), "web", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+
+// approveDevice signs in the user test on the device page.
+func (suite *TestSuite) approveDevice(userCode string) {
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	w := suite.device("POST", url.Values{
+		"user_code": {userCode},
+		"username":  {"test"},
+		"password":  {"test"},
+		"action":    {"approve"},
+	})
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "The device is connected")
+}
+func deviceTokenForm(clientID, deviceCode string) url.Values {
+	form := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+	}
+	if clientID != "" {
+		form.Set("client_id", clientID)
+	}
+	return form
+}
+func (suite *TestSuite) deviceAuthorize(clientID string) *DeviceAuthorizationResponse {
+	w := suite.deviceAuthorizeForm(url.Values{"client_id": {clientID}, "scope": {"read"}})
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	res := &DeviceAuthorizationResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+	return res
+}
+func (suite *TestSuite) deviceAuthorizeForm(form url.Values) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", "http://example.com"+deviceAuthorizeURL, strings.NewReader(form.Encode()))
+	require.Nil(suite.T(), err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+func (suite *TestSuite) device(method string, params url.Values) *httptest.ResponseRecorder {
+	var r *http.Request
+	var err error
+	if method == "GET" {
+		r, err = http.NewRequest("GET", deviceURL+"?"+params.Encode(), nil)
+	} else {
+		r, err = http.NewRequest("POST", deviceURL, strings.NewReader(params.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
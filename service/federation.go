@@ -0,0 +1,166 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/authentication/federation"
+)
+
+// FederationLogin starts a login with an upstream identity provider from the
+// login page of the Authorize endpoint. It takes the parameters of the
+// authorization request and the provider parameter, and redirects the user
+// to the provider. The authorization request is validated again when the
+// provider redirects the user back to FederationCallback.
+func (s *Service) FederationLogin(w http.ResponseWriter, r *http.Request) {
+	if s.Federation == nil || s.AuthorizationCodeStore == nil || s.ClientRegistry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	values := r.URL.Query()
+	req := newAuthorizeRequest(values)
+	client, redirectURI, err := s.checkRedirectURI(req)
+	if err != nil {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The application is not registered or its redirect URI is not allowed."})
+		return
+	}
+	if oErr := s.checkAuthorizeRequest(req, client); oErr != nil {
+		redirect(w, r, redirectURI, url.Values{
+			"error":             {oErr.Code},
+			"error_description": {oErr.Description},
+			"state":             {req.State},
+		})
+		return
+	}
+
+	authCodeURL, err := s.Federation.AuthCodeURL(values.Get("provider"), s.federationRedirectURI(), req.values().Encode())
+	if err == federation.ErrUnknownProvider {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Client: client, Request: req, Error: "The identity provider does not exist."})
+		return
+	}
+	if err != nil {
+		s.renderLogin(w, http.StatusBadGateway, &loginPage{Client: client, Request: req, Error: "The identity provider is not available, please try again later."})
+		return
+	}
+	redirect(w, r, authCodeURL, nil)
+}
+
+// FederationCallback finishes a login with an upstream identity provider.
+// The user is redirected to the client with an authorization code, as
+// if they had signed in on the login page of the Authorize endpoint.
+// When the login fails the login page is shown again. Upstream users
+// whose username already belongs to a local user are refused.
+func (s *Service) FederationCallback(w http.ResponseWriter, r *http.Request) {
+	if s.Federation == nil || s.AuthorizationCodeStore == nil || s.ClientRegistry == nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		data, err := s.Federation.Cancel(query.Get("state"))
+		if err != nil {
+			s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The sign in has expired, please start again."})
+			return
+		}
+		s.renderFederationError(w, data, "The identity provider did not sign you in.")
+		return
+	}
+
+	user, data, err := s.Federation.Exchange(query.Get("state"), query.Get("code"))
+	if err == federation.ErrInvalidState {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The sign in has expired, please start again."})
+		return
+	}
+	if err == federation.ErrUserNotAllowed {
+		s.renderFederationError(w, data, "Your account of the identity provider is not allowed to sign in.")
+		return
+	}
+	if err == nil && !s.Config.Federation.Provision {
+		err = s.checkFederatedUser(r, user.Username)
+	}
+	if err == authenticationcontroller.ErrUserExists {
+		s.renderFederationError(w, data, "An account with the same username already exists.")
+		return
+	}
+	if err != nil {
+		s.renderFederationError(w, data, "The sign in with the identity provider failed.")
+		return
+	}
+
+	client, redirectURI, req, err := s.federatedAuthorizeRequest(data)
+	if err != nil {
+		s.renderLogin(w, http.StatusBadRequest, &loginPage{Error: "The application is not registered or its redirect URI is not allowed."})
+		return
+	}
+	s.issueAuthorizationCode(w, r, user, client, redirectURI, req)
+}
+
+// renderFederationError renders the login page of the
+// authorization request of a failed login with an error.
+func (s *Service) renderFederationError(w http.ResponseWriter, data, message string) {
+	client, _, req, err := s.federatedAuthorizeRequest(data)
+	if err != nil {
+		s.renderLogin(w, http.StatusUnauthorized, &loginPage{Error: message})
+		return
+	}
+	s.renderLogin(w, http.StatusUnauthorized, &loginPage{Client: client, Request: req, Error: message})
+}
+
+// federatedAuthorizeRequest decodes and validates again
+// the authorization request kept with an upstream login.
+func (s *Service) federatedAuthorizeRequest(data string) (*clientregistry.Client, string, *authorizeRequest, error) {
+	values, err := url.ParseQuery(data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req := newAuthorizeRequest(values)
+	client, redirectURI, err := s.checkRedirectURI(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if oErr := s.checkAuthorizeRequest(req, client); oErr != nil {
+		return nil, "", nil, oErr
+	}
+	return client, redirectURI, req, nil
+}
+
+// checkFederatedUser returns ErrUserExists when the username of an upstream
+// user that is not provisioned belongs to an user of the UserStore. The
+// provisioned users are checked by the Provisioner instead.
+func (s *Service) checkFederatedUser(r *http.Request, username string) error {
+	if s.UserStore == nil {
+		return nil
+	}
+	_, err := s.UserStore.GetUser(r.Context(), username)
+	if err == authenticationcontroller.ErrUserNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return authenticationcontroller.ErrUserExists
+}
+
+// federationRedirectURI returns the callback address
+// registered with the upstream providers.
+func (s *Service) federationRedirectURI() string {
+	return s.Config.Federation.RedirectURI
+}
+
+// federationLinks returns the links of the login page to sign in with
+// the providers. They are relative to the Authorize endpoint.
+func federationLinks(providers []*federation.ProviderConfig, req *authorizeRequest) []*providerLink {
+	links := []*providerLink{}
+	for _, p := range providers {
+		values := req.values()
+		values.Set("provider", p.ID)
+		name := p.Name
+		if name == "" {
+			name = p.ID
+		}
+		links = append(links, &providerLink{Name: name, URL: "federation/login?" + values.Encode()})
+	}
+	return links
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/federation"
+	"github.com/clawio/authentication/federation/federationtest"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+var upstreamClaims = map[string]interface{}{
+	"sub":                "248289761001",
+	"preferred_username": "jdoe",
+	"email":              "jdoe@campus.example.org",
+	"name":               "Jane Doe",
+}
+
+// setupFederation enables the authorization code flow with an
+// upstream provider served by the returned issuer.
+func (suite *TestSuite) setupFederation() *federationtest.Issuer {
+	suite.setupAuthorizationCodeFlow()
+	issuer := federationtest.NewIssuer("clawio", "secret")
+	suite.Service.Config.Federation = &FederationConfig{
+		Providers: []*federation.ProviderConfig{{
+			ID:           "campus",
+			Name:         "Campus",
+			Issuer:       issuer.URL,
+			ClientID:     "clawio",
+			ClientSecret: "secret",
+			Claims:       &federation.ClaimMapping{Username: "{preferred_username}", DisplayName: "{name}"},
+		}},
+		RedirectURI: "https://auth.example.org/federation/callback",
+	}
+	fed, err := federation.New(&federation.Options{Providers: suite.Service.Config.Federation.Providers})
+	require.Nil(suite.T(), err)
+	suite.Service.Federation = fed
+	return issuer
+}
+
+func (suite *TestSuite) TestAuthorize_withFederation() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	w := suite.authorize("GET", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "Sign in with Campus")
+	require.Contains(suite.T(), w.Body.String(), `href="federation/login?`)
+}
+func (suite *TestSuite) TestFederationLogin() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	callback := suite.federationLogin(issuer, upstreamClaims)
+	require.Equal(suite.T(), "https://auth.example.org/federation/callback", callback.Scheme+"://"+callback.Host+callback.Path)
+
+	w := suite.federationCallback(callback)
+	location := suite.requireRedirect(w)
+	require.Equal(suite.T(), "xyz", location.Query().Get("state"))
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w = suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	user, err := suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "jdoe@campus", user.Username)
+	require.Equal(suite.T(), "Jane Doe", user.DisplayName)
+
+	// the login can not be replayed.
+	w = suite.federationCallback(callback)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestFederationLogin_withAdminUsername() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	suite.Service.Config.General.AdminUsers = []string{"admin", "admin@campus"}
+	claims := map[string]interface{}{"sub": "1", "preferred_username": "admin"}
+	location := suite.requireRedirect(suite.federationCallback(suite.federationLogin(issuer, claims)))
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	claims, err := suite.Service.Authenticator.Claims(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	// the upstream admin is neither the local admin nor an admin.
+	require.Equal(suite.T(), "admin@campus", claims["username"])
+	require.Nil(suite.T(), claims[rolesClaim])
+}
+func (suite *TestSuite) TestFederationCallback_withLocalUser() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	suite.setupUserStore()
+	local := &authenticationcontroller.StoredUser{User: &entities.User{Username: "jdoe@campus"}}
+	require.Nil(suite.T(), suite.Service.UserStore.CreateUser(context.Background(), local, "secret"))
+	w := suite.federationCallback(suite.federationLogin(issuer, upstreamClaims))
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "An account with the same username already exists.")
+}
+func (suite *TestSuite) TestFederationLogin_withoutFederation() {
+	suite.setupAuthorizationCodeFlow()
+	w := suite.federationLoginRequest("campus", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestFederationLogin_withUnknownProvider() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	w := suite.federationLoginRequest("unknown", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "Sign in to Desktop")
+}
+func (suite *TestSuite) TestFederationLogin_withUnknownClient() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	w := suite.federationLoginRequest("campus", authorizeParams("unknown"))
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	require.NotContains(suite.T(), w.Body.String(), "Sign in with Campus")
+}
+func (suite *TestSuite) TestFederationLogin_withoutPKCE() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	params := authorizeParams("desktop")
+	params.Del("code_challenge")
+	location := suite.requireRedirect(suite.federationLoginRequest("campus", params))
+	require.Equal(suite.T(), "invalid_request", location.Query().Get("error"))
+}
+func (suite *TestSuite) TestFederationLogin_withUnavailableProvider() {
+	issuer := suite.setupFederation()
+	issuer.Close()
+	w := suite.federationLoginRequest("campus", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusBadGateway, w.Code)
+}
+func (suite *TestSuite) TestFederationCallback_withError() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	callback := suite.federationLogin(issuer, upstreamClaims)
+	query := callback.Query()
+	query.Del("code")
+	query.Set("error", "access_denied")
+	callback.RawQuery = query.Encode()
+	w := suite.federationCallback(callback)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "Sign in to Desktop")
+	require.Contains(suite.T(), html.UnescapeString(w.Body.String()), "The identity provider did not sign you in.")
+}
+func (suite *TestSuite) TestFederationCallback_withBadState() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	callback := suite.federationLogin(issuer, upstreamClaims)
+	query := callback.Query()
+	query.Set("state", "bad")
+	callback.RawQuery = query.Encode()
+	w := suite.federationCallback(callback)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestFederationCallback_withUserNotAllowed() {
+	issuer := suite.setupFederation()
+	defer issuer.Close()
+	suite.Service.Config.Federation.Providers[0].Require = map[string]string{"hd": "campus.example.org"}
+	w := suite.federationCallback(suite.federationLogin(issuer, upstreamClaims))
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	require.Contains(suite.T(), w.Body.String(), "Sign in to Desktop")
+	require.Contains(suite.T(), w.Body.String(), "is not allowed to sign in")
+}
+func (suite *TestSuite) TestNew_withFederation() {
+	providers := []*federation.ProviderConfig{{ID: "campus", Issuer: "https://idp.example.org", ClientID: "clawio"}}
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		Federation:               &FederationConfig{Providers: providers},
+	}
+	// the callback address is not taken from the requests.
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+
+	cfg.Federation.RedirectURI = "https://auth.example.org/federation/callback"
+	svc, err := New(cfg)
+	require.Nil(suite.T(), err)
+	require.NotNil(suite.T(), svc.Federation)
+
+	// the memory controller can not provision users.
+	cfg.Federation.Provision = true
+	_, err = New(cfg)
+	require.NotNil(suite.T(), err)
+
+	cfg.AuthenticationController = &AuthenticationControllerConfig{
+		Type:   "simple",
+		Config: json.RawMessage(`{"Driver": "sqlite3", "DSN": "` + filepath.Join(suite.dir, "userstore.db") + `"}`),
+	}
+	_, err = New(cfg)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadFederation() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		Federation:               &FederationConfig{Providers: []*federation.ProviderConfig{{ID: "campus"}}},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+
+// federationLogin starts a login with the provider campus for the client
+// desktop and returns the callback URL the issuer redirects the user to.
+func (suite *TestSuite) federationLogin(issuer *federationtest.Issuer, claims map[string]interface{}) *url.URL {
+	w := suite.federationLoginRequest("campus", authorizeParams("desktop"))
+	require.Equal(suite.T(), http.StatusFound, w.Code)
+	callback, err := issuer.Authorize(w.Header().Get("Location"), claims)
+	require.Nil(suite.T(), err)
+	return callback
+}
+func (suite *TestSuite) federationLoginRequest(provider string, params url.Values) *httptest.ResponseRecorder {
+	params.Set("provider", provider)
+	r, err := http.NewRequest("GET", "http://example.com/federation/login?"+params.Encode(), nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+func (suite *TestSuite) federationCallback(callback *url.URL) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("GET", "http://example.com"+callback.Path+"?"+callback.RawQuery, nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/clawio/authentication/lib"
+)
+
+// IntrospectResponse specifies the data returned from the Introspect endpoint.
+// Only Active is set for tokens that are not active. Tokens issued to
+// services with the client credentials grant have no user fields,
+// and their Sub is the ClientID.
+type IntrospectResponse struct {
+	Active      bool   `json:"active"`
+	Scope       string `json:"scope,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	ClientID    string `json:"client_id,omitempty"`
+	Sub         string `json:"sub,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Exp         int64  `json:"exp,omitempty"`
+	Iat         int64  `json:"iat,omitempty"`
+	Nbf         int64  `json:"nbf,omitempty"`
+	Jti         string `json:"jti,omitempty"`
+}
+
+// Introspect returns the state and the claims of an access token following
+// RFC 7662, for services that can not validate tokens themselves.
+// Callers authenticate with their client credentials. The token is sent
+// form encoded in the token parameter. Expired, revoked or invalid tokens,
+// refresh tokens and ID tokens are reported as not active, and errors
+// are returned in the OAuth 2.0 format.
+func (s *Service) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "body is not form encoded"))
+		return
+	}
+	if _, err := s.authenticateClient(r); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusUnauthorized, "invalid_client", "client authentication failed"))
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "token is required"))
+		return
+	}
+
+	res := &IntrospectResponse{}
+	if claims, err := s.Authenticator.Claims(token); err == nil && lib.IsAccessToken(claims) {
+		res = newIntrospectResponse(claims)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func newIntrospectResponse(claims map[string]interface{}) *IntrospectResponse {
+	str := func(name string) string {
+		v, _ := claims[name].(string)
+		return v
+	}
+	num := func(name string) int64 {
+		v, _ := claims[name].(float64)
+		return int64(v)
+	}
+	sub := str("sub")
+	if sub == "" {
+		sub = str("username")
+	}
+	return &IntrospectResponse{
+		Active:      true,
+		Scope:       str("scope"),
+		TokenType:   "Bearer",
+		ClientID:    str("client_id"),
+		Sub:         sub,
+		Username:    str("username"),
+		Email:       str("email"),
+		DisplayName: str("display_name"),
+		Exp:         num("exp"),
+		Iat:         num("iat"),
+		Nbf:         num("nbf"),
+		Jti:         str("jti"),
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/clawio/authentication/clientregistry"
+	clientregistrymemory "github.com/clawio/authentication/clientregistry/memory"
+	"github.com/clawio/authentication/lib"
+	revocationstorememory "github.com/clawio/authentication/revocationstore/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+// bcrypt hash of "secret"
+const clientSecretHash = "$2a$04$T/dwqYcp0JQnEQHTtka7ZOrpG154qRoVI2ci3JgKp6iDBQMOr1Ndi"
+
+func (suite *TestSuite) setupClientRegistry() {
+	clients := []*clientregistry.Client{{ID: "test", Secret: clientSecretHash, Scope: "openid read write"}}
+	clientRegistry, err := clientregistrymemory.New(&clientregistrymemory.Options{Clients: clients})
+	require.Nil(suite.T(), err)
+	suite.Service.ClientRegistry = clientRegistry
+}
+
+func (suite *TestSuite) TestIntrospect() {
+	suite.setupClientRegistry()
+	user := &entities.User{Username: "test", Email: "test@test.com", DisplayName: "Test"}
+	token, err := suite.Service.Authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	w := suite.introspect(url.Values{"token": {token}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), "no-store", w.Header().Get("Cache-Control"))
+	res := &IntrospectResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+	require.True(suite.T(), res.Active)
+	require.Equal(suite.T(), "test", res.Sub)
+	require.Equal(suite.T(), "test", res.Username)
+	require.Equal(suite.T(), "test@test.com", res.Email)
+	require.NotZero(suite.T(), res.Exp)
+	require.NotEmpty(suite.T(), res.Jti)
+}
+func (suite *TestSuite) TestIntrospect_withServiceToken() {
+	suite.setupClientRegistry()
+	token, err := suite.Service.Authenticator.CreateServiceToken(&lib.ServicePrincipal{ClientID: "data", Scope: "read"})
+	require.Nil(suite.T(), err)
+	w := suite.introspect(url.Values{"token": {token}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	res := &IntrospectResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+	require.True(suite.T(), res.Active)
+	require.Equal(suite.T(), "data", res.Sub)
+	require.Equal(suite.T(), "data", res.ClientID)
+	require.Equal(suite.T(), "read", res.Scope)
+	require.Empty(suite.T(), res.Username)
+}
+func (suite *TestSuite) TestIntrospect_withFormCredentials() {
+	suite.setupClientRegistry()
+	token, err := suite.Service.Authenticator.CreateToken(&entities.User{Username: "test"})
+	require.Nil(suite.T(), err)
+	form := url.Values{"token": {token}, "client_id": {"test"}, "client_secret": {"secret"}}
+	w := suite.introspect(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+}
+func (suite *TestSuite) TestIntrospect_withRevokedToken() {
+	suite.setupClientRegistry()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	token, err := suite.Service.Authenticator.CreateToken(&entities.User{Username: "test"})
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), suite.Service.Authenticator.RevokeToken(token))
+	w := suite.introspect(url.Values{"token": {token}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.JSONEq(suite.T(), `{"active":false}`, w.Body.String())
+}
+func (suite *TestSuite) TestIntrospect_withRevokedUserTokens() {
+	suite.setupClientRegistry()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	now := time.Now()
+	suite.Service.Authenticator.Now = func() time.Time { return now }
+	token, err := suite.Service.Authenticator.CreateToken(&entities.User{Username: "test"})
+	require.Nil(suite.T(), err)
+	now = now.Add(time.Minute)
+	require.Nil(suite.T(), suite.Service.Authenticator.RevokeUserTokens("test"))
+	w := suite.introspect(url.Values{"token": {token}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.JSONEq(suite.T(), `{"active":false}`, w.Body.String())
+}
+func (suite *TestSuite) TestIntrospect_withIDToken() {
+	suite.setupClientRegistry()
+	suite.useAsymmetricKey()
+	user := &entities.User{Username: "test", Email: "test@test.com"}
+	token, err := suite.Service.Authenticator.CreateIDToken(user, &lib.IDToken{
+		Issuer:   "https://auth.example.org",
+		Audience: "test",
+		Nonce:    "nonce",
+		Scope:    "openid profile email",
+	})
+	require.Nil(suite.T(), err)
+	w := suite.introspect(url.Values{"token": {token}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.JSONEq(suite.T(), `{"active":false}`, w.Body.String())
+}
+func (suite *TestSuite) TestIntrospect_withInvalidToken() {
+	suite.setupClientRegistry()
+	w := suite.introspect(url.Values{"token": {"invalid"}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.JSONEq(suite.T(), `{"active":false}`, w.Body.String())
+}
+func (suite *TestSuite) TestIntrospect_withoutToken() {
+	suite.setupClientRegistry()
+	w := suite.introspect(url.Values{}, "test", "secret")
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+func (suite *TestSuite) TestIntrospect_withBadClientSecret() {
+	suite.setupClientRegistry()
+	w := suite.introspect(url.Values{"token": {"invalid"}}, "test", "bad")
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+	require.NotEmpty(suite.T(), w.Header().Get("WWW-Authenticate"))
+	oErr := &OAuthError{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(oErr))
+	require.Equal(suite.T(), "invalid_client", oErr.Code)
+}
+func (suite *TestSuite) TestIntrospect_withoutClientRegistry() {
+	w := suite.introspect(url.Values{"token": {"invalid"}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+
+func (suite *TestSuite) introspect(form url.Values, id, secret string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", introspectURL, strings.NewReader(form.Encode()))
+	require.Nil(suite.T(), err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id != "" {
+		r.SetBasicAuth(id, secret)
+	}
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
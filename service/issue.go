@@ -0,0 +1,86 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/refreshtokenstore"
+	"github.com/clawio/entities"
+)
+
+// tokenGrant describes the tokens to issue to an user whose credentials,
+// code or refresh token have been verified by a grant.
+type tokenGrant struct {
+	User     *entities.User
+	ClientID string
+	Scope    string
+
+	// RefreshToken is the rotated refresh token of the refresh_token
+	// grant, a new one is issued when empty and refresh tokens are enabled.
+	RefreshToken string
+
+	// IDToken is the OpenID Connect ID token to add when the openid
+	// scope is granted to a client, nil for no ID token.
+	IDToken *lib.IDToken
+}
+
+// issueTokens is the only place where user tokens are issued: it signs the
+// access token with the scope, role and client_id claims, sets its lifetime
+// and adds the refresh and ID tokens. Authentication controllers only verify credentials.
+func (s *Service) issueTokens(r *http.Request, g *tokenGrant) (*AuthenticateResponse, *OAuthError) {
+	claims := s.userClaims(g.User, g.Scope)
+	if g.ClientID != "" {
+		// the client is named so it can be checked on revocation.
+		if claims == nil {
+			claims = map[string]interface{}{}
+		}
+		claims["client_id"] = g.ClientID
+	}
+	token, err := s.Authenticator.CreateTokenWithClaims(g.User, claims)
+	if err != nil {
+		return nil, newServerError()
+	}
+	res := s.newAuthenticateResponse(token, g.Scope)
+	res.RefreshToken = g.RefreshToken
+	if res.RefreshToken == "" && s.RefreshTokenStore != nil {
+		grant := &refreshtokenstore.Grant{User: g.User, ClientID: g.ClientID, Scope: g.Scope}
+		res.RefreshToken, err = s.RefreshTokenStore.Issue(grant)
+		if err != nil {
+			return nil, newServerError()
+		}
+	}
+	if g.IDToken != nil {
+		if oErr := s.addIDToken(r, res, g.User, g.IDToken); oErr != nil {
+			return nil, oErr
+		}
+	}
+	return res, nil
+}
+
+// userClaims returns the claims of the access tokens of the user. The
+// roles are looked up on every issuance, so a refreshed token loses a
+// role removed from the configuration.
+func (s *Service) userClaims(user *entities.User, scope string) map[string]interface{} {
+	claims := scopeClaims(scope)
+	if s.isAdmin(user.Username) {
+		if claims == nil {
+			claims = map[string]interface{}{}
+		}
+		claims[rolesClaim] = []string{adminRole}
+	}
+	return claims
+}
+
+// isAdmin reports whether the user is one of the AdminUsers. The
+// users of the upstream providers are never admins, whatever their name.
+func (s *Service) isAdmin(username string) bool {
+	if s.Federation != nil && s.Federation.IsFederated(username) {
+		return false
+	}
+	for _, admin := range s.Config.General.AdminUsers {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
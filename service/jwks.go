@@ -0,0 +1,28 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/clawio/authentication/lib"
+)
+
+// jwksMaxAge is how long consumers may cache the key set. Keys scheduled
+// for rotation must be configured at least this long before they become active.
+const jwksMaxAge = 5 * time.Minute
+
+// JWKS returns the public keys that verify the issued tokens as a JSON Web Key Set.
+// HMAC keys are never published.
+func (s *Service) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.Authenticator.Keys.VerificationKeys()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(jwksMaxAge/time.Second)))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lib.NewJSONWebKeySet(keys))
+}
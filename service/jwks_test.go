@@ -0,0 +1,43 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/clawio/authentication/lib"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestJWKS() {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	key := &lib.Key{ID: "test", SigningMethod: "ES256", SigningKey: priv, VerificationKey: &priv.PublicKey}
+	suite.Service.Authenticator = lib.NewAuthenticatorWithKey(key)
+
+	r, err := http.NewRequest("GET", jwksURL, nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), "public, max-age=300", w.Header().Get("Cache-Control"))
+	set := &lib.JSONWebKeySet{}
+	err = json.NewDecoder(w.Body).Decode(set)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), set.Keys, 1)
+	require.Equal(suite.T(), "test", set.Keys[0].Kid)
+}
+func (suite *TestSuite) TestJWKS_withHMACKey() {
+	r, err := http.NewRequest("GET", jwksURL, nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	set := &lib.JSONWebKeySet{}
+	err = json.NewDecoder(w.Body).Decode(set)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), set.Keys, 0)
+}
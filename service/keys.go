@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/clawio/authentication/lib"
+)
+
+// defaultKeyID is the key ID of the HMAC key configured with JWTKey.
+const defaultKeyID = "default"
+
+// getScheduledKeys returns the signing keys rotation schedule. Without JWTKeys
+// the schedule has the single key configured with JWTKey or the key files.
+func getScheduledKeys(cfg *Config) ([]*lib.ScheduledKey, error) {
+	if len(cfg.General.JWTKeys) == 0 {
+		key, err := getKey(&JWTKeyConfig{
+			SigningMethod:  cfg.General.JWTSigningMethod,
+			Key:            cfg.General.JWTKey,
+			PrivateKeyFile: cfg.General.JWTPrivateKeyFile,
+			PublicKeyFile:  cfg.General.JWTPublicKeyFile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []*lib.ScheduledKey{{Key: key}}, nil
+	}
+
+	keys := []*lib.ScheduledKey{}
+	for _, keyCfg := range cfg.General.JWTKeys {
+		key, err := getKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+		scheduled := &lib.ScheduledKey{Key: key}
+		if keyCfg.ActiveFrom != "" {
+			activeFrom, err := time.Parse(time.RFC3339, keyCfg.ActiveFrom)
+			if err != nil {
+				return nil, errors.New("key " + keyCfg.ID + " ActiveFrom is not a RFC 3339 time: " + err.Error())
+			}
+			scheduled.ActiveFrom = activeFrom
+		}
+		keys = append(keys, scheduled)
+	}
+	return keys, nil
+}
+
+// getKey returns the key for a key configuration. Keys without ID are identified
+// by their RFC 7638 thumbprint, or by defaultKeyID for HMAC keys.
+func getKey(cfg *JWTKeyConfig) (*lib.Key, error) {
+	method := cfg.SigningMethod
+	if method == "" {
+		method = lib.DefaultJWTSigningMethod
+	}
+
+	var key *lib.Key
+	var err error
+	if cfg.PrivateKeyFile != "" || cfg.PublicKeyFile != "" {
+		key, err = lib.LoadKey(method, cfg.PrivateKeyFile, cfg.PublicKeyFile)
+	} else {
+		secret := cfg.Key
+		if secret == "" {
+			secret = lib.DefaultJWTKey
+		}
+		key, err = lib.NewHMACKey(secret, method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = cfg.ID
+	if key.ID == "" {
+		key.ID, err = lib.Thumbprint(key)
+		if err == lib.ErrNotPublicKey {
+			key.ID, err = defaultKeyID, nil
+		}
+	}
+	return key, err
+}
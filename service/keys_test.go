@@ -0,0 +1,55 @@
+package service
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestgetScheduledKeys() {
+	cfg := &Config{
+		General: &GeneralConfig{
+			JWTKeys: []*JWTKeyConfig{
+				{ID: "key1", SigningMethod: "HS256", Key: "secret1"},
+				{ID: "key2", SigningMethod: "HS512", Key: "secret2", ActiveFrom: "2030-01-01T00:00:00Z"},
+			},
+		},
+	}
+	keys, err := getScheduledKeys(cfg)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), keys, 2)
+	require.Equal(suite.T(), "key2", keys[1].ID)
+	require.Equal(suite.T(), time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), keys[1].ActiveFrom.UTC())
+}
+func (suite *TestSuite) TestgetScheduledKeys_withBadActiveFrom() {
+	cfg := &Config{
+		General: &GeneralConfig{
+			JWTKeys: []*JWTKeyConfig{
+				{ID: "key1", SigningMethod: "HS256", Key: "secret1", ActiveFrom: "tomorrow"},
+			},
+		},
+	}
+	_, err := getScheduledKeys(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestgetScheduledKeys_withDefaultKey() {
+	cfg := &Config{
+		General: &GeneralConfig{},
+	}
+	keys, err := getScheduledKeys(cfg)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), keys, 1)
+	require.Equal(suite.T(), defaultKeyID, keys[0].ID)
+}
+func (suite *TestSuite) TestgetAuthenticator_withDuplicatedKeyID() {
+	cfg := &Config{
+		General: &GeneralConfig{
+			JWTKeys: []*JWTKeyConfig{
+				{ID: "key1", SigningMethod: "HS256", Key: "secret1"},
+				{ID: "key1", SigningMethod: "HS256", Key: "secret2"},
+			},
+		},
+	}
+	_, err := getAuthenticator(cfg)
+	require.NotNil(suite.T(), err)
+}
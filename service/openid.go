@@ -0,0 +1,125 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/clawio/authentication/lib"
+)
+
+// OpenIDConfiguration specifies the data returned from the OpenIDConfiguration
+// endpoint, the OpenID Connect Discovery metadata of the service.
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration returns the OpenID Connect Discovery metadata, served
+// on /.well-known/openid-configuration under the issuer URL. It is not found
+// unless the tokens are signed with an asymmetric key, as ID tokens are only
+// issued then.
+func (s *Service) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	if !s.Authenticator.SignsIDTokens() {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	keys, err := s.Authenticator.Keys.VerificationKeys()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	algs := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !seen[key.SigningMethod] {
+			seen[key.SigningMethod] = true
+			algs = append(algs, key.SigningMethod)
+		}
+	}
+
+	issuer := s.issuer()
+	res := &OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/jwks.json",
+		RevocationEndpoint:                issuer + "/revoke",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		ScopesSupported:                   []string{"openid", "profile", "email"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "password", "refresh_token", "client_credentials"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "preferred_username", "email"},
+	}
+	if s.DeviceCodeStore != nil {
+		res.DeviceAuthorizationEndpoint = issuer + "/device/authorize"
+		res.GrantTypesSupported = append(res.GrantTypesSupported, deviceCodeGrantType)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// UserInfo returns the OpenID Connect claims of the user of an access token
+// granted the openid scope: sub always, name and preferred_username with the
+// profile scope and email with the email scope.
+func (s *Service) UserInfo(w http.ResponseWriter, r *http.Request) {
+	token := s.Authenticator.TokenFromRequest(r)
+	user, err := s.Authenticator.CreateUserFromToken(token)
+	if err != nil {
+		writeBearerError(w, newOAuthError(http.StatusUnauthorized, "invalid_token", "access token is invalid"))
+		return
+	}
+	claims, err := s.Authenticator.Claims(token)
+	if err != nil {
+		writeBearerError(w, newOAuthError(http.StatusUnauthorized, "invalid_token", "access token is invalid"))
+		return
+	}
+	scope, _ := claims["scope"].(string)
+	if !hasScope(scope, "openid") {
+		writeBearerError(w, newOAuthError(http.StatusForbidden, "insufficient_scope", "access token has no openid scope"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(lib.UserInfoClaims(user, scope))
+}
+
+// writeBearerError writes the errors of endpoints protected
+// with bearer tokens as defined in RFC 6750 section 3.
+func writeBearerError(w http.ResponseWriter, e *OAuthError) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+e.Code+`", error_description="`+e.Description+`"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// issuer returns the OpenID Connect issuer, the configured public address
+// of the service. It is never taken from the Host header of the requests,
+// which the clients choose.
+func (s *Service) issuer() string {
+	return strings.TrimSuffix(s.Config.General.Issuer, "/")
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+const issuer = "https://auth.example.org"
+
+var oidcUser = &entities.User{Username: "test", Email: "test@example.org", DisplayName: "Test"}
+
+func (suite *TestSuite) TestOpenIDConfiguration() {
+	suite.useAsymmetricKey()
+	r, err := http.NewRequest("GET", "http://example.com/.well-known/openid-configuration", nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	res := &OpenIDConfiguration{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+	require.Equal(suite.T(), "http://example.com", res.Issuer)
+	require.Equal(suite.T(), "http://example.com/token", res.TokenEndpoint)
+	require.Equal(suite.T(), "http://example.com/userinfo", res.UserInfoEndpoint)
+	require.Equal(suite.T(), "http://example.com/jwks.json", res.JWKSURI)
+	require.Equal(suite.T(), []string{"ES256"}, res.IDTokenSigningAlgValuesSupported)
+	require.Empty(suite.T(), res.DeviceAuthorizationEndpoint)
+}
+func (suite *TestSuite) TestOpenIDConfiguration_withHMACKey() {
+	r, err := http.NewRequest("GET", "http://example.com/.well-known/openid-configuration", nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
+func (suite *TestSuite) TestOpenIDConfiguration_withIssuer() {
+	suite.useAsymmetricKey()
+	suite.Service.Config.General.Issuer = issuer + "/"
+	// the Host header is not used.
+	r, err := http.NewRequest("GET", "http://attacker.example.com/.well-known/openid-configuration", nil)
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	res := &OpenIDConfiguration{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(res))
+	require.Equal(suite.T(), issuer, res.Issuer)
+	require.Equal(suite.T(), issuer+"/authorize", res.AuthorizationEndpoint)
+}
+func (suite *TestSuite) TestAuthorize_withOpenIDScope() {
+	suite.setupAuthorizationCodeFlow()
+	suite.useAsymmetricKey()
+	suite.Service.Config.General.Issuer = issuer
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: oidcUser}, nil)
+	params := authorizeParams("desktop")
+	params.Set("scope", "openid profile email")
+	params.Set("nonce", "n-0S6_WzA2Mj")
+	params.Set("username", "test")
+	params.Set("password", "test")
+	location := suite.requireRedirect(suite.authorize("POST", params))
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.NotEmpty(suite.T(), authNRes.IDToken)
+
+	claims, err := suite.Service.Authenticator.Claims(authNRes.IDToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), issuer, claims["iss"])
+	require.Equal(suite.T(), "test", claims["sub"])
+	require.Equal(suite.T(), "desktop", claims["aud"])
+	require.Equal(suite.T(), "n-0S6_WzA2Mj", claims["nonce"])
+	require.Equal(suite.T(), "Test", claims["name"])
+	require.Equal(suite.T(), "test@example.org", claims["email"])
+	require.NotNil(suite.T(), claims["auth_time"])
+	require.NotNil(suite.T(), claims["at_hash"])
+
+	// the ID token is not an access token.
+	_, err = suite.Service.Authenticator.CreateUserFromToken(authNRes.IDToken)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthorize_withoutOpenIDScope() {
+	suite.setupAuthorizationCodeFlow()
+	code := suite.authorizationCode("desktop")
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"desktop"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	w := suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Empty(suite.T(), authNRes.IDToken)
+}
+func (suite *TestSuite) TestAuthenticate_withOpenIDScope() {
+	suite.setupClientRegistry()
+	suite.useAsymmetricKey()
+	suite.Service.Config.General.Issuer = issuer
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: oidcUser}, nil)
+	form := url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}, "scope": {"openid"}}
+	w := suite.tokenForm(form, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	claims, err := suite.Service.Authenticator.Claims(authNRes.IDToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", claims["aud"])
+	require.Nil(suite.T(), claims["email"])
+
+	// without a client there is no audience for the ID token.
+	w = suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+}
+func (suite *TestSuite) TestAuthenticate_withOpenIDScopeAndHMACKey() {
+	suite.setupClientRegistry()
+	form := url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}, "scope": {"openid"}}
+	w := suite.tokenForm(form, "test", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+}
+func (suite *TestSuite) TestUserInfo() {
+	token, err := suite.Service.Authenticator.CreateTokenWithClaims(oidcUser, scopeClaims("openid email"))
+	require.Nil(suite.T(), err)
+	w := suite.userInfo(token)
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	claims := map[string]interface{}{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(&claims))
+	require.Equal(suite.T(), map[string]interface{}{"sub": "test", "email": "test@example.org"}, claims)
+}
+func (suite *TestSuite) TestUserInfo_withoutOpenIDScope() {
+	token, err := suite.Service.Authenticator.CreateTokenWithClaims(oidcUser, scopeClaims("read"))
+	require.Nil(suite.T(), err)
+	w := suite.userInfo(token)
+	suite.requireOAuthError(w, http.StatusForbidden, "insufficient_scope")
+	require.Contains(suite.T(), w.Header().Get("WWW-Authenticate"), `Bearer error="insufficient_scope"`)
+}
+func (suite *TestSuite) TestUserInfo_withBadToken() {
+	w := suite.userInfo("bad")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_token")
+}
+func (suite *TestSuite) userInfo(token string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("GET", "/userinfo", nil)
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+
+// useAsymmetricKey makes the service sign its tokens with an ES256
+// key, which ID tokens require.
+func (suite *TestSuite) useAsymmetricKey() {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(suite.T(), err)
+	key := &lib.Key{SigningMethod: "ES256", SigningKey: priv, VerificationKey: &priv.PublicKey}
+	suite.Service.Authenticator = lib.NewAuthenticatorWithKey(key)
+}
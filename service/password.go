@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/passwordpolicy"
+	"github.com/clawio/entities"
+	"github.com/clawio/keys"
+	"github.com/gorilla/context"
+)
+
+// ChangePasswordRequest specifies the data received by the ChangePassword
+// endpoint. RefreshToken is optional, the refresh token of the session
+// changing the password, which is not revoked with the others.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	RefreshToken    string `json:"refresh_token"`
+}
+
+// ChangePassword changes the password of the user of the access token,
+// who must send the current one, when the AuthenticationController is an
+// authenticationcontroller.PasswordChanger. The new password must follow
+// the PasswordPolicy. The refresh tokens of the other sessions of the user
+// are revoked and, with a RevocationStore, every access token issued to the
+// user until then, so the session changing the password gets a new one with
+// its refresh token.
+func (s *Service) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	changer, ok := s.AuthenticationController.(authenticationcontroller.PasswordChanger)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	// the user is set by JWTHandlerFunc.
+	user := context.Get(r, keys.UserKey).(*entities.User)
+	req := &ChangePasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeBadInput(w, http.StatusBadRequest, "")
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		writeBadInput(w, http.StatusBadRequest, "current_password and new_password are required")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		writeBadInput(w, http.StatusBadRequest, "new password must be different")
+		return
+	}
+	if err := s.checkPassword(user.Username, req.NewPassword); err != nil {
+		writeBadInput(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := changer.ChangePassword(r.Context(), user.Username, req.CurrentPassword, req.NewPassword)
+	switch err {
+	case nil:
+	case authenticationcontroller.ErrInvalidPassword, authenticationcontroller.ErrUserNotFound:
+		writeBadInput(w, http.StatusBadRequest, "current password does not match")
+		return
+	case authenticationcontroller.ErrPasswordChangeNotSupported, authenticationcontroller.ErrUserDisabled:
+		writeBadInput(w, http.StatusForbidden, err.Error())
+		return
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := s.revokeUserSessions(user.Username, req.RefreshToken); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserSessions revokes the refresh tokens of the user but the family
+// of keepToken, if not empty, and the access tokens issued to the user until
+// now. Access tokens can only be revoked with a RevocationStore.
+func (s *Service) revokeUserSessions(username, keepToken string) error {
+	if err := s.revokeUserRefreshTokens(username, keepToken); err != nil {
+		return err
+	}
+	if s.Authenticator.RevocationStore == nil {
+		return nil
+	}
+	return s.Authenticator.RevokeUserTokens(username)
+}
+
+func (s *Service) checkPassword(username, password string) error {
+	policy := s.PasswordPolicy
+	if policy == nil {
+		policy = passwordpolicy.New(nil)
+	}
+	return policy.Check(username, password)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/refreshtokenstore"
+	refreshtokenstorememory "github.com/clawio/authentication/refreshtokenstore/memory"
+	revocationstorememory "github.com/clawio/authentication/revocationstore/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPasswordChanger uses the controller of the user store,
+// which holds the user jdoe with the password "correct horse".
+func (suite *TestSuite) setupPasswordChanger() {
+	suite.setupUserStore()
+	err := suite.Service.UserStore.CreateUser(context.Background(), &authenticationcontroller.StoredUser{
+		User: &entities.User{Username: "jdoe"},
+	}, "correct horse")
+	require.Nil(suite.T(), err)
+	suite.Service.AuthenticationController = suite.Service.UserStore.(authenticationcontroller.AuthenticationController)
+}
+
+func (suite *TestSuite) TestChangePassword() {
+	suite.setupPasswordChanger()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	grant := &refreshtokenstore.Grant{User: &entities.User{Username: "jdoe"}}
+	current, err := suite.Service.RefreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+	other, err := suite.Service.RefreshTokenStore.Issue(grant)
+	require.Nil(suite.T(), err)
+
+	body := `{"current_password": "correct horse", "new_password": "battery staple", "refresh_token": "` + current + `"}`
+	w := suite.admin("POST", "/password", suite.userToken("jdoe"), body)
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	_, err = suite.Service.AuthenticationController.Authenticate(context.Background(), "jdoe", "battery staple")
+	require.Nil(suite.T(), err)
+	_, err = suite.Service.AuthenticationController.Authenticate(context.Background(), "jdoe", "correct horse")
+	require.Equal(suite.T(), authenticationcontroller.ErrInvalidPassword, err)
+
+	// only the session changing the password is kept.
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(current, "", "")
+	require.Nil(suite.T(), err)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(other, "", "")
+	require.Equal(suite.T(), refreshtokenstore.ErrInvalidToken, err)
+}
+func (suite *TestSuite) TestChangePassword_revokesAccessTokens() {
+	suite.setupPasswordChanger()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	now := time.Now()
+	suite.Service.Authenticator.Now = func() time.Time { return now }
+	token := suite.userToken("jdoe")
+	stolen := suite.userToken("jdoe")
+
+	now = now.Add(time.Minute)
+	body := `{"current_password": "correct horse", "new_password": "battery staple"}`
+	w := suite.admin("POST", "/password", token, body)
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	_, err := suite.Service.Authenticator.CreateUserFromToken(stolen)
+	require.Equal(suite.T(), lib.ErrTokenRevoked, err)
+	_, err = suite.Service.Authenticator.CreateUserFromToken(suite.userToken("jdoe"))
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestChangePassword_withInvalidCurrentPassword() {
+	suite.setupPasswordChanger()
+	body := `{"current_password": "wrong horse", "new_password": "battery staple"}`
+	w := suite.admin("POST", "/password", suite.userToken("jdoe"), body)
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	_, err := suite.Service.AuthenticationController.Authenticate(context.Background(), "jdoe", "correct horse")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestChangePassword_withInvalidNewPassword() {
+	suite.setupPasswordChanger()
+	for _, newPassword := range []string{"", "short", "correct horse", strings.Repeat("x", 73)} {
+		body := `{"current_password": "correct horse", "new_password": "` + newPassword + `"}`
+		w := suite.admin("POST", "/password", suite.userToken("jdoe"), body)
+		require.Equal(suite.T(), http.StatusBadRequest, w.Code, newPassword)
+	}
+}
+func (suite *TestSuite) TestChangePassword_withoutToken() {
+	suite.setupPasswordChanger()
+	body := `{"current_password": "correct horse", "new_password": "battery staple"}`
+	w := suite.admin("POST", "/password", "", body)
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestChangePassword_withoutPasswordChanger() {
+	body := `{"current_password": "correct horse", "new_password": "battery staple"}`
+	w := suite.admin("POST", "/password", suite.userToken("jdoe"), body)
+	require.Equal(suite.T(), http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/codes"
+)
+
+// LogoutRequest specifies the data received by the Logout endpoint.
+// RefreshToken is optional.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Revoke revokes an access or refresh token following RFC 7009.
+// The token is sent form encoded in the token parameter. The token_type_hint
+// parameter is ignored as both kinds of tokens are looked up.
+// The client authenticates like on the Token endpoint, public clients with
+// client_id, and tokens issued to another client are not revoked. Access
+// tokens are unsupported_token_type when no RevocationStore is configured.
+// Invalid and unknown tokens are not an error, as there is nothing to revoke,
+// and errors are returned in the OAuth 2.0 format.
+func (s *Service) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "body is not form encoded"))
+		return
+	}
+	clientID, oErr := s.tokenClientID(r, &AuthenticateRequest{ClientID: r.PostForm.Get("client_id")})
+	if oErr != nil {
+		writeOAuthError(w, oErr)
+		return
+	}
+	if clientID == "" {
+		writeOAuthError(w, newOAuthError(http.StatusUnauthorized, "invalid_client", "client authentication is required"))
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "token is required"))
+		return
+	}
+	if err := s.revokeRefreshToken(token, clientID); err != nil {
+		writeOAuthError(w, newServerError())
+		return
+	}
+	claims, err := s.Authenticator.Claims(token)
+	if err != nil {
+		// a refresh token, or an access token that is already invalid.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if s.Authenticator.RevocationStore == nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unsupported_token_type", "access tokens can not be revoked"))
+		return
+	}
+	// access tokens issued to other clients, or without a
+	// client, are not revoked, like refresh tokens.
+	if tokenClientID, _ := claims["client_id"].(string); tokenClientID != clientID {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	err = s.Authenticator.RevokeToken(token)
+	if err == lib.ErrTokenNotRevocable {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unsupported_token_type", "token has no jti claim and can not be revoked"))
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, newServerError())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Logout revokes the access token of the request and,
+// if given, the refresh tokens issued with it.
+func (s *Service) Logout(w http.ResponseWriter, r *http.Request) {
+	logoutReq := &LogoutRequest{}
+	if r.Body != nil {
+		// the body is optional.
+		if err := json.NewDecoder(r.Body).Decode(logoutReq); err != nil && err != io.EOF {
+			e := codes.NewErr(codes.BadInputData, "")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(e)
+			return
+		}
+	}
+	if logoutReq.RefreshToken != "" {
+		if err := s.revokeRefreshToken(logoutReq.RefreshToken, ""); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.revokeAccessToken(s.Authenticator.TokenFromRequest(r)); err != nil {
+		s.handleRevokeError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeRefreshToken revokes the family of the refresh token
+// if it is bound to clientID, any client when it is empty.
+func (s *Service) revokeRefreshToken(token, clientID string) error {
+	if s.RefreshTokenStore == nil {
+		return nil
+	}
+	return s.RefreshTokenStore.Revoke(token, clientID)
+}
+
+// revokeAccessToken revokes the token if a revocation store is configured.
+// Without it access tokens stay valid until they expire.
+func (s *Service) revokeAccessToken(token string) error {
+	if s.Authenticator.RevocationStore == nil {
+		return nil
+	}
+	return s.Authenticator.RevokeToken(token)
+}
+
+func (s *Service) handleRevokeError(err error, w http.ResponseWriter) {
+	if err == lib.ErrTokenNotRevocable {
+		e := codes.NewErr(codes.BadInputData, "token can not be revoked")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/clawio/authentication/refreshtokenstore"
+	refreshtokenstorememory "github.com/clawio/authentication/refreshtokenstore/memory"
+	revocationstorememory "github.com/clawio/authentication/revocationstore/memory"
+	"github.com/clawio/entities"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *TestSuite) TestRevoke() {
+	suite.setupClientRegistry()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	token := suite.clientToken("test")
+	w := suite.revoke(url.Values{"token": {token}, "token_type_hint": {"access_token"}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	_, err := suite.Service.Authenticator.CreateUserFromToken(token)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevoke_withAccessTokenOfOtherClient() {
+	suite.setupClientRegistry()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	for _, token := range []string{suite.clientToken("other"), suite.userToken("test")} {
+		w := suite.revoke(url.Values{"token": {token}}, "test", "secret")
+		require.Equal(suite.T(), http.StatusOK, w.Code)
+		_, err := suite.Service.Authenticator.CreateUserFromToken(token)
+		require.Nil(suite.T(), err)
+	}
+}
+func (suite *TestSuite) TestRevoke_withoutRevocationStore() {
+	suite.setupClientRegistry()
+	w := suite.revoke(url.Values{"token": {suite.clientToken("test")}}, "test", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "unsupported_token_type")
+}
+func (suite *TestSuite) TestRevoke_withRefreshToken() {
+	suite.setupClientRegistry()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{User: &entities.User{Username: "test"}, ClientID: "test"})
+	require.Nil(suite.T(), err)
+	w := suite.revoke(url.Values{"token": {refreshToken}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "test", "")
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevoke_withRefreshTokenOfOtherClient() {
+	suite.setupClientRegistry()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{User: &entities.User{Username: "test"}, ClientID: "other"})
+	require.Nil(suite.T(), err)
+	w := suite.revoke(url.Values{"token": {refreshToken}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "other", "")
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestRevoke_withInvalidToken() {
+	suite.setupClientRegistry()
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	w := suite.revoke(url.Values{"token": {"invalid"}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+}
+func (suite *TestSuite) TestRevoke_withoutToken() {
+	suite.setupClientRegistry()
+	w := suite.revoke(url.Values{}, "test", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_request")
+}
+func (suite *TestSuite) TestRevoke_withoutClient() {
+	suite.setupClientRegistry()
+	w := suite.revoke(url.Values{"token": {"invalid"}}, "", "")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+	w = suite.revoke(url.Values{"token": {"invalid"}}, "test", "notsecret")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+}
+func (suite *TestSuite) TestLogout() {
+	suite.Service.Authenticator.RevocationStore = revocationstorememory.New()
+	suite.Service.RefreshTokenStore = refreshtokenstorememory.New(nil)
+	user := &entities.User{Username: "test"}
+	token, err := suite.Service.Authenticator.CreateToken(user)
+	require.Nil(suite.T(), err)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{User: user})
+	require.Nil(suite.T(), err)
+
+	w := suite.logout(token, `{"refresh_token":"`+refreshToken+`"}`)
+	require.Equal(suite.T(), http.StatusNoContent, w.Code)
+	_, err = suite.Service.Authenticator.CreateUserFromToken(token)
+	require.NotNil(suite.T(), err)
+	_, _, err = suite.Service.RefreshTokenStore.Rotate(refreshToken, "", "")
+	require.NotNil(suite.T(), err)
+
+	// the token does not work any more.
+	w = suite.logout(token, "")
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestLogout_withoutToken() {
+	w := suite.logout("", "")
+	require.Equal(suite.T(), http.StatusUnauthorized, w.Code)
+}
+func (suite *TestSuite) TestLogout_withInvalidJSON() {
+	token, err := suite.Service.Authenticator.CreateToken(&entities.User{Username: "test"})
+	require.Nil(suite.T(), err)
+	w := suite.logout(token, "{")
+	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
+}
+
+// clientToken returns an access token of the test user issued to the client.
+func (suite *TestSuite) clientToken(clientID string) string {
+	res, oErr := suite.Service.issueTokens(nil, &tokenGrant{User: &entities.User{Username: "test"}, ClientID: clientID})
+	require.Nil(suite.T(), oErr)
+	return res.AccessToken
+}
+func (suite *TestSuite) revoke(form url.Values, id, secret string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", revokeURL, strings.NewReader(form.Encode()))
+	require.Nil(suite.T(), err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id != "" {
+		r.SetBasicAuth(id, secret)
+	}
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+func (suite *TestSuite) logout(token, body string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", logoutURL, strings.NewReader(body))
+	require.Nil(suite.T(), err)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
@@ -1,14 +1,36 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/NYTimes/gizmo/config"
 	"github.com/clawio/authentication/authenticationcontroller"
-	"github.com/clawio/authentication/authenticationcontroller/memory"
-	"github.com/clawio/authentication/authenticationcontroller/simple"
+	// register the controllers of this repository.
+	_ "github.com/clawio/authentication/authenticationcontroller/chain"
+	_ "github.com/clawio/authentication/authenticationcontroller/file"
+	_ "github.com/clawio/authentication/authenticationcontroller/ldap"
+	_ "github.com/clawio/authentication/authenticationcontroller/memory"
+	_ "github.com/clawio/authentication/authenticationcontroller/simple"
+	"github.com/clawio/authentication/authorizationcodestore"
+	authorizationcodestorememory "github.com/clawio/authentication/authorizationcodestore/memory"
+	authorizationcodestoresimple "github.com/clawio/authentication/authorizationcodestore/simple"
+	"github.com/clawio/authentication/clientregistry"
+	"github.com/clawio/authentication/devicecodestore"
+	devicecodestorememory "github.com/clawio/authentication/devicecodestore/memory"
+	devicecodestoresimple "github.com/clawio/authentication/devicecodestore/simple"
+	"github.com/clawio/authentication/federation"
 	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/passwordpolicy"
+	"github.com/clawio/authentication/refreshtokenstore"
+	refreshtokenstorememory "github.com/clawio/authentication/refreshtokenstore/memory"
+	refreshtokenstoresimple "github.com/clawio/authentication/refreshtokenstore/simple"
+	"github.com/clawio/authentication/revocationstore"
+	revocationstorememory "github.com/clawio/authentication/revocationstore/memory"
+	revocationstoresimple "github.com/clawio/authentication/revocationstore/simple"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -19,6 +41,38 @@ type (
 	Service struct {
 		Config                   *Config
 		AuthenticationController authenticationcontroller.AuthenticationController
+		Authenticator            *lib.Authenticator
+
+		// RefreshTokenStore is nil when refresh tokens are disabled.
+		RefreshTokenStore refreshtokenstore.RefreshTokenStore
+
+		// ClientRegistry authenticates the clients of the OAuth 2.0
+		// endpoints. No client is accepted when it is nil.
+		ClientRegistry clientregistry.ClientRegistry
+
+		// AuthorizationCodeStore is nil when the
+		// authorization code flow is disabled.
+		AuthorizationCodeStore authorizationcodestore.AuthorizationCodeStore
+
+		// DeviceCodeStore is nil when the device
+		// authorization grant is disabled.
+		DeviceCodeStore devicecodestore.DeviceCodeStore
+
+		// Federation is nil when users can not sign
+		// in with upstream identity providers.
+		Federation *federation.Federation
+
+		// UserStore is nil when the AuthenticationController
+		// can not manage its users.
+		UserStore authenticationcontroller.UserStore
+
+		// PasswordPolicy validates the passwords chosen
+		// by the users, nil means the default policy.
+		PasswordPolicy *passwordpolicy.Policy
+
+		// userCodeAttempts limits the user codes
+		// tried on the device page.
+		userCodeAttempts attemptLimiter
 	}
 
 	// Config is a struct to contain all the needed
@@ -27,6 +81,34 @@ type (
 		Server                   *config.Server
 		General                  *GeneralConfig
 		AuthenticationController *AuthenticationControllerConfig
+
+		// RefreshTokenStore is optional, refresh tokens
+		// are not issued when it is nil.
+		RefreshTokenStore *RefreshTokenStoreConfig
+
+		// RevocationStore is optional, access tokens
+		// can not be revoked when it is nil.
+		RevocationStore *RevocationStoreConfig
+
+		// ClientRegistry is optional, the endpoints that
+		// require client credentials reject every request when it is nil.
+		ClientRegistry *ClientRegistryConfig
+
+		// AuthorizationCodeStore is optional, the authorization
+		// code flow is disabled when it is nil.
+		AuthorizationCodeStore *AuthorizationCodeStoreConfig
+
+		// DeviceCodeStore is optional, the device
+		// authorization grant is disabled when it is nil.
+		DeviceCodeStore *DeviceCodeStoreConfig
+
+		// Federation is optional, users can only sign in with
+		// the AuthenticationController when it is nil.
+		Federation *FederationConfig
+
+		// PasswordPolicy is optional, the default
+		// policy is used when it is nil.
+		PasswordPolicy *PasswordPolicyConfig
 	}
 
 	// GeneralConfig contains configuration parameters
@@ -34,17 +116,152 @@ type (
 	GeneralConfig struct {
 		BaseURL                  string
 		JWTKey, JWTSigningMethod string
+
+		// Issuer is the OpenID Connect issuer, the public URL of the
+		// service. It is required, the endpoints are published under it.
+		Issuer string
+
+		// JWTPrivateKeyFile and JWTPublicKeyFile are PEM encoded keys used
+		// instead of JWTKey for the RS*, PS*, ES* and EdDSA signing methods.
+		JWTPrivateKeyFile string
+		JWTPublicKeyFile  string
+
+		// JWTKeys is the rotation schedule of the signing keys.
+		// When set JWTKey, JWTSigningMethod and the key files are ignored.
+		JWTKeys []*JWTKeyConfig
+
+		// AdminUsers are the usernames granted the admin role, required
+		// by the user management endpoints. Federated users are never admins.
+		AdminUsers []string
+
+		// JWTTTL is the lifetime of the issued tokens in seconds.
+		// JWTLeeway is the clock skew in seconds tolerated when validating tokens.
+		JWTTTL    int
+		JWTLeeway int
 	}
 
-	// AuthenticationControllerConfig holds the configuration for
-	// an AuthenticationController.
+	// PasswordPolicyConfig holds the configuration of the policy of the
+	// passwords set by the users and the admins. MinLength is a number of
+	// characters and MaxLength a number of bytes, zero means the default.
+	PasswordPolicyConfig struct {
+		MinLength int
+		MaxLength int
+	}
+
+	// JWTKeyConfig holds the configuration of a key
+	// of the signing keys rotation schedule.
+	JWTKeyConfig struct {
+		// ID is the kid header of the tokens signed with the key.
+		ID            string
+		SigningMethod string
+
+		// Key is the shared secret for HMAC signing methods.
+		Key            string
+		PrivateKeyFile string
+		PublicKeyFile  string
+
+		// ActiveFrom is the RFC 3339 time the key becomes the signing key.
+		// Empty means the key is active since the beginning.
+		ActiveFrom string
+	}
+
+	// AuthenticationControllerConfig selects the AuthenticationController
+	// registered as Type, created with the Config object documented by
+	// the Config type of its package.
 	AuthenticationControllerConfig struct {
+		Type   string
+		Config json.RawMessage
+
+		// SimpleDriver, SimpleDSN and MemoryUsers are deprecated, they
+		// are the options of the simple and memory controllers in the
+		// previous format and are only used when Config is empty.
+		SimpleDriver string
+		SimpleDSN    string
+		MemoryUsers  json.RawMessage
+	}
+
+	// RefreshTokenStoreConfig holds the configuration for
+	// a RefreshTokenStore.
+	RefreshTokenStoreConfig struct {
+		Type string
+
+		// TTL is the lifetime of the refresh tokens in seconds.
+		TTL int
+		// MaxLifetime is the lifetime in seconds of the tokens issued from
+		// the same login, after which the user must sign in again.
+		MaxLifetime int
+
+		SimpleDriver string
+		SimpleDSN    string
+	}
+
+	// ClientRegistryConfig holds the configuration for
+	// a ClientRegistry.
+	ClientRegistryConfig struct {
+		Type string
+
+		SimpleDriver string
+		SimpleDSN    string
+
+		// MemoryClients secrets are encoded hashes,
+		// as printed by the hash-password command.
+		MemoryClients []*clientregistry.Client
+	}
+
+	// AuthorizationCodeStoreConfig holds the configuration for
+	// an AuthorizationCodeStore.
+	AuthorizationCodeStoreConfig struct {
+		Type string
+
+		// TTL is the lifetime of the codes in seconds.
+		TTL int
+
+		SimpleDriver string
+		SimpleDSN    string
+	}
+
+	// DeviceCodeStoreConfig holds the configuration for
+	// a DeviceCodeStore.
+	DeviceCodeStoreConfig struct {
 		Type string
 
+		// TTL is the lifetime of the codes in seconds.
+		// Interval is the minimum time between polls in seconds.
+		TTL      int
+		Interval int
+
+		// VerificationURI is the address of the verification page
+		// shown to the users. Empty means the one under General.Issuer.
+		VerificationURI string
+
 		SimpleDriver string
 		SimpleDSN    string
+	}
+
+	// FederationConfig holds the configuration of the
+	// upstream OpenID Connect identity providers.
+	FederationConfig struct {
+		Providers []*federation.ProviderConfig
+
+		// Provision creates or updates the users signed in upstream
+		// in the users table of the simple AuthenticationController.
+		Provision bool
+
+		// LoginTTL is the time in seconds to sign in upstream.
+		LoginTTL int
+
+		// RedirectURI is the callback address registered with the
+		// providers, the public URL of /federation/callback. It is required.
+		RedirectURI string
+	}
 
-		MemoryUsers []*memory.User
+	// RevocationStoreConfig holds the configuration for
+	// a RevocationStore.
+	RevocationStoreConfig struct {
+		Type string
+
+		SimpleDriver string
+		SimpleDSN    string
 	}
 )
 
@@ -60,43 +277,232 @@ func New(cfg *Config) (*Service, error) {
 	if cfg.General == nil {
 		return nil, errors.New("config.General is nil")
 	}
+	if cfg.General.Issuer == "" {
+		return nil, errors.New("config.General.Issuer is empty, set it to the public URL of the service")
+	}
 
-	var authenticationController authenticationcontroller.AuthenticationController
-	switch cfg.AuthenticationController.Type {
-	case "simple":
-		a, err := getSimpleAuthenticationController(cfg)
+	authenticator, err := getAuthenticator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	controllerConfig, err := getAuthenticationControllerConfig(cfg.AuthenticationController)
+	if err != nil {
+		return nil, err
+	}
+	authenticationController, err := authenticationcontroller.New(cfg.AuthenticationController.Type, controllerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var refreshTokenStore refreshtokenstore.RefreshTokenStore
+	if cfg.RefreshTokenStore != nil {
+		refreshTokenStore, err = getRefreshTokenStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clientRegistry clientregistry.ClientRegistry
+	if cfg.ClientRegistry != nil {
+		clientRegistry, err = getClientRegistry(cfg)
 		if err != nil {
 			return nil, err
 		}
-		authenticationController = a
-	case "memory":
-		authenticationController = getMemoryAuthenticationController(cfg)
-	default:
-		return nil, errors.New("authenticationController type " + cfg.AuthenticationController.Type + " does not exist")
 	}
 
+	var authorizationCodeStore authorizationcodestore.AuthorizationCodeStore
+	if cfg.AuthorizationCodeStore != nil {
+		authorizationCodeStore, err = getAuthorizationCodeStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var deviceCodeStore devicecodestore.DeviceCodeStore
+	if cfg.DeviceCodeStore != nil {
+		deviceCodeStore, err = getDeviceCodeStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fed *federation.Federation
+	if cfg.Federation != nil {
+		fed, err = getFederation(cfg, authenticationController)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// controllers that manage their users enable the user management endpoints.
+	userStore, _ := authenticationController.(authenticationcontroller.UserStore)
+
 	return &Service{
-		Config: cfg,
+		Config:                   cfg,
 		AuthenticationController: authenticationController,
+		Authenticator:            authenticator,
+		RefreshTokenStore:        refreshTokenStore,
+		ClientRegistry:           clientRegistry,
+		AuthorizationCodeStore:   authorizationCodeStore,
+		DeviceCodeStore:          deviceCodeStore,
+		Federation:               fed,
+		UserStore:                userStore,
+		PasswordPolicy:           getPasswordPolicy(cfg),
 	}, nil
 }
 
-func getSimpleAuthenticationController(cfg *Config) (authenticationcontroller.AuthenticationController, error) {
-	authenticator := lib.NewAuthenticator(cfg.General.JWTKey, cfg.General.JWTSigningMethod)
-	opts := &simple.Options{
-		Driver:        cfg.AuthenticationController.SimpleDriver,
-		DSN:           cfg.AuthenticationController.SimpleDSN,
-		Authenticator: authenticator,
+// getAuthenticationControllerConfig returns the Config of the controller,
+// built from the deprecated options of the previous format when it is empty.
+func getAuthenticationControllerConfig(cfg *AuthenticationControllerConfig) (json.RawMessage, error) {
+	deprecated := cfg.SimpleDriver != "" || cfg.SimpleDSN != "" || len(cfg.MemoryUsers) > 0
+	if !deprecated {
+		return cfg.Config, nil
+	}
+	if len(cfg.Config) > 0 {
+		return nil, errors.New("config.AuthenticationController has both Config and the deprecated SimpleDriver, SimpleDSN or MemoryUsers, move them into Config")
+	}
+	var config interface{}
+	switch cfg.Type {
+	case "simple":
+		config = map[string]string{"Driver": cfg.SimpleDriver, "DSN": cfg.SimpleDSN}
+	case "memory":
+		users := cfg.MemoryUsers
+		if len(users) == 0 {
+			users = json.RawMessage("[]")
+		}
+		config = map[string]json.RawMessage{"Users": users}
+	default:
+		return nil, errors.New("config.AuthenticationController of type " + cfg.Type + " does not accept SimpleDriver, SimpleDSN or MemoryUsers, set its Config instead")
+	}
+	log.Printf("config.AuthenticationController: SimpleDriver, SimpleDSN and MemoryUsers are deprecated, set the Config of the %s controller instead", cfg.Type)
+	return json.Marshal(config)
+}
+
+func getFederation(cfg *Config, authenticationController authenticationcontroller.AuthenticationController) (*federation.Federation, error) {
+	if cfg.Federation.RedirectURI == "" {
+		return nil, errors.New("config.Federation.RedirectURI is empty, set it to the public URL of /federation/callback")
+	}
+	opts := &federation.Options{
+		Providers: cfg.Federation.Providers,
+		LoginTTL:  time.Duration(cfg.Federation.LoginTTL) * time.Second,
+	}
+	if cfg.Federation.Provision {
+		provisioner, ok := authenticationController.(federation.Provisioner)
+		if !ok {
+			return nil, errors.New("authenticationController type " + cfg.AuthenticationController.Type + " can not provision users")
+		}
+		opts.Provisioner = provisioner
+	}
+	return federation.New(opts)
+}
+
+func getDeviceCodeStore(cfg *Config) (devicecodestore.DeviceCodeStore, error) {
+	ttl := time.Duration(cfg.DeviceCodeStore.TTL) * time.Second
+	interval := time.Duration(cfg.DeviceCodeStore.Interval) * time.Second
+	switch cfg.DeviceCodeStore.Type {
+	case "simple":
+		opts := &devicecodestoresimple.Options{
+			Driver:   cfg.DeviceCodeStore.SimpleDriver,
+			DSN:      cfg.DeviceCodeStore.SimpleDSN,
+			TTL:      ttl,
+			Interval: interval,
+		}
+		return devicecodestoresimple.New(opts)
+	case "memory":
+		return devicecodestorememory.New(&devicecodestorememory.Options{TTL: ttl, Interval: interval}), nil
+	default:
+		return nil, errors.New("deviceCodeStore type " + cfg.DeviceCodeStore.Type + " does not exist")
 	}
-	return simple.New(opts)
 }
-func getMemoryAuthenticationController(cfg *Config) authenticationcontroller.AuthenticationController {
-	authenticator := lib.NewAuthenticator(cfg.General.JWTKey, cfg.General.JWTSigningMethod)
-	opts := &memory.Options{
-		Users:         cfg.AuthenticationController.MemoryUsers,
-		Authenticator: authenticator,
+
+func getAuthorizationCodeStore(cfg *Config) (authorizationcodestore.AuthorizationCodeStore, error) {
+	ttl := time.Duration(cfg.AuthorizationCodeStore.TTL) * time.Second
+	switch cfg.AuthorizationCodeStore.Type {
+	case "simple":
+		opts := &authorizationcodestoresimple.Options{
+			Driver: cfg.AuthorizationCodeStore.SimpleDriver,
+			DSN:    cfg.AuthorizationCodeStore.SimpleDSN,
+			TTL:    ttl,
+		}
+		return authorizationcodestoresimple.New(opts)
+	case "memory":
+		return authorizationcodestorememory.New(&authorizationcodestorememory.Options{TTL: ttl}), nil
+	default:
+		return nil, errors.New("authorizationCodeStore type " + cfg.AuthorizationCodeStore.Type + " does not exist")
+	}
+}
+
+func getRefreshTokenStore(cfg *Config) (refreshtokenstore.RefreshTokenStore, error) {
+	ttl := time.Duration(cfg.RefreshTokenStore.TTL) * time.Second
+	maxLifetime := time.Duration(cfg.RefreshTokenStore.MaxLifetime) * time.Second
+	switch cfg.RefreshTokenStore.Type {
+	case "simple":
+		opts := &refreshtokenstoresimple.Options{
+			Driver:      cfg.RefreshTokenStore.SimpleDriver,
+			DSN:         cfg.RefreshTokenStore.SimpleDSN,
+			TTL:         ttl,
+			MaxLifetime: maxLifetime,
+		}
+		return refreshtokenstoresimple.New(opts)
+	case "memory":
+		return refreshtokenstorememory.New(&refreshtokenstorememory.Options{TTL: ttl, MaxLifetime: maxLifetime}), nil
+	default:
+		return nil, errors.New("refreshTokenStore type " + cfg.RefreshTokenStore.Type + " does not exist")
+	}
+}
+
+func getPasswordPolicy(cfg *Config) *passwordpolicy.Policy {
+	if cfg.PasswordPolicy == nil {
+		return passwordpolicy.New(nil)
+	}
+	return passwordpolicy.New(&passwordpolicy.Options{
+		MinLength: cfg.PasswordPolicy.MinLength,
+		MaxLength: cfg.PasswordPolicy.MaxLength,
+	})
+}
+
+func getAuthenticator(cfg *Config) (*lib.Authenticator, error) {
+	ttl := lib.DefaultTTL
+	if cfg.General.JWTTTL > 0 {
+		ttl = time.Duration(cfg.General.JWTTTL) * time.Second
+	}
+	leeway := time.Duration(cfg.General.JWTLeeway) * time.Second
+
+	keys, err := getScheduledKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// retired keys verify tokens until the last ones they signed expire.
+	keySet, err := lib.NewRotatingKeySet(keys, ttl+leeway)
+	if err != nil {
+		return nil, err
+	}
+	authenticator := lib.NewAuthenticatorWithKeySet(keySet)
+	authenticator.TTL = ttl
+	authenticator.Leeway = leeway
+	if cfg.RevocationStore != nil {
+		authenticator.RevocationStore, err = getRevocationStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return authenticator, nil
+}
+
+func getRevocationStore(cfg *Config) (revocationstore.RevocationStore, error) {
+	switch cfg.RevocationStore.Type {
+	case "simple":
+		opts := &revocationstoresimple.Options{
+			Driver: cfg.RevocationStore.SimpleDriver,
+			DSN:    cfg.RevocationStore.SimpleDSN,
+		}
+		return revocationstoresimple.New(opts)
+	case "memory":
+		return revocationstorememory.New(), nil
+	default:
+		return nil, errors.New("revocationStore type " + cfg.RevocationStore.Type + " does not exist")
 	}
-	return memory.New(opts)
 }
 
 // Prefix returns the string prefix used for all endpoints within
@@ -121,5 +527,53 @@ func (s *Service) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/token": {
 			"POST": prometheus.InstrumentHandlerFunc("/token", s.Token),
 		},
+		"/revoke": {
+			"POST": prometheus.InstrumentHandlerFunc("/revoke", s.Revoke),
+		},
+		"/authorize": {
+			"GET":  prometheus.InstrumentHandlerFunc("/authorize", s.Authorize),
+			"POST": prometheus.InstrumentHandlerFunc("/authorize", s.Authorize),
+		},
+		"/device/authorize": {
+			"POST": prometheus.InstrumentHandlerFunc("/device/authorize", s.DeviceAuthorize),
+		},
+		"/device": {
+			"GET":  prometheus.InstrumentHandlerFunc("/device", s.Device),
+			"POST": prometheus.InstrumentHandlerFunc("/device", s.Device),
+		},
+		"/federation/login": {
+			"GET": prometheus.InstrumentHandlerFunc("/federation/login", s.FederationLogin),
+		},
+		"/federation/callback": {
+			"GET": prometheus.InstrumentHandlerFunc("/federation/callback", s.FederationCallback),
+		},
+		"/introspect": {
+			"POST": prometheus.InstrumentHandlerFunc("/introspect", s.Introspect),
+		},
+		"/logout": {
+			"POST": prometheus.InstrumentHandlerFunc("/logout", s.Authenticator.JWTHandlerFunc(s.Logout)),
+		},
+		"/admin/users": {
+			"GET":  prometheus.InstrumentHandlerFunc("/admin/users", s.adminHandlerFunc(s.ListUsers)),
+			"POST": prometheus.InstrumentHandlerFunc("/admin/users", s.adminHandlerFunc(s.CreateUser)),
+		},
+		"/admin/users/{username}": {
+			"GET":    prometheus.InstrumentHandlerFunc("/admin/users/{username}", s.adminHandlerFunc(s.GetUser)),
+			"PATCH":  prometheus.InstrumentHandlerFunc("/admin/users/{username}", s.adminHandlerFunc(s.UpdateUser)),
+			"DELETE": prometheus.InstrumentHandlerFunc("/admin/users/{username}", s.adminHandlerFunc(s.DeleteUser)),
+		},
+		"/password": {
+			"POST": prometheus.InstrumentHandlerFunc("/password", s.Authenticator.JWTHandlerFunc(s.ChangePassword)),
+		},
+		"/userinfo": {
+			"GET":  prometheus.InstrumentHandlerFunc("/userinfo", s.UserInfo),
+			"POST": prometheus.InstrumentHandlerFunc("/userinfo", s.UserInfo),
+		},
+		"/.well-known/openid-configuration": {
+			"GET": prometheus.InstrumentHandlerFunc("/.well-known/openid-configuration", s.OpenIDConfiguration),
+		},
+		"/jwks.json": {
+			"GET": prometheus.InstrumentHandlerFunc("/jwks.json", s.JWKS),
+		},
 	}
 }
@@ -1,21 +1,36 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/NYTimes/gizmo/config"
 	"github.com/NYTimes/gizmo/server"
 	mock_authenticationcontroller "github.com/clawio/authentication/authenticationcontroller/mock"
+	"github.com/clawio/authentication/lib"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
 var (
-	tokenURL   string
-	metricsURL string
+	tokenURL      string
+	metricsURL    string
+	jwksURL       string
+	revokeURL     string
+	logoutURL     string
+	introspectURL string
+	authorizeURL  string
+	deviceURL     string
+
+	deviceAuthorizeURL string
 )
 
 type TestSuite struct {
@@ -23,6 +38,8 @@ type TestSuite struct {
 	MockAuthenticationController *mock_authenticationcontroller.AuthenticationController
 	Service                      *Service
 	Server                       *server.SimpleServer
+	// dir holds the databases of the test.
+	dir string
 }
 
 func Test(t *testing.T) {
@@ -30,14 +47,21 @@ func Test(t *testing.T) {
 }
 
 func (suite *TestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "clawio-service")
+	require.Nil(suite.T(), err)
+	suite.dir = dir
+
 	mockAuthenticationController := &mock_authenticationcontroller.AuthenticationController{}
 
 	svc := &Service{}
 	svc.AuthenticationController = mockAuthenticationController
 	cfg := &Config{
-		General: &GeneralConfig{BaseURL: "/"},
+		General: &GeneralConfig{BaseURL: "/", Issuer: "http://example.com"},
 	}
 	svc.Config = cfg
+	authenticator, err := getAuthenticator(cfg)
+	require.Nil(suite.T(), err)
+	svc.Authenticator = authenticator
 
 	suite.Service = svc
 	suite.MockAuthenticationController = mockAuthenticationController
@@ -51,40 +75,292 @@ func (suite *TestSuite) SetupTest() {
 	// set testing urls
 	tokenURL = path.Join(svc.Config.General.BaseURL, "/token")
 	metricsURL = path.Join(svc.Config.General.BaseURL, "/metrics")
+	jwksURL = path.Join(svc.Config.General.BaseURL, "/jwks.json")
+	revokeURL = path.Join(svc.Config.General.BaseURL, "/revoke")
+	logoutURL = path.Join(svc.Config.General.BaseURL, "/logout")
+	introspectURL = path.Join(svc.Config.General.BaseURL, "/introspect")
+	authorizeURL = path.Join(svc.Config.General.BaseURL, "/authorize")
+	deviceURL = path.Join(svc.Config.General.BaseURL, "/device")
+	deviceAuthorizeURL = path.Join(svc.Config.General.BaseURL, "/device/authorize")
 
 }
+func (suite *TestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
 
+func (suite *TestSuite) TestNew_withoutIssuer() {
+	cfg := &Config{
+		General:                  &GeneralConfig{},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
 func (suite *TestSuite) TestNew_withSimple() {
 	authCfg := &AuthenticationControllerConfig{
-		Type:         "simple",
-		SimpleDriver: "sqlite3",
-		SimpleDSN:    "/tmp/userstore.db",
+		Type:   "simple",
+		Config: json.RawMessage(`{"Driver": "sqlite3", "DSN": "` + filepath.Join(suite.dir, "userstore.db") + `"}`),
 	}
 	cfg := &Config{
-		General:                  &GeneralConfig{},
+		General:                  &GeneralConfig{Issuer: issuer},
 		AuthenticationController: authCfg,
 	}
 	_, err := New(cfg)
 	require.Nil(suite.T(), err)
 }
 
+func (suite *TestSuite) TestNew_withSimpleAndBadPasswordHashAlgorithm() {
+	authCfg := &AuthenticationControllerConfig{
+		Type:   "simple",
+		Config: json.RawMessage(`{"Driver": "sqlite3", "DSN": "` + filepath.Join(suite.dir, "userstore.db") + `", "PasswordHashAlgorithm": "md5"}`),
+	}
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: authCfg,
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+
 func (suite *TestSuite) TestNew_withMemory() {
 	authCfg := &AuthenticationControllerConfig{
 		Type: "memory",
 	}
 	cfg := &Config{
-		General:                  &GeneralConfig{},
+		General:                  &GeneralConfig{Issuer: issuer},
 		AuthenticationController: authCfg,
 	}
 	_, err := New(cfg)
 	require.Nil(suite.T(), err)
 }
+func (suite *TestSuite) TestNew_withLDAP() {
+	authCfg := &AuthenticationControllerConfig{
+		Type:   "ldap",
+		Config: json.RawMessage(`{"URL": "ldap://127.0.0.1:389", "BaseDN": "dc=example,dc=org"}`),
+	}
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: authCfg,
+	}
+	_, err := New(cfg)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withLDAPAndBadCAFile() {
+	authCfg := &AuthenticationControllerConfig{
+		Type:   "ldap",
+		Config: json.RawMessage(`{"URL": "ldaps://127.0.0.1:636", "BaseDN": "dc=example,dc=org", "CAFile": "/tmp/thisnotexists.pem"}`),
+	}
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: authCfg,
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withFile() {
+	f, err := ioutil.TempFile("", "htpasswd")
+	require.Nil(suite.T(), err)
+	defer os.Remove(f.Name())
+	// bcrypt hash of "hashed"
+	_, err = f.WriteString("hashed:$2a$04$De314cF4i52YR6Ybpbx5r.kF3b9t56JnOYpXwQ0CGmyXlwmXALlpG\n")
+	require.Nil(suite.T(), err)
+	f.Close()
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{
+			Type:   "file",
+			Config: json.RawMessage(`{"Path": "` + f.Name() + `"}`),
+		},
+	}
+	_, err = New(cfg)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadFile() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{
+			Type:   "file",
+			Config: json.RawMessage(`{"Path": "/tmp/thisnotexists"}`),
+		},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withChain() {
+	authCfg := &AuthenticationControllerConfig{
+		Type: "chain",
+		Config: json.RawMessage(`{
+			"Policy": "first-success",
+			"Hops": [
+				{"Name": "ldap", "Pattern": "^[a-z]+$", "Type": "ldap", "Config": {"URL": "ldap://127.0.0.1:389", "BaseDN": "dc=example,dc=org"}},
+				{"Name": "memory", "Type": "memory"}
+			]
+		}`),
+	}
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: authCfg,
+	}
+	_, err := New(cfg)
+	require.Nil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withBadChain() {
+	for _, hops := range []string{
+		`[]`,
+		`[{"Name": "memory"}]`,
+		`[{"Name": "memory", "Pattern": "(", "Type": "memory"}]`,
+		`[{"Name": "other", "Type": "other"}]`,
+	} {
+		cfg := &Config{
+			General: &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{
+				Type:   "chain",
+				Config: json.RawMessage(`{"Hops": ` + hops + `}`),
+			},
+		}
+		_, err := New(cfg)
+		require.NotNil(suite.T(), err)
+	}
+}
+func (suite *TestSuite) TestNew_withUnknownControllerOption() {
+	// options of the previous configuration format are rejected in Config.
+	cfg := &Config{
+		General: &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{
+			Type:   "simple",
+			Config: json.RawMessage(`{"SimpleDSN": "/tmp/userstore.db"}`),
+		},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withDeprecatedControllerOptions() {
+	// configurations of the previous format are still accepted,
+	// with the options of the other controllers ignored.
+	users := json.RawMessage(`[{"username": "test", "password": "test"}]`)
+	for _, authCfg := range []*AuthenticationControllerConfig{
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "userstore.db"), MemoryUsers: users},
+		{Type: "memory", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "userstore.db"), MemoryUsers: users},
+	} {
+		svc, err := New(&Config{General: &GeneralConfig{Issuer: issuer}, AuthenticationController: authCfg})
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.AuthenticationController)
+	}
+	svc, err := New(&Config{General: &GeneralConfig{Issuer: issuer}, AuthenticationController: &AuthenticationControllerConfig{Type: "memory", MemoryUsers: users}})
+	require.Nil(suite.T(), err)
+	_, err = svc.AuthenticationController.Authenticate(context.Background(), "test", "test")
+	require.Nil(suite.T(), err)
+
+	for _, authCfg := range []*AuthenticationControllerConfig{
+		{Type: "memory", Config: json.RawMessage(`{"Users": []}`), MemoryUsers: users},
+		{Type: "ldap", SimpleDSN: filepath.Join(suite.dir, "userstore.db")},
+	} {
+		_, err := New(&Config{General: &GeneralConfig{Issuer: issuer}, AuthenticationController: authCfg})
+		require.NotNil(suite.T(), err)
+	}
+}
+func (suite *TestSuite) TestNew_withRefreshTokenStore() {
+	for _, storeCfg := range []*RefreshTokenStoreConfig{
+		{Type: "memory"},
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "refreshtokenstore.db")},
+	} {
+		cfg := &Config{
+			General:                  &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+			RefreshTokenStore:        storeCfg,
+		}
+		svc, err := New(cfg)
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.RefreshTokenStore)
+	}
+}
+func (suite *TestSuite) TestNew_withBadRefreshTokenStore() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		RefreshTokenStore:        &RefreshTokenStoreConfig{Type: "notfound"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withRevocationStore() {
+	for _, storeCfg := range []*RevocationStoreConfig{
+		{Type: "memory"},
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "revocationstore.db")},
+	} {
+		cfg := &Config{
+			General:                  &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+			RevocationStore:          storeCfg,
+		}
+		svc, err := New(cfg)
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.Authenticator.RevocationStore)
+	}
+}
+func (suite *TestSuite) TestNew_withBadRevocationStore() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		RevocationStore:          &RevocationStoreConfig{Type: "notfound"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withClientRegistry() {
+	for _, registryCfg := range []*ClientRegistryConfig{
+		{Type: "memory"},
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "clientregistry.db")},
+	} {
+		cfg := &Config{
+			General:                  &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+			ClientRegistry:           registryCfg,
+		}
+		svc, err := New(cfg)
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.ClientRegistry)
+	}
+}
+func (suite *TestSuite) TestNew_withBadClientRegistry() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		ClientRegistry:           &ClientRegistryConfig{Type: "notfound"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestNew_withAuthorizationCodeStore() {
+	for _, storeCfg := range []*AuthorizationCodeStoreConfig{
+		{Type: "memory"},
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "authorizationcodestore.db")},
+	} {
+		cfg := &Config{
+			General:                  &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+			AuthorizationCodeStore:   storeCfg,
+		}
+		svc, err := New(cfg)
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.AuthorizationCodeStore)
+	}
+}
+func (suite *TestSuite) TestNew_withBadAuthorizationCodeStore() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		AuthorizationCodeStore:   &AuthorizationCodeStoreConfig{Type: "notfound"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
 func (suite *TestSuite) TestNew_withBadController() {
 	authCfg := &AuthenticationControllerConfig{
 		Type: "notfound",
 	}
 	cfg := &Config{
-		General:                  &GeneralConfig{},
+		General:                  &GeneralConfig{Issuer: issuer},
 		AuthenticationController: authCfg,
 	}
 	_, err := New(cfg)
@@ -96,7 +372,7 @@ func (suite *TestSuite) TestNew_withNilConfig() {
 }
 func (suite *TestSuite) TestNew_withNilGeneralConfig() {
 	cfg := &Config{
-		General: &GeneralConfig{},
+		General: &GeneralConfig{Issuer: issuer},
 	}
 	_, err := New(cfg)
 	require.NotNil(suite.T(), err)
@@ -111,9 +387,8 @@ func (suite *TestSuite) TestNew_withNilAuthenticationControllerConfig() {
 }
 func (suite *TestSuite) TestNew_withNilWithBadDSN() {
 	authCfg := &AuthenticationControllerConfig{
-		Type:         "simple",
-		SimpleDriver: "sqlite3",
-		SimpleDSN:    "/this/does/not/exists/userstore.db",
+		Type:   "simple",
+		Config: json.RawMessage(`{"Driver": "sqlite3", "DSN": "/this/does/not/exists/userstore.db"}`),
 	}
 	cfg := &Config{
 		Server: nil,
@@ -122,6 +397,40 @@ func (suite *TestSuite) TestNew_withNilWithBadDSN() {
 	_, err := New(cfg)
 	require.NotNil(suite.T(), err)
 }
+func (suite *TestSuite) TestgetAuthenticator() {
+	cfg := &Config{
+		General: &GeneralConfig{JWTTTL: 60, JWTLeeway: 5},
+	}
+	authenticator, err := getAuthenticator(cfg)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), time.Minute, authenticator.TTL)
+	require.Equal(suite.T(), 5*time.Second, authenticator.Leeway)
+}
+func (suite *TestSuite) TestgetAuthenticator_withDefaultTTL() {
+	cfg := &Config{
+		General: &GeneralConfig{Issuer: issuer},
+	}
+	authenticator, err := getAuthenticator(cfg)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), lib.DefaultTTL, authenticator.TTL)
+}
+func (suite *TestSuite) TestgetAuthenticator_withBadKeyFile() {
+	cfg := &Config{
+		General: &GeneralConfig{
+			JWTSigningMethod:  "RS256",
+			JWTPrivateKeyFile: "/this/does/not/exists/key.pem",
+		},
+	}
+	_, err := getAuthenticator(cfg)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestgetAuthenticator_withAsymmetricMethodAndNoKeyFiles() {
+	cfg := &Config{
+		General: &GeneralConfig{JWTSigningMethod: "ES256"},
+	}
+	_, err := getAuthenticator(cfg)
+	require.NotNil(suite.T(), err)
+}
 func (suite *TestSuite) TestMetrics() {
 	r, err := http.NewRequest("GET", metricsURL, nil)
 	require.Nil(suite.T(), err)
@@ -129,3 +438,27 @@ func (suite *TestSuite) TestMetrics() {
 	suite.Server.ServeHTTP(w, r)
 	require.Equal(suite.T(), http.StatusOK, w.Code)
 }
+func (suite *TestSuite) TestNew_withDeviceCodeStore() {
+	for _, storeCfg := range []*DeviceCodeStoreConfig{
+		{Type: "memory"},
+		{Type: "simple", SimpleDriver: "sqlite3", SimpleDSN: filepath.Join(suite.dir, "devicecodestore.db")},
+	} {
+		cfg := &Config{
+			General:                  &GeneralConfig{Issuer: issuer},
+			AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+			DeviceCodeStore:          storeCfg,
+		}
+		svc, err := New(cfg)
+		require.Nil(suite.T(), err)
+		require.NotNil(suite.T(), svc.DeviceCodeStore)
+	}
+}
+func (suite *TestSuite) TestNew_withBadDeviceCodeStore() {
+	cfg := &Config{
+		General:                  &GeneralConfig{Issuer: issuer},
+		AuthenticationController: &AuthenticationControllerConfig{Type: "memory"},
+		DeviceCodeStore:          &DeviceCodeStoreConfig{Type: "thisnotexists"},
+	}
+	_, err := New(cfg)
+	require.NotNil(suite.T(), err)
+}
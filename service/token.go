@@ -3,49 +3,391 @@ package service
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/clawio/authentication/authorizationcodestore"
+	"github.com/clawio/authentication/devicecodestore"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/refreshtokenstore"
 	"github.com/clawio/codes"
+	"github.com/clawio/entities"
 )
 
+// deviceCodeGrantType is the grant_type of the device authorization grant (RFC 8628).
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
 type (
 	// AuthenticateRequest specifies the data received by the Authenticate endpoint.
+	// GrantType is password to authenticate with an username and a password,
+	// refresh_token to exchange a refresh token, authorization_code to redeem
+	// a code issued by the Authorize endpoint, client_credentials for clients
+	// that authenticate as themselves or urn:ietf:params:oauth:grant-type:device_code
+	// to poll a code issued by the DeviceAuthorize endpoint. Scope is the optional
+	// space separated list of scopes requested for the token.
 	AuthenticateRequest struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		GrantType    string `json:"grant_type"`
+		ClientID     string `json:"client_id"`
+		Username     string `json:"username"`
+		Password     string `json:"password"`
+		RefreshToken string `json:"refresh_token"`
+		Code         string `json:"code"`
+		RedirectURI  string `json:"redirect_uri"`
+		CodeVerifier string `json:"code_verifier"`
+		DeviceCode   string `json:"device_code"`
+		Scope        string `json:"scope"`
 	}
 
 	// AuthenticateResponse specifies the data returned from the Authenticate endpoint.
+	// RefreshToken is empty when refresh tokens are disabled. IDToken is
+	// only set when a client is granted the openid scope.
 	AuthenticateResponse struct {
-		AccessToken string `json:"access_token"`
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope,omitempty"`
+		IDToken      string `json:"id_token,omitempty"`
 	}
 )
 
-// Authenticate authenticates an user using an username and a password.
+// Authenticate authenticates an user using an username and a password,
+// or a refresh token.
+// Form encoded requests follow RFC 6749: grant_type is required and errors
+// are returned in the OAuth 2.0 format. JSON requests default to the password
+// grant and keep the original error format.
+// Clients may authenticate with HTTP Basic authentication or the client_id
+// and client_secret parameters, and the refresh tokens issued to them can
+// only be exchanged by the same client. Public clients only send client_id.
 func (s *Service) Token(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	form := isFormRequest(r)
 	authReq := &AuthenticateRequest{}
-	if err := json.NewDecoder(r.Body).Decode(authReq); err != nil {
-		e := codes.NewErr(codes.BadInputData, "")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(e)
-		return
+	if form {
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "body is not form encoded"))
+			return
+		}
+		authReq.GrantType = r.PostForm.Get("grant_type")
+		authReq.ClientID = r.PostForm.Get("client_id")
+		authReq.Username = r.PostForm.Get("username")
+		authReq.Password = r.PostForm.Get("password")
+		authReq.RefreshToken = r.PostForm.Get("refresh_token")
+		authReq.Code = r.PostForm.Get("code")
+		authReq.RedirectURI = r.PostForm.Get("redirect_uri")
+		authReq.CodeVerifier = r.PostForm.Get("code_verifier")
+		authReq.DeviceCode = r.PostForm.Get("device_code")
+		authReq.Scope = r.PostForm.Get("scope")
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(authReq); err != nil {
+			e := codes.NewErr(codes.BadInputData, "")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(e)
+			return
+		}
+		if authReq.GrantType == "" {
+			authReq.GrantType = "password"
+		}
 	}
-	token, err := s.AuthenticationController.Authenticate(authReq.Username, authReq.Password)
-	if err != nil {
-		s.handleTokenError(err, w)
+
+	res, oErr := s.token(r, authReq, form)
+	if oErr != nil {
+		if form {
+			writeOAuthError(w, oErr)
+		} else {
+			s.handleTokenError(oErr, w)
+		}
 		return
 	}
-	res := &AuthenticateResponse{AccessToken: token}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
-func (s *Service) handleTokenError(err error, w http.ResponseWriter) {
-	e := codes.NewErr(codes.BadInputData, "user or password do not match")
-	w.WriteHeader(http.StatusBadRequest)
+func (s *Service) token(r *http.Request, authReq *AuthenticateRequest, form bool) (*AuthenticateResponse, *OAuthError) {
+	clientID, oErr := s.tokenClientID(r, authReq)
+	if oErr != nil {
+		return nil, oErr
+	}
+
+	switch authReq.GrantType {
+	case "password":
+		if form && (authReq.Username == "" || authReq.Password == "") {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "username and password are required")
+		}
+		return s.passwordGrant(r, authReq, clientID)
+	case "refresh_token":
+		if form && authReq.RefreshToken == "" {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		}
+		return s.refreshTokenGrant(r, authReq, clientID)
+	case "client_credentials":
+		return s.clientCredentialsGrant(authReq, clientID)
+	case "authorization_code":
+		if form && authReq.Code == "" {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "code is required")
+		}
+		return s.authorizationCodeGrant(r, authReq, clientID)
+	case deviceCodeGrantType:
+		if form && authReq.DeviceCode == "" {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "device_code is required")
+		}
+		return s.deviceCodeGrant(r, authReq, clientID)
+	case "":
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		return nil, newOAuthError(http.StatusBadRequest, "unsupported_grant_type", "grant_type "+authReq.GrantType+" is not supported")
+	}
+}
+
+// tokenClientID returns the id of the client of a token request: the
+// authenticated client or, for public clients, the client_id parameter.
+// Confidential clients must authenticate. The id is empty if the
+// request does not name a client.
+func (s *Service) tokenClientID(r *http.Request, authReq *AuthenticateRequest) (string, *OAuthError) {
+	invalidClient := newOAuthError(http.StatusUnauthorized, "invalid_client", "client id or secret do not match")
+	if hasClientCredentials(r) {
+		client, err := s.authenticateClient(r)
+		if err != nil {
+			return "", invalidClient
+		}
+		if authReq.ClientID != "" && authReq.ClientID != client.ID {
+			return "", invalidClient
+		}
+		return client.ID, nil
+	}
+	if authReq.ClientID == "" {
+		return "", nil
+	}
+	if s.ClientRegistry == nil {
+		return "", invalidClient
+	}
+	client, err := s.ClientRegistry.FindByID(authReq.ClientID)
+	if err != nil || !client.IsPublic() {
+		return "", invalidClient
+	}
+	return client.ID, nil
+}
+
+func (s *Service) passwordGrant(r *http.Request, authReq *AuthenticateRequest, clientID string) (*AuthenticateResponse, *OAuthError) {
+	if oErr := s.checkClientScope(authReq.Scope, clientID); oErr != nil {
+		return nil, oErr
+	}
+	user, err := s.authenticateUser(r.Context(), authReq.Username, authReq.Password)
+	if err == errFactorsRequired {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", err.Error())
+	}
+	if err != nil {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "user or password do not match")
+	}
+	return s.issueTokens(r, &tokenGrant{
+		User:     user,
+		ClientID: clientID,
+		Scope:    authReq.Scope,
+		IDToken:  &lib.IDToken{Audience: clientID, AuthTime: time.Now()},
+	})
+}
+
+func (s *Service) refreshTokenGrant(r *http.Request, authReq *AuthenticateRequest, clientID string) (*AuthenticateResponse, *OAuthError) {
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "refresh token is invalid")
+	if s.RefreshTokenStore == nil {
+		return nil, invalidGrant
+	}
+	// the scope can be narrowed but not extended.
+	grant, refreshToken, err := s.RefreshTokenStore.Rotate(authReq.RefreshToken, clientID, authReq.Scope)
+	if err == refreshtokenstore.ErrInvalidToken || err == refreshtokenstore.ErrReusedToken {
+		return nil, invalidGrant
+	}
+	if err == refreshtokenstore.ErrInvalidScope {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+	if err != nil {
+		return nil, newServerError()
+	}
+	scope := grant.Scope
+	if authReq.Scope != "" {
+		scope = authReq.Scope
+	}
+	return s.issueTokens(r, &tokenGrant{
+		User:         grant.User,
+		ClientID:     grant.ClientID,
+		Scope:        scope,
+		RefreshToken: refreshToken,
+	})
+}
+
+// authorizationCodeGrant redeems a code issued by the Authorize endpoint.
+// The code is only valid for the client it was issued to, with the same
+// redirect_uri and the PKCE code_verifier of the authorization request.
+func (s *Service) authorizationCodeGrant(r *http.Request, authReq *AuthenticateRequest, clientID string) (*AuthenticateResponse, *OAuthError) {
+	if clientID == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "client_id is required")
+	}
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "authorization code is invalid")
+	if s.AuthorizationCodeStore == nil {
+		return nil, invalidGrant
+	}
+	code, err := s.AuthorizationCodeStore.Consume(authReq.Code)
+	if err == authorizationcodestore.ErrInvalidCode {
+		return nil, invalidGrant
+	}
+	if err != nil {
+		return nil, newServerError()
+	}
+	if code.ClientID != clientID || code.RedirectURI != authReq.RedirectURI || !code.Verify(authReq.CodeVerifier) {
+		return nil, invalidGrant
+	}
+	return s.issueTokens(r, &tokenGrant{
+		User:     code.User,
+		ClientID: clientID,
+		Scope:    code.Scope,
+		IDToken:  &lib.IDToken{Audience: clientID, Nonce: code.Nonce, AuthTime: code.AuthTime},
+	})
+}
+
+// addIDToken adds an OpenID Connect ID token to the response when the
+// openid scope is granted to a client. The issuer, access token and scope
+// of the ID token are taken from the request and the response.
+func (s *Service) addIDToken(r *http.Request, res *AuthenticateResponse, user *entities.User, idToken *lib.IDToken) *OAuthError {
+	if idToken.Audience == "" || !hasScope(res.Scope, "openid") {
+		return nil
+	}
+	idToken.Issuer = s.issuer()
+	idToken.AccessToken = res.AccessToken
+	idToken.Scope = res.Scope
+	token, err := s.Authenticator.CreateIDToken(user, idToken)
+	if err != nil {
+		return newServerError()
+	}
+	res.IDToken = token
+	return nil
+}
+
+// deviceCodeGrant is polled by a device until the user approves or denies
+// its authorization on the Device page, or the device code expires.
+// Devices that poll faster than the interval get slow_down.
+func (s *Service) deviceCodeGrant(r *http.Request, authReq *AuthenticateRequest, clientID string) (*AuthenticateResponse, *OAuthError) {
+	if clientID == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "client_id is required")
+	}
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "device code is invalid")
+	if s.DeviceCodeStore == nil {
+		return nil, invalidGrant
+	}
+	authorization, err := s.DeviceCodeStore.Poll(authReq.DeviceCode)
+	switch err {
+	case nil:
+	case devicecodestore.ErrAuthorizationPending:
+		return nil, newOAuthError(http.StatusBadRequest, "authorization_pending", "the user has not approved the device yet")
+	case devicecodestore.ErrSlowDown:
+		return nil, newOAuthError(http.StatusBadRequest, "slow_down", "polling interval increased by 5 seconds")
+	case devicecodestore.ErrAccessDenied:
+		return nil, newOAuthError(http.StatusBadRequest, "access_denied", "the user denied the device")
+	case devicecodestore.ErrExpiredToken:
+		return nil, newOAuthError(http.StatusBadRequest, "expired_token", "device code has expired")
+	case devicecodestore.ErrInvalidCode:
+		return nil, invalidGrant
+	default:
+		return nil, newServerError()
+	}
+	if authorization.ClientID != clientID {
+		return nil, invalidGrant
+	}
+	return s.issueTokens(r, &tokenGrant{
+		User:     authorization.User,
+		ClientID: clientID,
+		Scope:    authorization.Scope,
+	})
+}
+
+// clientCredentialsGrant issues a token to a confidential client for itself,
+// for service to service calls. The token carries a service principal instead
+// of an user. The scope defaults to, and can not exceed, the client scope.
+// No refresh token is issued as the client can always authenticate again.
+func (s *Service) clientCredentialsGrant(authReq *AuthenticateRequest, clientID string) (*AuthenticateResponse, *OAuthError) {
+	if clientID == "" {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "client authentication is required")
+	}
+	client, err := s.ClientRegistry.FindByID(clientID)
+	if err != nil {
+		return nil, newServerError()
+	}
+	if client.IsPublic() {
+		return nil, newOAuthError(http.StatusBadRequest, "unauthorized_client", "public clients can not use the client_credentials grant")
+	}
+	scope := client.Scope
+	if authReq.Scope != "" {
+		if !refreshtokenstore.IsScopeSubset(authReq.Scope, client.Scope) {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_scope", "scope exceeds the client scope")
+		}
+		scope = authReq.Scope
+	}
+	token, err := s.Authenticator.CreateServiceToken(&lib.ServicePrincipal{ClientID: client.ID, Scope: scope})
+	if err != nil {
+		return nil, newServerError()
+	}
+	return s.newAuthenticateResponse(token, scope), nil
+}
+
+// checkClientScope returns an invalid_scope error when the scope exceeds
+// the scope registered for the client. Requests that do not name a client
+// can not request any scope.
+func (s *Service) checkClientScope(scope, clientID string) *OAuthError {
+	if scope == "" {
+		return nil
+	}
+	if clientID == "" {
+		return newOAuthError(http.StatusBadRequest, "invalid_scope", "scope requires a client")
+	}
+	if hasScope(scope, "openid") && !s.Authenticator.SignsIDTokens() {
+		return newOAuthError(http.StatusBadRequest, "invalid_scope", "openid requires an asymmetric signing key")
+	}
+	client, err := s.ClientRegistry.FindByID(clientID)
+	if err != nil {
+		return newServerError()
+	}
+	if !refreshtokenstore.IsScopeSubset(scope, client.Scope) {
+		return newOAuthError(http.StatusBadRequest, "invalid_scope", "scope exceeds the client scope")
+	}
+	return nil
+}
+
+func (s *Service) newAuthenticateResponse(token, scope string) *AuthenticateResponse {
+	ttl := s.Authenticator.TTL
+	if ttl <= 0 {
+		ttl = lib.DefaultTTL
+	}
+	return &AuthenticateResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ttl / time.Second),
+		Scope:       scope,
+	}
+}
+
+func scopeClaims(scope string) map[string]interface{} {
+	if scope == "" {
+		return nil
+	}
+	return map[string]interface{}{"scope": scope}
+}
+
+func newServerError() *OAuthError {
+	return newOAuthError(http.StatusInternalServerError, "server_error", "")
+}
+
+// handleTokenError writes the errors of JSON requests in the original format.
+func (s *Service) handleTokenError(oErr *OAuthError, w http.ResponseWriter) {
+	if oErr.status == http.StatusInternalServerError {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	e := codes.NewErr(codes.BadInputData, oErr.Description)
+	w.WriteHeader(oErr.status)
 	json.NewEncoder(w).Encode(e)
 	return
 }
@@ -5,13 +5,21 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 
+	"github.com/clawio/authentication/authenticationcontroller"
+	"github.com/clawio/authentication/clientregistry"
+	clientregistrymemory "github.com/clawio/authentication/clientregistry/memory"
+	"github.com/clawio/authentication/lib"
+	"github.com/clawio/authentication/refreshtokenstore"
+	"github.com/clawio/authentication/refreshtokenstore/memory"
+	"github.com/clawio/entities"
 	"github.com/stretchr/testify/require"
 )
 
 func (suite *TestSuite) TestAuthenticate() {
-	suite.MockAuthenticationController.On("Authenticate").Once().Return("testtoken", nil)
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
 	body := strings.NewReader(`{"username":"test", "password":"test"}`)
 	r, err := http.NewRequest("POST", tokenURL, body)
 	require.Nil(suite.T(), err)
@@ -21,7 +29,15 @@ func (suite *TestSuite) TestAuthenticate() {
 	authNRes := &AuthenticateResponse{}
 	err = json.NewDecoder(w.Body).Decode(authNRes)
 	require.Nil(suite.T(), err)
-	require.Equal(suite.T(), "testtoken", authNRes.AccessToken)
+	user, err := suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+}
+func (suite *TestSuite) TestAuthenticate_withFactorsRequired() {
+	res := &authenticationcontroller.Result{User: &entities.User{Username: "test"}, Factors: []string{"otp"}}
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(res, nil)
+	w := suite.tokenForm(url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
 }
 func (suite *TestSuite) TestAuthenticate_withNilBody() {
 	r, err := http.NewRequest("POST", tokenURL, nil)
@@ -39,7 +55,7 @@ func (suite *TestSuite) TestAuthenticate_withInvalidJSON() {
 	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
 }
 func (suite *TestSuite) TestAuthenticate_withAuthenticationControllerError() {
-	suite.MockAuthenticationController.On("Authenticate").Once().Return("", errors.New("test error"))
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(nil, errors.New("test error"))
 	body := strings.NewReader(`{"username":"test", "password":"test"}`)
 	r, err := http.NewRequest("POST", tokenURL, body)
 	require.Nil(suite.T(), err)
@@ -47,3 +63,208 @@ func (suite *TestSuite) TestAuthenticate_withAuthenticationControllerError() {
 	suite.Server.ServeHTTP(w, r)
 	require.Equal(suite.T(), http.StatusBadRequest, w.Code)
 }
+func (suite *TestSuite) TestAuthenticate_withRefreshToken() {
+	suite.Service.RefreshTokenStore = memory.New(nil)
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	authNRes := suite.token(`{"username":"test", "password":"test"}`, http.StatusOK)
+	require.NotEmpty(suite.T(), authNRes.AccessToken)
+	require.NotEmpty(suite.T(), authNRes.RefreshToken)
+
+	refreshed := suite.token(`{"grant_type":"refresh_token", "refresh_token":"`+authNRes.RefreshToken+`"}`, http.StatusOK)
+	require.NotEmpty(suite.T(), refreshed.AccessToken)
+	require.NotEqual(suite.T(), authNRes.RefreshToken, refreshed.RefreshToken)
+	user, err := suite.Service.Authenticator.CreateUserFromToken(refreshed.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+
+	// reusing a refresh token revokes the tokens issued from it.
+	suite.token(`{"grant_type":"refresh_token", "refresh_token":"`+authNRes.RefreshToken+`"}`, http.StatusBadRequest)
+	suite.token(`{"grant_type":"refresh_token", "refresh_token":"`+refreshed.RefreshToken+`"}`, http.StatusBadRequest)
+}
+func (suite *TestSuite) TestAuthenticate_verifiedByConsumer() {
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	authNRes := suite.token(`{"username":"test", "password":"test"}`, http.StatusOK)
+	// consumers configured with just the secret verify tokens whatever their kid.
+	user, err := lib.NewAuthenticator(lib.DefaultJWTKey, "HS256").CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+}
+func (suite *TestSuite) TestAuthenticate_withRefreshTokensDisabled() {
+	suite.token(`{"grant_type":"refresh_token", "refresh_token":"test"}`, http.StatusBadRequest)
+}
+func (suite *TestSuite) TestAuthenticate_withBadGrantType() {
+	suite.token(`{"grant_type":"notfound"}`, http.StatusBadRequest)
+}
+
+func (suite *TestSuite) token(body string, code int) *AuthenticateResponse {
+	r, err := http.NewRequest("POST", tokenURL, strings.NewReader(body))
+	require.Nil(suite.T(), err)
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	require.Equal(suite.T(), code, w.Code)
+	authNRes := &AuthenticateResponse{}
+	err = json.NewDecoder(w.Body).Decode(authNRes)
+	require.Nil(suite.T(), err)
+	return authNRes
+}
+func (suite *TestSuite) TestAuthenticate_withForm() {
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	w := suite.tokenForm(url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}}, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	require.Equal(suite.T(), "no-store", w.Header().Get("Cache-Control"))
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	user, err := suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "test", user.Username)
+	require.Equal(suite.T(), "Bearer", authNRes.TokenType)
+	require.Equal(suite.T(), int64(3600), authNRes.ExpiresIn)
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndScope() {
+	suite.setupClientRegistry()
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	form := url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}, "scope": {"read write"}}
+	w := suite.tokenForm(form, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Equal(suite.T(), "read write", authNRes.Scope)
+	claims, err := suite.Service.Authenticator.Claims(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "read write", claims["scope"])
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndExcessiveScope() {
+	suite.setupClientRegistry()
+	form := url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}, "scope": {"read admin"}}
+	w := suite.tokenForm(form, "test", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+	// without a client no scope can be requested.
+	form.Set("scope", "read")
+	w = suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+	suite.MockAuthenticationController.AssertNotCalled(suite.T(), "Authenticate")
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndBadCredentials() {
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(nil, errors.New("test error"))
+	w := suite.tokenForm(url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"bad"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndMissingPassword() {
+	w := suite.tokenForm(url.Values{"grant_type": {"password"}, "username": {"test"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_request")
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndMissingGrantType() {
+	w := suite.tokenForm(url.Values{"username": {"test"}, "password": {"test"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_request")
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndBadGrantType() {
+	w := suite.tokenForm(url.Values{"grant_type": {"notfound"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndBadClient() {
+	suite.setupClientRegistry()
+	w := suite.tokenForm(url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}}, "test", "bad")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+	require.NotEmpty(suite.T(), w.Header().Get("WWW-Authenticate"))
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndClientBoundRefreshToken() {
+	suite.setupClientRegistry()
+	suite.Service.RefreshTokenStore = memory.New(nil)
+	suite.MockAuthenticationController.On("Authenticate").Once().Return(&authenticationcontroller.Result{User: &entities.User{Username: "test"}}, nil)
+	form := url.Values{"grant_type": {"password"}, "username": {"test"}, "password": {"test"}, "scope": {"read write"}}
+	w := suite.tokenForm(form, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+
+	// other clients can not use the refresh token.
+	w = suite.tokenForm(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {authNRes.RefreshToken}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_grant")
+	// nor burn it for its client.
+	w = suite.tokenForm(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {authNRes.RefreshToken}}, "test", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+}
+func (suite *TestSuite) TestAuthenticate_withFormAndNarrowedScope() {
+	suite.Service.RefreshTokenStore = memory.New(nil)
+	refreshToken, err := suite.Service.RefreshTokenStore.Issue(&refreshtokenstore.Grant{
+		User:  &entities.User{Username: "test"},
+		Scope: "read write",
+	})
+	require.Nil(suite.T(), err)
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}, "scope": {"read"}}
+	w := suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Equal(suite.T(), "read", authNRes.Scope)
+
+	form = url.Values{"grant_type": {"refresh_token"}, "refresh_token": {authNRes.RefreshToken}, "scope": {"admin"}}
+	w = suite.tokenForm(form, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+	// the refresh token was not exchanged, so the retry is not a reuse.
+	form.Set("scope", "write")
+	w = suite.tokenForm(form, "", "")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+}
+
+func (suite *TestSuite) tokenForm(form url.Values, id, secret string) *httptest.ResponseRecorder {
+	r, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	require.Nil(suite.T(), err)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id != "" {
+		r.SetBasicAuth(id, secret)
+	}
+	w := httptest.NewRecorder()
+	suite.Server.ServeHTTP(w, r)
+	return w
+}
+func (suite *TestSuite) requireOAuthError(w *httptest.ResponseRecorder, code int, oauthCode string) {
+	require.Equal(suite.T(), code, w.Code)
+	e := &OAuthError{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(e))
+	require.Equal(suite.T(), oauthCode, e.Code)
+}
+func (suite *TestSuite) setupServiceClients() {
+	clients := []*clientregistry.Client{
+		{ID: "data", Secret: clientSecretHash, Scope: "metadata:read metadata:write"},
+		{ID: "desktop", RedirectURIs: []string{"http://localhost/callback"}},
+	}
+	clientRegistry, err := clientregistrymemory.New(&clientregistrymemory.Options{Clients: clients})
+	require.Nil(suite.T(), err)
+	suite.Service.ClientRegistry = clientRegistry
+}
+func (suite *TestSuite) TestAuthenticate_withClientCredentials() {
+	suite.setupServiceClients()
+	w := suite.tokenForm(url.Values{"grant_type": {"client_credentials"}}, "data", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+	authNRes := &AuthenticateResponse{}
+	require.Nil(suite.T(), json.NewDecoder(w.Body).Decode(authNRes))
+	require.Equal(suite.T(), "metadata:read metadata:write", authNRes.Scope)
+	require.Empty(suite.T(), authNRes.RefreshToken)
+
+	principal, err := suite.Service.Authenticator.CreateServicePrincipalFromToken(authNRes.AccessToken)
+	require.Nil(suite.T(), err)
+	require.Equal(suite.T(), "data", principal.ClientID)
+	_, err = suite.Service.Authenticator.CreateUserFromToken(authNRes.AccessToken)
+	require.NotNil(suite.T(), err)
+}
+func (suite *TestSuite) TestAuthenticate_withClientCredentialsAndScope() {
+	suite.setupServiceClients()
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"metadata:read"}}
+	w := suite.tokenForm(form, "data", "secret")
+	require.Equal(suite.T(), http.StatusOK, w.Code)
+
+	form.Set("scope", "admin")
+	w = suite.tokenForm(form, "data", "secret")
+	suite.requireOAuthError(w, http.StatusBadRequest, "invalid_scope")
+}
+func (suite *TestSuite) TestAuthenticate_withClientCredentialsAndNoClient() {
+	suite.setupServiceClients()
+	w := suite.tokenForm(url.Values{"grant_type": {"client_credentials"}}, "", "")
+	suite.requireOAuthError(w, http.StatusUnauthorized, "invalid_client")
+}
+func (suite *TestSuite) TestAuthenticate_withClientCredentialsAndPublicClient() {
+	suite.setupServiceClients()
+	w := suite.tokenForm(url.Values{"grant_type": {"client_credentials"}, "client_id": {"desktop"}}, "", "")
+	suite.requireOAuthError(w, http.StatusBadRequest, "unauthorized_client")
+}